@@ -0,0 +1,263 @@
+package uart
+
+// WithSlabAllocator returns a TreeOption that makes
+// the tree carve its leaves, key copies, and nodes
+// from slabs: arrays of many nodes of one kind,
+// allocated together. A big tree is then made of a
+// few thousand slabs rather than millions of small
+// objects, which cuts the cost of allocating them.
+//
+// Nodes that a write frees are kept on free lists,
+// one for each kind of node, and reused by later
+// writes: the node4 that grow replaces with a
+// node16, the node16 that shrink replaces with a
+// node4, and the inner nodes that Remove collapses.
+// Once the tree is in copy-on-write mode, after a
+// Snapshot, or when it has shared its nodes
+// with Union, SplitAt and the like,
+// a freed node may still be in use elsewhere, so
+// nothing is recycled. Nor is anything recycled
+// with WithOptimisticReads, since a reader may
+// still be looking at a freed node.
+//
+// The leaves are never recycled, since Find,
+// Remove, and the iterators hand them out.
+// A Leaf still held by the caller keeps the
+// whole slab it came from alive.
+//
+// Reset empties the tree and drops all of
+// its slabs at once.
+func WithSlabAllocator() TreeOption {
+	return func(t *Tree) {
+		t.slab = &slabs{}
+	}
+}
+
+// The number of nodes of each kind in a slab,
+// and the number of bytes in a key slab. Keys
+// longer than maxSlabKey get their own allocation.
+const (
+	slabLen    = 256
+	bigSlabLen = 16
+	keySlabLen = 64 << 10
+	maxSlabKey = keySlabLen / 16
+)
+
+// slabs is the allocator of WithSlabAllocator.
+// A nil *slabs allocates each node on its own,
+// as a tree without the option does, and
+// recycles nothing. It is used under the
+// tree's write lock.
+type slabs struct {
+	leaves []Leaf
+	bnodes []bnode
+	inners []inner
+	n4     []node4
+	n16    []node16
+	n48    []node48
+	n256   []node256
+	keys   []byte
+
+	// the free lists of recycled nodes.
+	freeB   []*bnode
+	freeIn  []*inner
+	free4   []*node4
+	free16  []*node16
+	free48  []*node48
+	free256 []*node256
+}
+
+// carve returns the next T from *slab, first
+// making a new slab of n of them if it is used up.
+func carve[T any](slab *[]T, n int) *T {
+	if len(*slab) == 0 {
+		*slab = make([]T, n)
+	}
+	p := &(*slab)[0]
+	*slab = (*slab)[1:]
+	return p
+}
+
+// take returns a recycled T from *free if
+// there is one, or else one carved from *slab.
+func take[T any](free *[]*T, slab *[]T, n int) *T {
+	if k := len(*free); k > 0 {
+		p := (*free)[k-1]
+		(*free)[k-1] = nil
+		*free = (*free)[:k-1]
+		return p
+	}
+	return carve(slab, n)
+}
+
+// key returns a copy of k.
+func (a *slabs) key(k Key) Key {
+	if a == nil || len(k) == 0 || len(k) > maxSlabKey {
+		return append(Key{}, k...)
+	}
+	if len(k) > len(a.keys) {
+		a.keys = make([]byte, keySlabLen)
+	}
+	// the cap stops an append to one key
+	// from writing over the next.
+	c := a.keys[:len(k):len(k)]
+	copy(c, k)
+	a.keys = a.keys[len(k):]
+	return c
+}
+
+// leaf returns a new Leaf holding key and v.
+// It does not copy key.
+func (a *slabs) leaf(key Key, v any) *Leaf {
+	if a == nil {
+		return NewLeaf(key, v, nil)
+	}
+	lf := carve(&a.leaves, slabLen)
+	lf.Key = key
+	lf.Value = v
+	return lf
+}
+
+func (a *slabs) bnodeLeaf(lf *Leaf) *bnode {
+	if a == nil {
+		return bnodeLeaf(lf)
+	}
+	b := take(&a.freeB, &a.bnodes, slabLen)
+	b.leaf = lf
+	b.isLeaf = true
+	return b
+}
+
+func (a *slabs) bnodeInner(n *inner) *bnode {
+	if a == nil {
+		return bnodeInner(n)
+	}
+	b := take(&a.freeB, &a.bnodes, slabLen)
+	b.inner = n
+	return b
+}
+
+// inner returns a new, zero inner node.
+func (a *slabs) inner() *inner {
+	if a == nil {
+		return &inner{}
+	}
+	return take(&a.freeIn, &a.inners, slabLen)
+}
+
+func (a *slabs) node4() *node4 {
+	if a == nil {
+		return &node4{}
+	}
+	return take(&a.free4, &a.n4, slabLen)
+}
+
+func (a *slabs) node16() *node16 {
+	if a == nil {
+		return &node16{}
+	}
+	return take(&a.free16, &a.n16, slabLen)
+}
+
+func (a *slabs) node48() *node48 {
+	if a == nil {
+		return &node48{}
+	}
+	return take(&a.free48, &a.n48, bigSlabLen)
+}
+
+func (a *slabs) node256() *node256 {
+	if a == nil {
+		return &node256{}
+	}
+	return take(&a.free256, &a.n256, bigSlabLen)
+}
+
+// newLeaf returns a new leaf holding a copy of
+// key, and value. The caller holds the write lock.
+// With compact leaves, the copy waits until the
+// leaf is stored, when rebase copies just the
+// part of key that the leaf keeps.
+func (t *Tree) newLeaf(key Key, value any) *Leaf {
+	if t.compact {
+		return t.slab.leaf(key, value)
+	}
+	return t.slab.leaf(t.slab.key(key), value)
+}
+
+// recycler returns the allocator that nodes freed
+// by a write may go back to, or nil if they
+// must not be reused; see WithSlabAllocator.
+func (t *Tree) recycler() *slabs {
+	if t.cow || t.olc != nil {
+		return nil
+	}
+	return t.slab
+}
+
+// freeBnode puts b, which nothing points to
+// any more, on its free list.
+func (a *slabs) freeBnode(b *bnode) {
+	if a == nil {
+		return
+	}
+	*b = bnode{}
+	a.freeB = append(a.freeB, b)
+}
+
+// freeInner puts n, and its node4/16/48/256,
+// on their free lists.
+func (a *slabs) freeInner(n *inner) {
+	if a == nil {
+		return
+	}
+	a.freeNode(n.Node)
+	*n = inner{}
+	a.freeIn = append(a.freeIn, n)
+}
+
+// freeNode puts n on the free list for its kind.
+func (a *slabs) freeNode(n inode) {
+	if a == nil {
+		return
+	}
+	switch x := n.(type) {
+	case *node4:
+		*x = node4{}
+		a.free4 = append(a.free4, x)
+	case *node16:
+		*x = node16{}
+		a.free16 = append(a.free16, x)
+	case *node48:
+		*x = node48{}
+		a.free48 = append(a.free48, x)
+	case *node256:
+		*x = node256{}
+		a.free256 = append(a.free256, x)
+	}
+}
+
+// Reset removes every key from the tree. With
+// WithSlabAllocator, the slabs and free lists
+// are dropped too, so the memory of the whole
+// tree can be freed at once, rather than node by
+// node; a Leaf that the caller still holds keeps
+// its own slab alive. Snapshots of the tree are
+// not changed.
+func (t *Tree) Reset() {
+	t.wlock()
+	defer t.wunlock()
+
+	if t.root != nil {
+		if t.watched() {
+			t.noteRange(t.root, t.size, nil, nil, KeyRemoved)
+		}
+		t.root = nil
+		t.size = 0
+		t.atCache = nil
+		t.treeVersion++
+	}
+	if t.slab != nil {
+		t.slab = &slabs{}
+	}
+}
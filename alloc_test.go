@@ -0,0 +1,139 @@
+package uart
+
+import (
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"testing"
+)
+
+func TestSlabAllocator_recycles_and_matches_ordinary_tree(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(18, 18))
+	tree := NewArtTree(WithSlabAllocator())
+	plain := NewArtTree()
+	recycled := map[string]bool{}
+	var snap, snapPlain *Tree
+	for i := range 60000 {
+		k := randKeys(rng, 1, 2, "")[0]
+		// the tree fills up and empties
+		// out again, over and over.
+		removing := (i/5000)%2 == 1
+		switch {
+		case removing && rng.IntN(4) != 0:
+			if lf, ok := plain.At(rng.IntN(max(plain.Size(), 1))); ok {
+				// most random keys are not in the tree.
+				k = lf.Key
+			}
+			d, lf := tree.Remove(k)
+			pd, plf := plain.Remove(k)
+			if d != pd || (d && lf.Value != plf.Value) {
+				t.Fatalf("Remove(%x) = %v, %v; want %v, %v", k, d, lf, pd, plf)
+			}
+		case rng.IntN(8) == 0:
+			tree.LoadOrStore(k, i)
+			plain.LoadOrStore(k, i)
+		default:
+			if u, pu := tree.Insert(k, i), plain.Insert(k, i); u != pu {
+				t.Fatalf("Insert(%x) = %v, want %v", k, u, pu)
+			}
+		}
+		a := tree.slab
+		for name, n := range map[string]int{"bnode": len(a.freeB), "inner": len(a.freeIn),
+			"node4": len(a.free4), "node16": len(a.free16), "node48": len(a.free48), "node256": len(a.free256)} {
+			if n > 0 {
+				recycled[name] = true
+			}
+		}
+		if i%10000 == 0 {
+			checkTree(t, fmt.Sprintf("op %v", i), tree, plain, nil)
+		}
+		if i == 45000 {
+			// from now on, nothing is recycled.
+			snap, snapPlain = tree.Snapshot(), plain.Clone()
+		}
+	}
+	checkTree(t, "end", tree, plain, nil)
+	checkTree(t, "snapshot", snap, snapPlain, nil)
+	if len(recycled) != 6 {
+		t.Fatalf("recycled only %v", recycled)
+	}
+}
+
+func TestSlabAllocator_options(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(18, 19))
+	for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}} {
+		tree := NewArtTree(append(opts, WithSlabAllocator())...)
+		plain := NewArtTree()
+		for i := range 20000 {
+			k := randKeys(rng, 1, 2, "")[0]
+			if rng.IntN(3) == 0 {
+				tree.Remove(k)
+				plain.Remove(k)
+			} else {
+				tree.Insert(k, i)
+				plain.Insert(k, i)
+			}
+		}
+		checkTree(t, "tree", tree, plain, nil)
+		l, r := tree.SplitAt(Key{128})
+		if l.slab == nil || r.slab == nil {
+			t.Fatalf("SplitAt lost the allocator")
+		}
+		j, _ := Join(l, r)
+		checkTree(t, "Join", j, plain, nil)
+	}
+}
+
+func TestReset(t *testing.T) {
+	for _, opts := range [][]TreeOption{nil, {WithSlabAllocator()}} {
+		tree := NewArtTree(opts...)
+		tree.Insert(Key("a"), 1)
+		tree.Insert(Key("b"), 2)
+		snap := tree.Snapshot()
+		tree.Insert(Key("c"), 3)
+		lf, _, _ := tree.Find(Exact, Key("a"))
+		w := tree.Watch(nil, nil)
+
+		slab := tree.slab
+		tree.Reset()
+		if tree.Size() != 0 || !tree.IsEmpty() {
+			t.Fatalf("Size %v after Reset", tree.Size())
+		}
+		if slab != nil && tree.slab == slab {
+			t.Fatalf("Reset kept the slabs")
+		}
+		for _, s := range []string{"a", "b", "c"} {
+			if ev := nextEvent(t, w); ev.Kind != KeyRemoved || string(ev.Key) != s {
+				t.Fatalf("got %v %s, want KeyRemoved %v", ev.Kind, ev.Key, s)
+			}
+		}
+		w.Close()
+		if snap.Size() != 2 || string(lf.Key) != "a" || lf.Value != 1 {
+			t.Fatalf("Reset changed a snapshot, or a leaf")
+		}
+		tree.Insert(Key("d"), 4)
+		if v, _, ok := tree.FindExact(Key("d")); !ok || v != 4 || tree.Size() != 1 {
+			t.Fatalf("insert after Reset: %v, %v", v, ok)
+		}
+	}
+}
+
+// The allocations of filling a tree with the
+// words, with and without the slab allocator.
+func BenchmarkSlabAllocator(b *testing.B) {
+	words := loadTestFile("assets/words.txt")
+	for _, slabs := range []bool{false, true} {
+		b.Run(fmt.Sprintf("slabs_%v", slabs), func(b *testing.B) {
+			b.ReportAllocs()
+			var opts []TreeOption
+			if slabs {
+				opts = append(opts, WithSlabAllocator())
+			}
+			for range b.N {
+				tree := NewArtTree(opts...)
+				for _, w := range words {
+					tree.Insert(w, nil)
+				}
+			}
+		})
+	}
+}
@@ -705,7 +705,13 @@ func verifyLeafIndexAt(tree *Tree) {
 		if idx2 != j {
 			panic("idx2 != j")
 		}
-		if lf2 != lf {
+		if tree.compact {
+			// a compact tree hands out copies
+			// of its leaves, so compare them.
+			if !bytes.Equal(lf2.Key, lf.Key) || lf2.Value != lf.Value {
+				panic(fmt.Sprintf("lf2 != lf. lf2 = '%v';\n\n lf = '%v'", lf2, lf))
+			}
+		} else if lf2 != lf {
 			panic(fmt.Sprintf("lf2 != lf. lf2 = '%v';\n\n lf = '%v'", lf2, lf))
 		}
 	}
@@ -0,0 +1,132 @@
+package uart
+
+// WriteBatch collects writes to be made to a
+// Tree together, by Tree.Apply. The zero
+// WriteBatch is empty and ready to use.
+// A WriteBatch is not safe for concurrent use.
+type WriteBatch struct {
+	ops []batchOp
+}
+
+type batchOpKind int
+
+const (
+	batchPut batchOpKind = iota
+	batchDelete
+	batchDeleteRange
+)
+
+type batchOp struct {
+	kind  batchOpKind
+	key   Key // the start, for batchDeleteRange
+	end   Key
+	value any
+}
+
+// BatchResult is the outcome of one
+// operation of a WriteBatch.
+type BatchResult struct {
+	// Existed reports whether the key of a Put
+	// or Delete was in the tree just before the
+	// operation was made.
+	Existed bool
+
+	// Prev is the value the key had then, if it Existed.
+	Prev any
+
+	// N is the number of keys a DeleteRange removed.
+	N int
+}
+
+// Put adds an Insert of key and value to the batch.
+// Like Insert, it makes a copy of key.
+func (b *WriteBatch) Put(key Key, value any) {
+	b.ops = append(b.ops, batchOp{
+		kind:  batchPut,
+		key:   append(Key{}, key...),
+		value: value,
+	})
+}
+
+// Delete adds a Remove of key to the batch.
+func (b *WriteBatch) Delete(key Key) {
+	b.ops = append(b.ops, batchOp{
+		kind: batchDelete,
+		key:  append(Key{}, key...),
+	})
+}
+
+// DeleteRange adds a DeleteRange(start, end)
+// to the batch. As for Tree.DeleteRange,
+// a nil start or end is unbounded.
+func (b *WriteBatch) DeleteRange(start, end Key) {
+	op := batchOp{kind: batchDeleteRange}
+	if start != nil {
+		op.key = append(Key{}, start...)
+	}
+	if end != nil {
+		op.end = append(Key{}, end...)
+	}
+	b.ops = append(b.ops, op)
+}
+
+// Len returns the number of operations in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch, so it can be reused.
+func (b *WriteBatch) Reset() {
+	clear(b.ops)
+	b.ops = b.ops[:0]
+}
+
+// Apply makes the writes of the batch b, in the
+// order they were added, as one write: the tree is
+// locked once, and its version is bumped once.
+// So no reader, nor any Snapshot, sees part
+// of the batch, and
+// open iterators re-seek once, not once per write.
+// Watchers are told of each change, in order,
+// once the whole batch is applied.
+//
+// Apply returns a BatchResult for each operation,
+// in order. The batch is not changed, and can be
+// applied again, or Reset and reused.
+func (t *Tree) Apply(b *WriteBatch) []BatchResult {
+	res := make([]BatchResult, len(b.ops))
+
+	t.wlock()
+	defer t.wunlock()
+
+	changed := false
+	for i, op := range b.ops {
+		r := &res[i]
+		switch op.kind {
+		case batchPut:
+			// one descent finds the old value and
+			// stores the new. Each apply needs its
+			// own leaf, as the tree holds on to it.
+			t.upsert_unlocked(&Leaf{Key: op.key}, &upsert{decide: func(old *Leaf) (*Leaf, bool) {
+				if old != nil {
+					r.Existed, r.Prev = true, old.Value
+				}
+				return t.newLeaf(op.key, op.value), false
+			}})
+			changed = true
+		case batchDelete:
+			deleted, lf := t.remove_unlocked(op.key)
+			if deleted {
+				r.Existed, r.Prev = true, lf.Value
+				changed = true
+			}
+		case batchDeleteRange:
+			r.N = t.deleteRange_unlocked(op.key, op.end)
+			changed = changed || r.N > 0
+		}
+	}
+	if changed {
+		t.treeVersion++
+	}
+	return res
+}
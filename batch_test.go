@@ -0,0 +1,136 @@
+package uart
+
+import (
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestApply_matches_map(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(13, 13))
+	for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}, {WithBuckets(4)}} {
+		tree := NewArtTree(opts...)
+		ref := make(map[string]int)
+		var b WriteBatch
+		for trial := range 200 {
+			b.Reset()
+			var want []BatchResult
+			for range rng.IntN(20) {
+				k := fmt.Sprintf("%02d", rng.IntN(60))
+				prev, existed := ref[k]
+				var r BatchResult
+				if existed {
+					r = BatchResult{Existed: true, Prev: prev}
+				}
+				switch rng.IntN(6) {
+				case 0:
+					b.Delete(Key(k))
+					delete(ref, k)
+				case 1:
+					end := fmt.Sprintf("%02d", rng.IntN(60))
+					b.DeleteRange(Key(k), Key(end))
+					r = BatchResult{}
+					for rk := range ref {
+						if rk >= k && rk < end {
+							delete(ref, rk)
+							r.N++
+						}
+					}
+				default:
+					b.Put(Key(k), trial)
+					ref[k] = trial
+				}
+				want = append(want, r)
+			}
+			v := tree.treeVersion
+			got := tree.Apply(&b)
+			if len(got) != b.Len() {
+				t.Fatalf("%v results for %v ops", len(got), b.Len())
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("trial %v op %v: got %+v, want %+v", trial, i, got[i], want[i])
+				}
+			}
+			if tree.treeVersion > v+1 {
+				t.Fatalf("version bumped %v times", tree.treeVersion-v)
+			}
+			checkTree(t, "after Apply", tree, treeOf(ref), nil)
+		}
+	}
+}
+
+func TestApply_iterator_and_watch(t *testing.T) {
+	tree := NewArtTree()
+	for i := range 10 {
+		tree.Insert(Key(fmt.Sprint(i)), i)
+	}
+	w := tree.Watch(nil, nil)
+	defer w.Close()
+
+	var b WriteBatch
+	b.Put(Key("1"), 100)
+	b.Delete(Key("2"))
+	b.DeleteRange(Key("5"), Key("8"))
+	b.Put(Key("99"), 99)
+
+	var keys []string
+	for key := range Ascend(tree, nil, nil) {
+		keys = append(keys, string(key))
+		if string(key) == "0" {
+			tree.Apply(&b)
+		}
+	}
+	if got := fmt.Sprint(keys); got != "[0 1 3 4 8 9 99]" {
+		t.Fatalf("iterated %v", got)
+	}
+
+	for _, s := range []string{
+		"KeyUpdated 1 100", "KeyRemoved 2 2",
+		"KeyRemoved 5 5", "KeyRemoved 6 6", "KeyRemoved 7 7",
+		"KeyInserted 99 99",
+	} {
+		ev := nextEvent(t, w)
+		if got := fmt.Sprintf("%v %s %v", ev.Kind, ev.Key, ev.Value); got != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+}
+
+// run with -race. Each batch moves a unit between two
+// keys, so a reader that saw half a batch would see
+// the total change.
+func TestApply_snapshot_readers_see_whole_batches(t *testing.T) {
+	tree := NewArtTree()
+	tree.Insert(Key("a"), 100)
+	tree.Insert(Key("b"), 0)
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				total := 0
+				for _, lf := range Ascend(tree.Snapshot(), nil, nil) {
+					total += lf.(*Leaf).Value.(int)
+				}
+				if total != 100 {
+					stop.Store(true)
+					panic(fmt.Sprintf("saw total %v", total))
+				}
+			}
+		}()
+	}
+	for i := range 2000 {
+		var b WriteBatch
+		b.Put(Key("a"), 100-i%100)
+		b.Put(Key("b"), i%100)
+		tree.Apply(&b)
+	}
+	stop.Store(true)
+	wg.Wait()
+}
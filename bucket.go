@@ -0,0 +1,532 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// DefaultBucketSize is the bucket size that
+// WithBuckets uses when given a size below 2.
+const DefaultBucketSize = 64
+
+// WithBuckets returns a TreeOption that stores the
+// small subtrees at the bottom of the tree as buckets:
+// sorted arrays of up to size leaves, as in a burst
+// trie or HAT-trie, or the leaves of a B-tree.
+//
+// In an ordinary tree, every key has a bnode of its
+// own in its parent, and the inner nodes nearest the
+// leaves often hold only a few keys each. With buckets,
+// two keys that meet under the same keybyte share a
+// bucket instead, and later keys join it in key order.
+// A bucket that grows past size keys bursts into an
+// ordinary inner node, its keys split by their first
+// differing byte into smaller buckets. An inner node
+// that Remove leaves with size/2 keys or fewer
+// collapses back into a bucket.
+//
+// A bucket counts its keys in SubN like any other
+// subtree, and is searched by binary search, so
+// Find, At, and LeafIndex still take O(log N) time.
+// The iterators walk the leaves of a bucket in place,
+// one after the other, rather than going down a node
+// for each of them. The leaves themselves are the same
+// as in an ordinary tree, and Find and the iterators
+// hand them out as they are.
+//
+// Union, Intersect, Difference, and Join of a tree
+// with buckets build a new tree from the merged
+// leaves, rather than sharing subtrees, as
+// for WithCompactLeaves. A tree cannot have both
+// buckets and compact leaves; NewArtTree panics.
+func WithBuckets(size int) TreeOption {
+	if size < 2 {
+		size = DefaultBucketSize
+	}
+	return func(t *Tree) {
+		t.buckets = size
+	}
+}
+
+// bucket is the inode of an inner node that holds
+// its subtree as the sorted array of its leaves.
+// The leaves hold their whole keys, so a bucket
+// has no compressed prefix and no keybytes, and its
+// inner's compressed is always nil. The keybyte
+// methods of inode must not be called on a bucket;
+// every walk of the tree checks for buckets first.
+type bucket struct {
+	leaves []*Leaf
+}
+
+// asBucket returns n's bucket, if n holds one.
+func (n *inner) asBucket() (bk *bucket, ok bool) {
+	bk, ok = n.Node.(*bucket)
+	return
+}
+
+// search returns the index of the first leaf
+// in bk whose key is >= key, and whether
+// that leaf's key is key.
+func (bk *bucket) search(key Key) (i int, found bool) {
+	return slices.BinarySearchFunc(bk.leaves, key, func(lf *Leaf, key Key) int {
+		return bytes.Compare(lf.Key, key)
+	})
+}
+
+// newBucket returns a new inner node of generation
+// gen, whose bucket is leaves. The leaves must be
+// sorted, and the slice not shared.
+func newBucket(a *slabs, leaves []*Leaf, keybyte byte, gen uint64) *inner {
+	n := a.inner()
+	*n = inner{
+		Node:    &bucket{leaves: leaves},
+		SubN:    len(leaves),
+		prenOK:  true,
+		keybyte: keybyte,
+		gen:     gen,
+	}
+	return n
+}
+
+// insertBucket does insert for n, which holds bk, and
+// which the caller has already made its own. If u has
+// the key removed, the replacement returned may be a
+// leaf, as for delBucket.
+func (n *inner) insertBucket(bk *bucket, lf *Leaf, depth int, selfb *bnode, tree *Tree, u *upsert) (replacement *bnode, updated bool) {
+	i, found := bk.search(lf.Key)
+	if found {
+		store, del := u.at(bk.leaves[i], lf)
+		switch {
+		case del:
+			replacement = selfb
+			u.removed = n.delBucketAt(bk, i, selfb, tree, func(b *bnode) {
+				replacement = b
+			})
+			return replacement, true
+		case store != nil:
+			bk.leaves[i] = store
+		}
+		return selfb, true
+	}
+	if lf, _ = u.at(nil, lf); lf == nil {
+		return selfb, true
+	}
+	bk.leaves = slices.Insert(bk.leaves, i, lf)
+	n.SubN++
+	if len(bk.leaves) > tree.buckets {
+		selfb.inner = n.burst(depth, tree)
+		tree.recycler().freeInner(n)
+	}
+	return selfb, false
+}
+
+// burst returns an ordinary inner node to replace n, a
+// bucket grown too big, whose keys all share their first
+// depth bytes. The keys are split by the byte after
+// the prefix they all share: each group of them
+// goes into a bucket, or a leaf if it is alone.
+func (n *inner) burst(depth int, tree *Tree) *inner {
+	leaves := n.Node.(*bucket).leaves
+	first := leaves[0].Key
+	last := leaves[len(leaves)-1].Key
+	pos := depth + comparePrefix(first, last, depth)
+	var keys []byte
+	var kids []*bnode
+	for beg := 0; beg < len(leaves); {
+		kb := leaves[beg].Key.At(pos)
+		end := beg + 1
+		for end < len(leaves) && leaves[end].Key.At(pos) == kb {
+			end++
+		}
+		var ch *bnode
+		if end-beg == 1 {
+			ch = tree.slab.bnodeLeaf(leaves[beg])
+		} else {
+			ch = tree.slab.bnodeInner(newBucket(tree.slab, slices.Clone(leaves[beg:end]), kb, tree.gen))
+		}
+		keys = append(keys, kb)
+		kids = append(kids, ch)
+		beg = end
+	}
+	var compressed []byte
+	if pos > depth {
+		compressed = append([]byte{}, first[depth:pos]...)
+	}
+	b := innerFrom(compressed, n.keybyte, keys, kids)
+	b.inner.gen = tree.gen
+	return b.inner
+}
+
+// delBucket does del for n, which holds bk. A bucket
+// left with one key gives way to a leaf.
+func (n *inner) delBucket(bk *bucket, key Key, selfb *bnode, tree *Tree, parentUpdate func(*bnode)) (deleted bool, deletedNode *bnode) {
+	i, found := bk.search(key)
+	if !found {
+		return false, nil
+	}
+	if tree.cow && n.gen != tree.gen {
+		n = n.cowClone(tree.gen)
+		selfb.inner = n
+		bk = n.Node.(*bucket)
+	}
+	return true, n.delBucketAt(bk, i, selfb, tree, parentUpdate)
+}
+
+// delBucketAt removes the i-th leaf of bk, which n
+// holds, and which n's caller has made its own. A
+// bucket left with one key gives way to a leaf, by
+// way of parentUpdate. It returns the leaf's bnode.
+func (n *inner) delBucketAt(bk *bucket, i int, selfb *bnode, tree *Tree, parentUpdate func(*bnode)) (deletedNode *bnode) {
+	lf := bk.leaves[i]
+	bk.leaves = slices.Delete(bk.leaves, i, i+1)
+	n.SubN--
+	if len(bk.leaves) == 1 {
+		parentUpdate(tree.slab.bnodeLeaf(bk.leaves[0]))
+		if a := tree.recycler(); a != nil {
+			a.freeInner(n)
+			a.freeBnode(selfb)
+		}
+	}
+	return tree.slab.bnodeLeaf(lf)
+}
+
+// collapse turns n, an ordinary inner node of the
+// caller's own, into a bucket of all the leaves
+// under it. n's children are left as they were.
+func (n *inner) collapse() {
+	leaves := make([]*Leaf, 0, n.SubN)
+	for _, ch := range n.kids() {
+		leaves = appendLeaves(leaves, ch)
+	}
+	n.Node = &bucket{leaves: leaves}
+	n.compressed = nil
+	n.prenOK = true
+}
+
+// collapseSmall collapses n, which a Remove has just
+// left with one key fewer, into a bucket if it has
+// no more than half a bucket's worth of keys.
+func (t *Tree) collapseSmall(n *inner) {
+	if t.buckets > 0 && n.SubN <= t.buckets/2 {
+		n.collapse()
+	}
+}
+
+// appendLeaves appends the leaves under b to
+// dst, in key order.
+func appendLeaves(dst []*Leaf, b *bnode) []*Leaf {
+	if b.isLeaf {
+		return append(dst, b.leaf)
+	}
+	if bk, ok := b.inner.asBucket(); ok {
+		return append(dst, bk.leaves...)
+	}
+	for _, ch := range b.inner.kids() {
+		dst = appendLeaves(dst, ch)
+	}
+	return dst
+}
+
+// bucketLeaves turns each biggest subtree under b
+// that has no more than size keys, but more than
+// one, into a bucket. b must be newly built, since
+// its inner nodes are changed in place.
+func bucketLeaves(b *bnode, size int) {
+	if b == nil || b.isLeaf {
+		return
+	}
+	n := b.inner
+	if _, ok := n.asBucket(); ok {
+		return
+	}
+	if n.SubN <= size {
+		n.collapse()
+		return
+	}
+	for _, ch := range n.kids() {
+		bucketLeaves(ch, size)
+	}
+}
+
+// rankFrame is an inner node that locate went
+// through, with the keybyte it took there, or the
+// one it found missing, at byte pos of the key.
+type rankFrame struct {
+	n   *inner
+	kb  byte
+	pos int
+}
+
+// locate returns the number of keys under a that
+// sort before key, and whether key is there. It goes
+// down the tree once, adding up the SubN counts of
+// the subtrees it passes by, and finishes with a
+// binary search if it ends in a bucket. The leaves
+// may be compact.
+//
+// It also returns the leaf that a Find with smod
+// gives, if there is one, with its whole key: the
+// leaf holding key, or the one next to key below or
+// above it. The latter is found from the nodes the
+// descent went through, under the nearest of them
+// with a child on that side of the path taken.
+func (a *bnode) locate(key Key, smod SearchModifier) (before int, eq bool, lf *Leaf) {
+	// the side of key to look on, if the
+	// leaf holding key is not the one wanted.
+	below := smod == LTE || smod == LT
+	above := smod == GTE || smod == GT
+	takeEq := smod == Exact || smod == GTE || smod == LTE
+
+	var stack [32]rankFrame
+	frames := stack[:0]
+	b := a
+	depth := 0
+descent:
+	for {
+		if b.isLeaf {
+			switch c := b.leaf.compare(key, 0); {
+			case c > 0:
+				before++
+				if below {
+					return before, false, b.leaf.full(key)
+				}
+			case c < 0:
+				if above {
+					return before, false, b.leaf.full(key)
+				}
+			default:
+				eq = true
+				if takeEq {
+					return before, true, b.leaf.full(key)
+				}
+			}
+			break descent
+		}
+		n := b.inner
+		if bk, ok := n.asBucket(); ok {
+			i, found := bk.search(key)
+			before += i
+			eq = found
+			j := i
+			switch {
+			case found && takeEq:
+				return before, true, bk.leaves[i]
+			case smod == GT && found:
+				j = i + 1
+			case below:
+				j = i - 1
+			}
+			if (below || above) && j >= 0 && j < len(bk.leaves) {
+				return before, eq, bk.leaves[j]
+			}
+			break descent
+		}
+		c := n.compressed
+		part := key[min(depth, len(key)):min(depth+len(c), len(key))]
+		if cmp := bytes.Compare(part, c); cmp != 0 {
+			// a key that stops short of the prefix, or
+			// differs from it, sorts before (or after)
+			// the whole subtree.
+			if cmp > 0 {
+				before += n.SubN
+				if below {
+					return before, false, b.edgeLeaf(keyPath(key, depth), true)
+				}
+			} else if above {
+				return before, false, b.edgeLeaf(keyPath(key, depth), false)
+			}
+			break descent
+		}
+		if !n.prenOK {
+			b.subTreeRedoPren()
+		}
+		pos := depth + len(c)
+		kb := key.At(pos)
+		if below || above {
+			frames = append(frames, rankFrame{n: n, kb: kb, pos: pos})
+		}
+		if _, ch := n.Node.child(kb); ch != nil {
+			// the usual case, and a quicker lookup
+			// than gte in a node48 or node256.
+			before += ch.pren
+			b = ch
+			depth = pos + 1
+			continue
+		}
+		// a copy, so that kb itself does not
+		// escape to the heap on every level.
+		k := kb
+		if _, ch := n.Node.gte(&k); ch != nil {
+			before += ch.pren
+		} else {
+			before += n.SubN
+		}
+		break
+	}
+	if len(frames) == 0 {
+		return before, eq, nil
+	}
+	// p is the path to the child of each frame in
+	// turn. Its last byte, rather than a variable of
+	// its own that would escape, is given to lt or gt.
+	p := keyPath(key, frames[len(frames)-1].pos+1)
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		p = p[:f.pos+1]
+		p[f.pos] = f.kb
+		var ch *bnode
+		if below {
+			p[f.pos], ch = f.n.Node.lt(&p[f.pos])
+		} else {
+			p[f.pos], ch = f.n.Node.gt(&p[f.pos])
+		}
+		if ch != nil {
+			return before, eq, ch.edgeLeaf(p, below)
+		}
+	}
+	return before, eq, nil
+}
+
+// keyPath returns a copy of the first n bytes of
+// key, with 0 for those past its end, as Key.At
+// has them, and room to append a neighbour of
+// key to it, as long as key and then some.
+func keyPath(key Key, n int) Key {
+	p := make(Key, n, max(n, len(key))+32)
+	copy(p, key)
+	return p
+}
+
+// findByRank is find_unlocked for a tree with compact
+// leaves or buckets. It counts the keys before key,
+// and finds the leaf wanted, in one descent; see
+// locate. The leaf found is given its whole key.
+func (t *Tree) findByRank(smod SearchModifier, key Key) (lf *Leaf, idx int, found bool) {
+	if t.root == nil {
+		return
+	}
+	if len(key) == 0 && (smod != Exact || t.size == 1) {
+		// asks for the first leaf, or the last; in a
+		// tree of one key, as find_unlocked has it,
+		// even an Exact search finds that key.
+		last := smod == LTE || smod == LT
+		if last {
+			idx = t.root.subn() - 1
+		}
+		return t.root.edgeLeaf(nil, last), idx, true
+	}
+	before, eq, lf := t.root.locate(key, smod)
+	return rankResult(smod, before, eq, lf)
+}
+
+// rankResult turns what locate found for smod
+// into the results of Find.
+func rankResult(smod SearchModifier, before int, eq bool, lf *Leaf) (_ *Leaf, idx int, found bool) {
+	switch smod {
+	case GTE:
+		idx = before
+	case GT:
+		idx = before
+		if eq {
+			idx++
+		}
+	case LTE:
+		idx = before - 1
+		if eq {
+			idx++
+		}
+	case LT:
+		idx = before - 1
+	default:
+		return lf, before, eq
+	}
+	return lf, idx, lf != nil
+}
+
+// The inode methods. A bucket has no keybytes,
+// so those methods are never called.
+
+func (bk *bucket) noKeybytes() {
+	panic("uart: keybyte method called on a bucket")
+}
+
+func (bk *bucket) redoPren() {}
+
+func (bk *bucket) first() (byte, *bnode) {
+	bk.noKeybytes()
+	return 0, nil
+}
+
+func (bk *bucket) last() (byte, *bnode) {
+	bk.noKeybytes()
+	return 0, nil
+}
+
+func (bk *bucket) gt(*byte) (byte, *bnode) {
+	bk.noKeybytes()
+	return 0, nil
+}
+
+func (bk *bucket) gte(*byte) (byte, *bnode) {
+	bk.noKeybytes()
+	return 0, nil
+}
+
+func (bk *bucket) lt(*byte) (byte, *bnode) {
+	bk.noKeybytes()
+	return 0, nil
+}
+
+func (bk *bucket) lte(*byte) (byte, *bnode) {
+	bk.noKeybytes()
+	return 0, nil
+}
+
+func (bk *bucket) next(*byte) (byte, *bnode) {
+	bk.noKeybytes()
+	return 0, nil
+}
+
+func (bk *bucket) prev(*byte) (byte, *bnode) {
+	bk.noKeybytes()
+	return 0, nil
+}
+
+func (bk *bucket) child(byte) (int, *bnode) {
+	bk.noKeybytes()
+	return 0, nil
+}
+
+func (bk *bucket) addChild(byte, *bnode) {
+	bk.noKeybytes()
+}
+
+func (bk *bucket) replace(int, *bnode, bool) *bnode {
+	bk.noKeybytes()
+	return nil
+}
+
+func (bk *bucket) nchild() int {
+	return len(bk.leaves)
+}
+
+func (bk *bucket) childkeysString() string {
+	return fmt.Sprintf("[bucket of %v]", len(bk.leaves))
+}
+
+func (bk *bucket) kind() kind {
+	return _Bucket
+}
+
+// a bucket never grows or shrinks; it bursts
+// or collapses instead.
+func (bk *bucket) full() bool            { return false }
+func (bk *bucket) min() bool             { return false }
+func (bk *bucket) grow(a *slabs) inode   { return nil }
+func (bk *bucket) shrink(a *slabs) inode { return nil }
+
+func (bk *bucket) String() string {
+	return bk.childkeysString()
+}
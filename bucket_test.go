@@ -0,0 +1,301 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"testing"
+)
+
+// checkBuckets fails unless the buckets under b
+// are well formed: sorted, with 2 to size leaves,
+// no compressed prefix, and the keybyte their
+// parent has them under. It returns how
+// many buckets there are.
+func checkBuckets(t *testing.T, what string, b *bnode, size int) (n int) {
+	t.Helper()
+	if b == nil || b.isLeaf {
+		return 0
+	}
+	bk, ok := b.inner.asBucket()
+	if !ok {
+		for kb, ch := range b.inner.kids() {
+			if !ch.isLeaf && ch.inner.keybyte != kb {
+				t.Fatalf("%v: %v under keybyte %v has keybyte %v", what, ch.inner.kind(), kb, ch.inner.keybyte)
+			}
+			n += checkBuckets(t, what, ch, size)
+		}
+		return n
+	}
+	if len(bk.leaves) < 2 || len(bk.leaves) > size || b.inner.compressed != nil {
+		t.Fatalf("%v: bucket of %v leaves, compressed %q", what, len(bk.leaves), b.inner.compressed)
+	}
+	for i := 1; i < len(bk.leaves); i++ {
+		if bytes.Compare(bk.leaves[i-1].Key, bk.leaves[i].Key) >= 0 {
+			t.Fatalf("%v: bucket out of order at %v", what, i)
+		}
+	}
+	return 1
+}
+
+// checkLeafIndex fails unless LeafIndex finds
+// each leaf of tree at its index.
+func checkLeafIndex(t *testing.T, what string, tree *Tree) {
+	t.Helper()
+	for i := range tree.Size() {
+		lf, _ := tree.At(i)
+		if idx, ok := tree.LeafIndex(lf); !ok || idx != i {
+			t.Fatalf("%v: LeafIndex(%q) = %v, %v; want %v", what, lf.Key, idx, ok, i)
+		}
+	}
+}
+
+func TestBuckets_match_ordinary_tree(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(20, 20))
+	keys := randKeys(rng, 400, 8, "abc/")
+	probes := append(randKeys(rng, 50, 8, "abc/"), Key{}, nil)
+
+	for _, opts := range [][]TreeOption{nil, {WithSlabAllocator()}} {
+		b := NewArtTree(append(opts, WithBuckets(4))...)
+		plain := NewArtTree()
+		var snap, snapPlain *Tree
+		for i := range 4000 {
+			k := keys[rng.IntN(len(keys))]
+			switch rng.IntN(10) {
+			case 0, 1:
+				d, lf := b.Remove(k)
+				pd, plf := plain.Remove(k)
+				if d != pd || (d && (!bytes.Equal(lf.Key, plf.Key) || lf.Value != plf.Value)) {
+					t.Fatalf("Remove(%q) = %v, %v; want %v, %v", k, d, lf, pd, plf)
+				}
+			case 2:
+				end := keys[rng.IntN(len(keys))]
+				if n, pn := b.DeleteRange(k, end), plain.DeleteRange(k, end); n != pn {
+					t.Fatalf("DeleteRange(%q, %q) = %v, want %v", k, end, n, pn)
+				}
+			case 3:
+				b.LoadOrStore(k, uint64(i))
+				plain.LoadOrStore(k, uint64(i))
+			default:
+				if u, pu := b.Insert(k, uint64(i)), plain.Insert(k, uint64(i)); u != pu {
+					t.Fatalf("Insert(%q) = %v, want %v", k, u, pu)
+				}
+			}
+			if i == 1000 {
+				snap, snapPlain = b.Snapshot(), plain.Clone()
+			}
+			if i%500 == 0 {
+				what := fmt.Sprintf("op %v", i)
+				checkTree(t, what, b, plain, probes)
+				checkBuckets(t, what, b.root, 4)
+				checkLeafIndex(t, what, b)
+			}
+		}
+		checkTree(t, "after ops", b, plain, probes)
+		checkTree(t, "snapshot", snap, snapPlain, probes)
+		checkBuckets(t, "snapshot", snap.root, 4)
+		clone := b.Clone()
+		checkTree(t, "clone", clone, plain, probes)
+		if checkBuckets(t, "clone", clone.root, 4) == 0 {
+			t.Fatalf("the clone has no buckets")
+		}
+
+		var buf bytes.Buffer
+		b.ValueCodec = uint64Codec{}
+		if _, err := b.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		// the snapshot holds ordinary nodes,
+		// which either kind of tree can read.
+		snapshot := buf.Bytes()
+		for _, back := range []*Tree{NewArtTree(WithBuckets(4)), NewArtTree()} {
+			back.ValueCodec = uint64Codec{}
+			if _, err := back.ReadFrom(bytes.NewReader(snapshot)); err != nil {
+				t.Fatal(err)
+			}
+			checkTree(t, "ReadFrom", back, plain, probes)
+			if n := checkBuckets(t, "ReadFrom", back.root, 4); (n > 0) != (back.buckets > 0) {
+				t.Fatalf("ReadFrom made %v buckets in a tree with bucket size %v", n, back.buckets)
+			}
+		}
+	}
+}
+
+// Buckets burst as they fill, and inner
+// nodes collapse back as they empty.
+func TestBuckets_burst_and_collapse(t *testing.T) {
+	words := loadTestFile("assets/words.txt")[:1000]
+	rng := mathrand2.New(mathrand2.NewPCG(20, 22))
+	tree := NewArtTree(WithBuckets(8))
+	for _, i := range rng.Perm(len(words)) {
+		tree.Insert(words[i], i)
+	}
+	if n := checkBuckets(t, "full", tree.root, 8); n == 0 {
+		t.Fatalf("no buckets for %v keys", len(words))
+	}
+	if _, ok := tree.root.inner.asBucket(); ok {
+		t.Fatalf("the root never burst")
+	}
+	checkLeafIndex(t, "full", tree)
+
+	for _, w := range words[:996] {
+		tree.Remove(w)
+	}
+	if bk, ok := tree.root.inner.asBucket(); !ok || len(bk.leaves) != 4 {
+		t.Fatalf("4 keys left, but the root is %v", tree.root.inner.kind())
+	}
+	for _, w := range words[996:999] {
+		tree.Remove(w)
+	}
+	if !tree.root.isLeaf || !bytes.Equal(tree.root.leaf.Key, words[999]) {
+		t.Fatalf("1 key left, but the root is %v", tree.root)
+	}
+}
+
+func TestBuckets_split_join_and_set_ops(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(20, 21))
+	keys := randKeys(rng, 600, 8, "abc/")
+	probes := randKeys(rng, 30, 8, "abc/")
+	fill := func(tree *Tree, lo, hi int) {
+		for i := lo; i < hi; i++ {
+			tree.Insert(keys[i], i)
+		}
+	}
+	for trial := range 20 {
+		a, pa := NewArtTree(WithBuckets(4)), NewArtTree()
+		b, pb := NewArtTree(WithBuckets(4)), NewArtTree()
+		fill(a, 0, 400)
+		fill(pa, 0, 400)
+		fill(b, 200, 600)
+		fill(pb, 200, 600)
+
+		at := probes[trial]
+		l, r := a.SplitAt(at)
+		pl, pr := pa.SplitAt(at)
+		checkTree(t, "left", l, pl, probes)
+		checkTree(t, "right", r, pr, probes)
+		j, err := Join(l, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTree(t, "Join", j, pa, probes)
+		checkBuckets(t, "Join", j.root, 4)
+		l, r = a.SplitIndex(trial * 10)
+		pl, pr = pa.SplitIndex(trial * 10)
+		checkTree(t, "SplitIndex left", l, pl, probes)
+		checkTree(t, "SplitIndex right", r, pr, probes)
+
+		// writes to the split trees leave a alone.
+		l.Insert(Key("a/new"), -1)
+		r.DeletePrefix(Key("c"))
+
+		// b may be either kind of tree.
+		other := b
+		if trial%2 == 1 {
+			other = pb
+		}
+		keepB := func(key Key, va, vb any) any { return vb }
+		checkTree(t, "Union", Union(a, other, keepB), Union(pa, pb, keepB), probes)
+		checkTree(t, "Intersect", Intersect(a, other, keepB), Intersect(pa, pb, keepB), probes)
+		checkTree(t, "Difference", Difference(a, other), Difference(pa, pb), probes)
+		if u := Union(pa, b, nil); u.buckets != 0 || checkBuckets(t, "Union", u.root, 4) != 0 {
+			t.Fatalf("Union of an ordinary tree came out with buckets")
+		}
+		checkTree(t, "a after", a, pa, probes)
+		checkBuckets(t, "a after", a.root, 4)
+	}
+}
+
+func TestBuckets_options(t *testing.T) {
+	if tree := NewArtTree(WithBuckets(0)); tree.buckets != DefaultBucketSize {
+		t.Fatalf("WithBuckets(0) gave size %v", tree.buckets)
+	}
+
+	tt := NewTypedTree[string](WithBuckets(4))
+	for _, k := range []string{"e", "d", "c", "b", "a", "ab"} {
+		tt.Insert(Key(k), k+"!")
+	}
+	if v, idx, ok := tt.FindGT(Key("a")); !ok || v != "ab!" || idx != 1 {
+		t.Fatalf("TypedTree FindGT = %v, %v, %v", v, idx, ok)
+	}
+	var got []string
+	for k, v := range tt.Descend(nil, nil) {
+		got = append(got, string(k)+"="+v)
+	}
+	if fmt.Sprint(got) != "[e=e! d=d! c=c! b=b! ab=ab! a=a!]" {
+		t.Fatalf("TypedTree Descend = %v", got)
+	}
+
+	tree := NewArtTree(WithBuckets(4))
+	w := tree.Watch(Key("b"), nil)
+	defer w.Close()
+	for _, k := range []string{"a", "b1", "b2", "b3", "c"} {
+		tree.Insert(Key(k), 1)
+	}
+	if n := tree.CountPrefix(Key("b")); n != 3 {
+		t.Fatalf("CountPrefix = %v, want 3", n)
+	}
+	tree.DeletePrefix(Key("b"))
+	for _, s := range []string{
+		"KeyInserted b1", "KeyInserted b2", "KeyInserted b3", "KeyInserted c",
+		"KeyRemoved b1", "KeyRemoved b2", "KeyRemoved b3",
+	} {
+		ev := nextEvent(t, w)
+		if got := fmt.Sprintf("%v %s", ev.Kind, ev.Key); got != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("NewArtTree did not panic")
+		}
+	}()
+	NewArtTree(WithCompactLeaves(), WithBuckets(4))
+}
+
+// The heap taken up by, and the time to scan, find,
+// and fill, a tree of the words and one of the
+// paths, with and without buckets of several sizes.
+// Use -benchtime 1x for just the heap-bytes.
+func BenchmarkBuckets(b *testing.B) {
+	for _, set := range keySets() {
+		for _, size := range []int{0, 16, DefaultBucketSize, 256} {
+			var opts []TreeOption
+			if size > 0 {
+				opts = append(opts, WithBuckets(size))
+			}
+			tree := NewArtTree(opts...)
+			for _, k := range set.keys {
+				tree.Insert(k, nil)
+			}
+			b.Run(fmt.Sprintf("memory/%v/size_%v", set.name, size), func(b *testing.B) {
+				var heap uint64
+				for range b.N {
+					heap = heapOf(set.keys, opts...)
+				}
+				b.ReportMetric(float64(heap), "heap-bytes")
+			})
+			b.Run(fmt.Sprintf("scan/%v/size_%v", set.name, size), func(b *testing.B) {
+				for range b.N {
+					for range Ascend(tree, nil, nil) {
+					}
+				}
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(set.keys)), "ns/key")
+			})
+			b.Run(fmt.Sprintf("find/%v/size_%v", set.name, size), func(b *testing.B) {
+				for i := range b.N {
+					tree.FindExact(set.keys[i%len(set.keys)])
+				}
+			})
+			b.Run(fmt.Sprintf("insert/%v/size_%v", set.name, size), func(b *testing.B) {
+				for range b.N {
+					tree := NewArtTree(opts...)
+					for _, k := range set.keys {
+						tree.Insert(k, nil)
+					}
+				}
+			})
+		}
+	}
+}
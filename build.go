@@ -0,0 +1,142 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	"iter"
+)
+
+// SortOrderError is returned by BuildFromSorted
+// when its input is not in strictly ascending
+// key order. Duplicate keys are also reported
+// this way, with Prev equal to Key.
+type SortOrderError struct {
+	// Index is the position, counting from 0, of
+	// the offending key in the input sequence.
+	Index int
+
+	// Prev is the key at Index-1; Key is the key at Index.
+	Prev Key
+	Key  Key
+}
+
+func (e *SortOrderError) Error() string {
+	if bytes.Equal(e.Prev, e.Key) {
+		return fmt.Sprintf("uart: duplicate key %q at index %v", e.Key, e.Index)
+	}
+	return fmt.Sprintf("uart: key %q at index %v is not greater than the previous key %q", e.Key, e.Index, e.Prev)
+}
+
+// BuildFromSorted returns a new Tree holding the keys
+// and values from seq, which must deliver its keys in
+// strictly ascending (bytes.Compare) order. The keys
+// are copied.
+//
+// Instead of calling Insert once per key, the tree is
+// built bottom-up: every inner node is created once,
+// already at its final node4/node16/node48/node256 size
+// and with its compressed prefix, SubN, and child
+// pren counts filled in. This is much faster than
+// repeated Insert for inputs that are already sorted,
+// such as database exports or sorted log files.
+//
+// If a key is out of order or repeated, BuildFromSorted
+// stops and returns a *SortOrderError saying where.
+//
+// Note that the package level Ascend and Descend functions
+// yield the *Leaf as the `any` value; to copy a
+// Tree, use Clone instead.
+func BuildFromSorted(seq iter.Seq2[Key, any]) (*Tree, error) {
+	var leaves []*Leaf
+	var prev Key
+	i := 0
+	for key, val := range seq {
+		if i > 0 && bytes.Compare(prev, key) >= 0 {
+			return nil, &SortOrderError{
+				Index: i,
+				Prev:  append(Key{}, prev...),
+				Key:   append(Key{}, key...),
+			}
+		}
+		key2 := Key(append([]byte{}, key...))
+		leaves = append(leaves, NewLeaf(key2, val, nil))
+		prev = key2
+		i++
+	}
+	return buildFromLeaves(leaves), nil
+}
+
+// buildFromLeaves returns a tree holding leaves,
+// which must be sorted, unique, and owned by
+// the new tree.
+func buildFromLeaves(leaves []*Leaf) *Tree {
+	t := NewArtTree()
+	if len(leaves) == 0 {
+		return t
+	}
+	root, ok := buildSorted(leaves, 0, 0)
+	if !ok {
+		// A key that ends exactly where another key
+		// continues with a 0 byte cannot be laid out
+		// bottom-up; let Insert place those.
+		t.SkipLocking = true
+		for _, lf := range leaves {
+			t.InsertLeaf(lf)
+		}
+		t.SkipLocking = false
+		return t
+	}
+	t.root = root
+	t.size = int64(len(leaves))
+	return t
+}
+
+// buildSorted builds the subtree holding leaves.
+// The leaves must be sorted, and all share their
+// first depth bytes. keybyte is the byte that
+// leads to the subtree from its parent.
+//
+// ok is false if two keys would need the same
+// 0 keybyte at some inner node: one key ending
+// there and another continuing with a 0 byte.
+func buildSorted(leaves []*Leaf, depth int, keybyte byte) (b *bnode, ok bool) {
+	if len(leaves) == 1 {
+		lf := leaves[0]
+		lf.keybyte = keybyte
+		return bnodeLeaf(lf), true
+	}
+
+	// Since the leaves are sorted, the prefix
+	// common to all of them is the one common
+	// to the first and the last.
+	first := leaves[0].Key
+	last := leaves[len(leaves)-1].Key
+	common := comparePrefix(first, last, depth)
+	var compressed []byte
+	if common > 0 {
+		compressed = append([]byte{}, first[depth:depth+common]...)
+	}
+	pos := depth + common
+	if len(first) == pos && leaves[1].Key.At(pos) == 0 {
+		return nil, false
+	}
+
+	var keys []byte
+	var children []*bnode
+	beg := 0
+	for beg < len(leaves) {
+		kb := leaves[beg].Key.At(pos)
+		end := beg + 1
+		for end < len(leaves) && leaves[end].Key.At(pos) == kb {
+			end++
+		}
+		child, ok := buildSorted(leaves[beg:end], pos+1, kb)
+		if !ok {
+			return nil, false
+		}
+		keys = append(keys, kb)
+		children = append(children, child)
+		beg = end
+	}
+	return innerFrom(compressed, keybyte, keys, children), true
+}
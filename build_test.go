@@ -0,0 +1,158 @@
+package uart
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"iter"
+	"sort"
+	"testing"
+)
+
+// sortedSeq yields keys (which must already be sorted)
+// with the key itself as the value.
+func sortedSeq(keys [][]byte) iter.Seq2[Key, any] {
+	return func(yield func(Key, any) bool) {
+		for _, k := range keys {
+			if !yield(k, string(k)) {
+				return
+			}
+		}
+	}
+}
+
+// verify that bulk built tree has the same
+// shape as the one built by Insert.
+func sameShape(t *testing.T, a, b *Tree) {
+	t.Helper()
+	if a.Size() != b.Size() {
+		t.Fatalf("sizes differ: %v vs %v", a.Size(), b.Size())
+	}
+	cs, _ := a.CompressedStats()
+	cs2, _ := b.CompressedStats()
+	if fmt.Sprint(cs) != fmt.Sprint(cs2) {
+		t.Fatalf("compressed stats differ:\n%v\n%v", cs, cs2)
+	}
+	kinds := func(tr *Tree) map[kind]int {
+		m := make(map[kind]int)
+		if tr.root == nil {
+			return m
+		}
+		for b := range dfs(tr.root) {
+			m[b.kind()]++
+		}
+		return m
+	}
+	if fmt.Sprint(kinds(a)) != fmt.Sprint(kinds(b)) {
+		t.Fatalf("node kinds differ:\n%v\n%v", kinds(a), kinds(b))
+	}
+}
+
+func TestBuildFromSorted_words(t *testing.T) {
+	for _, path := range []string{"assets/words.txt", "assets/hsk_words.txt", "assets/uuid.txt"} {
+		words := loadTestFile(path)
+		sort.Sort(sliceByteSlice(words))
+		// remove any duplicates
+		uniq := words[:0]
+		for i, w := range words {
+			if i == 0 || !bytes.Equal(w, words[i-1]) {
+				uniq = append(uniq, w)
+			}
+		}
+		words = uniq
+
+		built, err := BuildFromSorted(sortedSeq(words))
+		panicOn(err)
+
+		inserted := NewArtTree()
+		for _, w := range words {
+			inserted.Insert(w, string(w))
+		}
+		sameShape(t, built, inserted)
+
+		for i := 0; i < len(words); i += 101 {
+			v, idx, ok := built.FindExact(words[i])
+			if !ok || idx != i || v.(string) != string(words[i]) {
+				t.Fatalf("%v: FindExact(%q) = %v, %v, %v", path, words[i], v, idx, ok)
+			}
+			lf, ok := built.Atfar(i)
+			if !ok || !bytes.Equal(lf.Key, words[i]) {
+				t.Fatalf("%v: Atfar(%v) = %v", path, i, lf)
+			}
+		}
+		// and it takes further writes normally.
+		for i := 0; i < len(words); i += 3 {
+			built.Remove(words[i])
+			inserted.Remove(words[i])
+		}
+		sameShape(t, built, inserted)
+	}
+}
+
+func TestBuildFromSorted_order_errors(t *testing.T) {
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("b"), []byte("c")}
+	_, err := BuildFromSorted(sortedSeq(keys))
+	var soe *SortOrderError
+	if !errors.As(err, &soe) || soe.Index != 2 || string(soe.Key) != "b" {
+		t.Fatalf("expected duplicate at index 2, got %v", err)
+	}
+	keys = [][]byte{[]byte("a"), []byte("c"), []byte("b")}
+	_, err = BuildFromSorted(sortedSeq(keys))
+	if !errors.As(err, &soe) || soe.Index != 2 || string(soe.Prev) != "c" {
+		t.Fatalf("expected out of order at index 2, got %v", err)
+	}
+}
+
+func TestBuildFromSorted_binary_keys(t *testing.T) {
+	// keys that are prefixes of one another, with 0 bytes.
+	keys := [][]byte{
+		{0, 1},
+		{0, 1, 2, 0},
+		{0, 1, 2, 0, 3},
+		{0, 1, 2, 0, 4},
+		{5},
+		{5, 1, 0},
+		{5, 1, 0, 7, 0},
+	}
+	tree, err := BuildFromSorted(sortedSeq(keys))
+	panicOn(err)
+	if tree.Size() != len(keys) {
+		t.Fatalf("size %v", tree.Size())
+	}
+	i := 0
+	for key := range Ascend(tree, nil, nil) {
+		if !bytes.Equal(key, keys[i]) {
+			t.Fatalf("at %v got %v want %v", i, key, keys[i])
+		}
+		i++
+	}
+
+	// A key ending where another continues with a 0 byte
+	// cannot be built bottom-up; we must get the same
+	// tree that Insert would give.
+	keys = [][]byte{{}, {0, 1}, {5}, {5, 0}}
+	tree, err = BuildFromSorted(sortedSeq(keys))
+	panicOn(err)
+	inserted := NewArtTree()
+	for _, k := range keys {
+		inserted.Insert(k, string(k))
+	}
+	sameShape(t, tree, inserted)
+}
+
+func TestClone_copies_values(t *testing.T) {
+	tree := NewArtTree()
+	for i := range 1000 {
+		tree.Insert(Key(fmt.Sprintf("%04d", i)), i)
+	}
+	c := tree.Clone()
+	sameShape(t, tree, c)
+	v, _, ok := c.FindExact(Key("0123"))
+	if !ok || v.(int) != 123 {
+		t.Fatalf("clone value %v, %v", v, ok)
+	}
+	tree.Remove(Key("0123"))
+	if _, _, ok = c.FindExact(Key("0123")); !ok {
+		t.Fatalf("clone shares structure with original")
+	}
+}
@@ -0,0 +1,156 @@
+package uart
+
+// WithCompactLeaves returns a TreeOption that
+// makes the tree store only the end of each key
+// in its leaf.
+//
+// Ordinarily every Leaf.Key holds the whole key,
+// repeating the bytes that the compressed prefixes
+// and keybytes of the inner nodes above it already
+// spell out. A compact leaf keeps just the bytes from
+// its parent's keybyte on. Find, At, the iterators,
+// and the other read methods rebuild the whole key
+// when they return it, from the search key or
+// from the path they walked.
+//
+// This saves memory when keys share long prefixes,
+// as file paths do, at the cost of an allocation
+// for each leaf handed back. So the *Leaf that Find,
+// At, Iter, and Remove return from a compact tree is
+// a copy holding the whole key: setting its Value
+// does not change the tree. Insert a new value instead.
+//
+// Union, Intersect, Difference, and Join of a tree
+// with compact leaves build a new tree from the
+// merged leaves, rather than sharing subtrees.
+// A TypedTree cannot have compact leaves.
+func WithCompactLeaves() TreeOption {
+	return func(t *Tree) {
+		t.compact = true
+	}
+}
+
+// atFull is at for a tree that may have compact
+// leaves: it returns the i-th leaf with its whole
+// key, built from the path down to it.
+func (a *bnode) atFull(i int) (lf *Leaf, ok bool) {
+	var path Key
+	b := a
+	for !b.isLeaf {
+		n := b.inner
+		if i < 0 || i >= n.SubN {
+			return nil, false
+		}
+		if bk, ok := n.asBucket(); ok {
+			return bk.leaves[i], true
+		}
+		path = append(path, n.compressed...)
+		key, ch := n.Node.next(nil)
+		for ch != nil && i >= ch.subn() {
+			i -= ch.subn()
+			key, ch = n.Node.next(&key)
+		}
+		if ch == nil {
+			return nil, false
+		}
+		path = append(path, key)
+		b = ch
+	}
+	if i != 0 {
+		return nil, false
+	}
+	return b.leaf.full(path), true
+}
+
+// edgeLeaf returns the first leaf under b, or the
+// last if last is set, with its whole key. p holds
+// the key bytes of the path to b, up to its prefix,
+// and is appended to.
+func (b *bnode) edgeLeaf(p Key, last bool) *Leaf {
+	for !b.isLeaf {
+		n := b.inner
+		if bk, ok := n.asBucket(); ok {
+			if last {
+				return bk.leaves[len(bk.leaves)-1]
+			}
+			return bk.leaves[0]
+		}
+		p = append(p, n.compressed...)
+		var kb byte
+		if last {
+			kb, b = n.last()
+		} else {
+			kb, b = n.first()
+		}
+		p = append(p, kb)
+	}
+	lf := b.leaf
+	if lf.base == 0 {
+		return lf
+	}
+	// p spells out the bytes lf leaves out.
+	return &Leaf{Key: append(p[:lf.base], lf.Key...), Value: lf.Value}
+}
+
+// finishFrom readies r, a tree newly built from
+// whole-key leaves, to stand in for t: it compacts
+// r's leaves if t has compact leaves, or gathers
+// them into buckets if t has buckets, and gives
+// r t's options. It returns r.
+func (r *Tree) finishFrom(t *Tree) *Tree {
+	if t.compact {
+		compactLeaves(r.root, 0)
+	}
+	if t.buckets > 0 {
+		bucketLeaves(r.root, t.buckets)
+	}
+	r.adoptOptions(t)
+	return r
+}
+
+// compactLeaves replaces each leaf under b, whose
+// keys all share their first depth bytes, with its
+// compact form. The leaves must hold whole keys,
+// and b must be newly built, since its child
+// bnodes are changed in place.
+func compactLeaves(b *bnode, depth int) {
+	if b == nil || b.isLeaf {
+		// a leaf at the root keeps its whole key.
+		return
+	}
+	n := b.inner
+	pos := depth + len(n.compressed)
+	for _, ch := range n.kids() {
+		if ch.isLeaf {
+			ch.leaf = ch.leaf.rebased(ch.leaf.Key, pos)
+		} else {
+			compactLeaves(ch, pos+1)
+		}
+	}
+}
+
+// edgeKey returns the whole key of the first
+// leaf under n, or the last if last is set.
+// path holds the key bytes before n's prefix.
+func (n *inner) edgeKey(path Key, last bool) Key {
+	lf := n.rfirst()
+	if last {
+		lf = n.rlast()
+	}
+	if lf.base == 0 {
+		return lf.Key
+	}
+	p := append(Key{}, path...)
+	for {
+		p = append(p, n.compressed...)
+		kb, b := n.first()
+		if last {
+			kb, b = n.last()
+		}
+		p = append(p, kb)
+		if b.isLeaf {
+			return b.leaf.fullKey(p)
+		}
+		n = b.inner
+	}
+}
@@ -0,0 +1,260 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"runtime"
+	"testing"
+)
+
+func TestCompactLeaves_match_ordinary_tree(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(17, 17))
+	keys := randKeys(rng, 400, 8, "abc/")
+	probes := append(randKeys(rng, 50, 8, "abc/"), Key{}, nil)
+	check := func(what string, c, plain *Tree) {
+		t.Helper()
+		if !c.compact {
+			t.Fatalf("%v: tree is not compact", what)
+		}
+		checkTree(t, what, c, plain, probes)
+	}
+
+	for _, opts := range [][]TreeOption{nil, {WithSlabAllocator()}} {
+		c := NewArtTree(append(opts, WithCompactLeaves())...)
+		plain := NewArtTree()
+		var snap, snapPlain *Tree
+		for i := range 4000 {
+			k := keys[rng.IntN(len(keys))]
+			switch rng.IntN(10) {
+			case 0, 1:
+				d, lf := c.Remove(k)
+				pd, plf := plain.Remove(k)
+				if d != pd || (d && (!bytes.Equal(lf.Key, plf.Key) || lf.Value != plf.Value)) {
+					t.Fatalf("Remove(%q) = %v, %v; want %v, %v", k, d, lf, pd, plf)
+				}
+			case 2:
+				end := keys[rng.IntN(len(keys))]
+				if n, pn := c.DeleteRange(k, end), plain.DeleteRange(k, end); n != pn {
+					t.Fatalf("DeleteRange(%q, %q) = %v, want %v", k, end, n, pn)
+				}
+			case 3:
+				c.LoadOrStore(k, uint64(i))
+				plain.LoadOrStore(k, uint64(i))
+			default:
+				if u, pu := c.Insert(k, uint64(i)), plain.Insert(k, uint64(i)); u != pu {
+					t.Fatalf("Insert(%q) = %v, want %v", k, u, pu)
+				}
+			}
+			if i == 1000 {
+				snap, snapPlain = c.Snapshot(), plain.Clone()
+			}
+			if i%500 == 0 {
+				check(fmt.Sprintf("op %v", i), c, plain)
+			}
+		}
+		check("after ops", c, plain)
+		check("snapshot", snap, snapPlain)
+		check("clone", c.Clone(), plain)
+
+		var buf, pbuf bytes.Buffer
+		c.ValueCodec = uint64Codec{}
+		plain.ValueCodec = uint64Codec{}
+		if _, err := c.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		plain.WriteTo(&pbuf)
+		if !bytes.Equal(buf.Bytes(), pbuf.Bytes()) {
+			t.Fatalf("the snapshots of the two trees differ")
+		}
+		back := NewArtTree(WithCompactLeaves())
+		back.ValueCodec = uint64Codec{}
+		if _, err := back.ReadFrom(&buf); err != nil {
+			t.Fatal(err)
+		}
+		check("ReadFrom", back, plain)
+	}
+}
+
+func TestCompactLeaves_split_join_and_set_ops(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(17, 18))
+	keys := randKeys(rng, 600, 8, "abc/")
+	probes := randKeys(rng, 30, 8, "abc/")
+	check := func(what string, c, plain *Tree) {
+		t.Helper()
+		if !c.compact {
+			t.Fatalf("%v: tree is not compact", what)
+		}
+		checkTree(t, what, c, plain, probes)
+	}
+	fill := func(tree *Tree, lo, hi int) {
+		for i := lo; i < hi; i++ {
+			tree.Insert(keys[i], i)
+		}
+	}
+	for trial := range 20 {
+		a, pa := NewArtTree(WithCompactLeaves()), NewArtTree()
+		b, pb := NewArtTree(WithCompactLeaves()), NewArtTree()
+		fill(a, 0, 400)
+		fill(pa, 0, 400)
+		fill(b, 200, 600)
+		fill(pb, 200, 600)
+
+		at := probes[trial]
+		l, r := a.SplitAt(at)
+		pl, pr := pa.SplitAt(at)
+		check("left", l, pl)
+		check("right", r, pr)
+		j, err := Join(l, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("Join", j, pa)
+		l, r = a.SplitIndex(trial * 10)
+		pl, pr = pa.SplitIndex(trial * 10)
+		check("SplitIndex left", l, pl)
+		check("SplitIndex right", r, pr)
+
+		// b may be either kind of tree.
+		other := b
+		if trial%2 == 1 {
+			other = pb
+		}
+		keepB := func(key Key, va, vb any) any { return vb }
+		check("Union", Union(a, other, keepB), Union(pa, pb, keepB))
+		check("Intersect", Intersect(a, other, keepB), Intersect(pa, pb, keepB))
+		check("Difference", Difference(a, other), Difference(pa, pb))
+		if u := Union(pa, b, nil); u.compact {
+			t.Fatalf("Union of an ordinary tree came out compact")
+		}
+		check("a after", a, pa)
+	}
+}
+
+func TestCompactLeaves_watch_and_typed(t *testing.T) {
+	tree := NewArtTree(WithCompactLeaves())
+	w := tree.Watch(nil, nil)
+	defer w.Close()
+	for _, k := range []string{"/usr/lib", "/usr/bin", "/usr/bin/go"} {
+		tree.Insert(Key(k), 1)
+	}
+	tree.Remove(Key("/usr/bin"))
+	tree.DeletePrefix(Key("/usr/"))
+	for _, s := range []string{
+		"KeyInserted /usr/lib", "KeyInserted /usr/bin", "KeyInserted /usr/bin/go",
+		"KeyRemoved /usr/bin", "KeyRemoved /usr/bin/go", "KeyRemoved /usr/lib",
+	} {
+		ev := nextEvent(t, w)
+		if got := fmt.Sprintf("%v %s", ev.Kind, ev.Key); got != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("NewTypedTree did not panic")
+		}
+	}()
+	NewTypedTree[int](WithCompactLeaves())
+}
+
+// heapOf returns the bytes of heap that a tree
+// holding keys takes up, counting the keys: each
+// is inserted as a copy that the tree then owns.
+func heapOf(keys [][]byte, opts ...TreeOption) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	tree := NewArtTree(opts...)
+	for _, k := range keys {
+		tree.Insert(append(Key(nil), k...), nil)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(tree)
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+// keySets returns the keys the memory benchmarks
+// measure: the words, whose prefixes are short,
+// and the paths of assets/paths.txt, whose
+// directories make long shared prefixes.
+func keySets() []keySet {
+	var paths [][]byte
+	for _, p := range loadPaths() {
+		paths = append(paths, []byte(p))
+	}
+	return []keySet{
+		{"words", loadTestFile("assets/words.txt")},
+		{"paths", paths},
+	}
+}
+
+type keySet struct {
+	name string
+	keys [][]byte
+}
+
+// The memory saved by compact leaves. Each run
+// reports the heap its tree takes up; use
+// -bench CompactLeaves_memory -benchtime 1x.
+func BenchmarkCompactLeaves_memory(b *testing.B) {
+	for _, set := range keySets() {
+		for _, mode := range []struct {
+			name string
+			opts []TreeOption
+		}{{"ordinary", nil}, {"compact", []TreeOption{WithCompactLeaves()}}} {
+			b.Run(set.name+"/"+mode.name, func(b *testing.B) {
+				var heap uint64
+				for range b.N {
+					heap = heapOf(set.keys, mode.opts...)
+				}
+				b.ReportMetric(float64(heap), "heap-bytes")
+			})
+		}
+	}
+}
+
+// A compact Find makes only the leaf it hands
+// back, and a compact Insert no more than an
+// ordinary one: the new leaf is made once, and
+// is given a copy of just the end of its key.
+func TestCompactLeaves_allocs(t *testing.T) {
+	paths := loadTestFile("assets/paths.txt")[:2000]
+	tree := NewArtTree(WithCompactLeaves())
+	for _, p := range paths {
+		tree.Insert(p, nil)
+	}
+	for _, smod := range []SearchModifier{Exact, GTE, GT, LTE, LT} {
+		n := testing.AllocsPerRun(1, func() {
+			for _, p := range paths {
+				tree.Find(smod, p)
+			}
+		})
+		t.Logf("%v: %v allocs per Find", smod, n/float64(len(paths)))
+		if n > 2*float64(len(paths)) {
+			t.Fatalf("%v: %v allocs per Find, want the Leaf and its key", smod, n/float64(len(paths)))
+		}
+	}
+	// each key but the first two adds a leaf to
+	// the root node, so that no leaf has to move.
+	var keys []Key
+	for i := range 200 {
+		keys = append(keys, append(Key{byte(i)}, "/usr/share/doc"...))
+	}
+	count := func(opts ...TreeOption) float64 {
+		return testing.AllocsPerRun(5, func() {
+			tree := NewArtTree(opts...)
+			for _, k := range keys {
+				tree.Insert(k, nil)
+			}
+		})
+	}
+	// the second key moves the first leaf down from
+	// the root, which a compact tree must copy.
+	compact, plain := count(WithCompactLeaves()), count()
+	t.Logf("allocs per %v inserts: compact %v, ordinary %v", len(keys), compact, plain)
+	if compact > plain+3 {
+		t.Fatalf("compact inserts make %v allocs, ordinary ones %v", compact, plain)
+	}
+}
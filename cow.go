@@ -0,0 +1,179 @@
+package uart
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// cowGen hands out the Tree.gen numbers used
+// by copy-on-write trees. They are unique
+// across all trees in the process, so no two
+// trees can ever both believe they own a node.
+var cowGen atomic.Uint64
+
+// Snapshot returns a frozen, read-only view of the
+// tree as it is now, in O(1) time.
+//
+// The snapshot shares all of its nodes with t.
+// To keep the snapshot unchanged, the first call to
+// Snapshot puts t into copy-on-write (persistent)
+// mode: from then on Insert and Remove copy the
+// inner nodes on the root-to-leaf path they
+// modify, rather than changing them in place.
+// Each inner node is copied at most once per
+// Snapshot, so a burst of writes to the same part
+// of the tree pays for the copy only once.
+//
+// t stays in copy-on-write mode, and frees no nodes
+// to its slab allocator (see WithSlabAllocator),
+// until every snapshot taken of it has been released.
+// Call Release on a snapshot once done with it.
+//
+// Readers of the snapshot need no locking, and
+// always see a consistent view, no matter what
+// writers do to t. Any number of goroutines
+// may read a snapshot at once. Snapshots can be kept
+// to provide a cheap versioned history of the tree.
+//
+// Insert, Remove, and the other write methods
+// panic if called on a snapshot. Use Clone() on
+// a snapshot to obtain a writable copy.
+//
+// Since the leaves are shared too, callers must not
+// modify the Leaf.Value of a leaf that a snapshot
+// might see; Insert a new value for the key instead.
+func (t *Tree) Snapshot() *Tree {
+	if t.readOnly {
+		return t
+	}
+	if !t.SkipLocking {
+		t.lock()
+		defer t.unlock()
+	}
+	return t.snapshotLocked()
+}
+
+// snapshotLocked does the work of Snapshot.
+// The caller must hold the write lock.
+func (t *Tree) snapshotLocked() *Tree {
+	// readers of the snapshot must never have to
+	// fix up a stale pren, since that writes.
+	if t.root != nil {
+		t.root.subTreeRedoPren()
+	}
+	snap := &Tree{
+		root:        t.root,
+		size:        t.size,
+		treeVersion: t.treeVersion,
+		readOnly:    true,
+		SkipLocking: true,
+		ValueCodec:  t.ValueCodec,
+		compact:     t.compact,
+		buckets:     t.buckets,
+		src:         t,
+	}
+	t.freeze()
+	t.pins++
+	return snap
+}
+
+// Release tells t, a snapshot, that it will not be
+// read again. Once every snapshot of a tree has been
+// released, the tree leaves copy-on-write mode: its
+// writes change nodes in place again, and free them
+// to its slab allocator. A tree whose nodes SplitAt,
+// Join, Union, Intersect or Difference have shared
+// stays in copy-on-write mode for good.
+//
+// A released snapshot is empty. Release does nothing
+// on a tree that is not a snapshot, or when called
+// again. Since the Snapshot of a snapshot is the
+// snapshot itself, releasing one releases both.
+func (t *Tree) Release() {
+	src := t.src
+	if src == nil {
+		return
+	}
+	t.src = nil
+	t.root, t.size, t.atCache = nil, 0, nil
+	if !src.SkipLocking {
+		src.lock()
+		defer src.unlock()
+	}
+	src.pins--
+	if src.pins == 0 && !src.pinned {
+		src.cow = false
+	}
+}
+
+// freeze marks every node currently in t as shared,
+// so that later writes to t copy them first.
+// The caller must hold the write lock, and
+// must already have brought pren up to date.
+func (t *Tree) freeze() {
+	if t.root != nil {
+		// the root bnode itself is shared too.
+		r := *t.root
+		t.root = &r
+	}
+	t.cow = true
+	t.gen = cowGen.Add(1)
+}
+
+// panicIfReadOnly is called by all writers.
+func (t *Tree) panicIfReadOnly() {
+	if t.readOnly {
+		panic("uart: cannot modify a read-only Tree Snapshot")
+	}
+}
+
+// cowClone returns a copy of n that belongs to
+// generation gen. The node4/16/48/256 is copied,
+// and so is each child bnode (but not the child
+// inner or Leaf it points to), since the bnodes
+// hold the pren counts that a writer may update.
+func (n *inner) cowClone(gen uint64) *inner {
+	c := *n
+	c.gen = gen
+	switch x := n.Node.(type) {
+	case *node4:
+		y := *x
+		cowKids(y.children[:y.lth])
+		c.Node = &y
+	case *node16:
+		y := *x
+		cowKids(y.children[:y.lth])
+		c.Node = &y
+	case *node48:
+		y := *x
+		cowKids(y.children[:])
+		c.Node = &y
+	case *node256:
+		y := *x
+		cowKids(y.children[:])
+		c.Node = &y
+	case *bucket:
+		c.Node = &bucket{leaves: slices.Clone(x.leaves)}
+	}
+	return &c
+}
+
+// cowKids replaces each non-nil bnode in kids
+// with a copy, using a single allocation.
+func cowKids(kids []*bnode) {
+	count := 0
+	for _, ch := range kids {
+		if ch != nil {
+			count++
+		}
+	}
+	slab := make([]bnode, count)
+	j := 0
+	for i, ch := range kids {
+		if ch != nil {
+			slab[j] = *ch
+			kids[i] = &slab[j]
+			j++
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	mathrand2 "math/rand/v2"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestSnapshot_isolation(t *testing.T) {
+	tree := NewArtTree()
+	words := loadTestFile("assets/words.txt")[:20000]
+	sort.Sort(sliceByteSlice(words))
+	for i, w := range words {
+		if i%2 == 0 {
+			tree.Insert(w, i)
+		}
+	}
+	snap := tree.Snapshot()
+	size0 := snap.Size()
+
+	// churn the live tree: add the odds, remove
+	// some evens, and update others.
+	for i, w := range words {
+		switch {
+		case i%2 == 1:
+			tree.Insert(w, i)
+		case i%4 == 0:
+			tree.Remove(w)
+		default:
+			tree.Insert(w, -i)
+		}
+	}
+	snap2 := tree.Snapshot()
+	tree.Insert(Key("after snap2"), 0)
+
+	if snap.Size() != size0 {
+		t.Fatalf("snapshot size changed: %v -> %v", size0, snap.Size())
+	}
+	j := 0
+	for key, lf := range Ascend(snap, nil, nil) {
+		i := j * 2
+		if !bytes.Equal(key, words[i]) || lf.(*Leaf).Value.(int) != i {
+			t.Fatalf("snapshot at %v: got %q = %v, want %q = %v", j, key, lf.(*Leaf).Value, words[i], i)
+		}
+		lf2, ok := snap.At(j)
+		if !ok || !bytes.Equal(lf2.Key, key) {
+			t.Fatalf("snapshot At(%v) = %v", j, lf2)
+		}
+		j++
+	}
+	if j != size0 {
+		t.Fatalf("snapshot iteration saw %v, want %v", j, size0)
+	}
+
+	for i, w := range words {
+		v, _, ok := snap2.FindExact(w)
+		switch {
+		case i%2 == 1:
+			if !ok || v.(int) != i {
+				t.Fatalf("snap2 %q: %v %v", w, v, ok)
+			}
+		case i%4 == 0:
+			if ok {
+				t.Fatalf("snap2 %q should be gone", w)
+			}
+		default:
+			if !ok || v.(int) != -i {
+				t.Fatalf("snap2 %q: %v %v", w, v, ok)
+			}
+		}
+	}
+	if _, _, ok := snap2.FindExact(Key("after snap2")); ok {
+		t.Fatalf("snap2 sees a later write")
+	}
+	if tree.Size() != snap2.Size()+1 {
+		t.Fatalf("live size %v, snap2 size %v", tree.Size(), snap2.Size())
+	}
+
+	// a snapshot refuses writes.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected panic writing to a snapshot")
+			}
+		}()
+		snap.Insert(Key("nope"), 1)
+	}()
+
+	// but a Clone of it is writable.
+	c := snap.Clone()
+	c.Insert(Key("yes"), 1)
+	if c.Size() != size0+1 || snap.Size() != size0 {
+		t.Fatalf("clone of snapshot: %v %v", c.Size(), snap.Size())
+	}
+}
+
+// run with -race: snapshot readers take no locks
+// while the writer keeps going.
+func TestSnapshot_concurrent_readers(t *testing.T) {
+	tree := NewArtTree()
+	n := 2000
+	for i := range n {
+		tree.Insert(Key(fmt.Sprintf("%05d", i)), i)
+	}
+	var wg sync.WaitGroup
+	for r := range 4 {
+		snap := tree.Snapshot()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pass := range 5 {
+				if snap.Size() != n {
+					panic(fmt.Sprintf("reader %v pass %v: size %v", r, pass, snap.Size()))
+				}
+				for i := 0; i < n; i += 7 {
+					v, idx, ok := snap.FindExact(Key(fmt.Sprintf("%05d", i)))
+					if !ok || v.(int) != i || idx != i {
+						panic(fmt.Sprintf("reader %v: %v -> %v %v %v", r, i, v, idx, ok))
+					}
+					lf, ok := snap.At(i)
+					if !ok || lf.Value.(int) != i {
+						panic(fmt.Sprintf("reader %v: At(%v) -> %v", r, i, lf))
+					}
+				}
+			}
+		}()
+		for i := range n {
+			tree.Insert(Key(fmt.Sprintf("%05d", i)), i+1000*(r+1))
+			if i%3 == 0 {
+				tree.Remove(Key(fmt.Sprintf("%05d", i)))
+				tree.Insert(Key(fmt.Sprintf("%05d", i)), i)
+			}
+		}
+		for i := range n {
+			tree.Insert(Key(fmt.Sprintf("%05d", i)), i)
+		}
+	}
+	wg.Wait()
+}
+
+func TestSnapshot_randomized_history(t *testing.T) {
+	var seed32 [32]byte
+	seed32[0] = 4
+	rng := mathrand2.New(mathrand2.NewChaCha8(seed32))
+	tree := NewArtTree()
+	model := map[string]int{}
+
+	type version struct {
+		snap  *Tree
+		model map[string]int
+	}
+	var history []version
+	for op := range 20000 {
+		k := fmt.Sprintf("%x", rng.IntN(3000))
+		if rng.IntN(3) == 0 {
+			tree.Remove(Key(k))
+			delete(model, k)
+		} else {
+			tree.Insert(Key(k), op)
+			model[k] = op
+		}
+		if op%997 == 0 {
+			m := make(map[string]int, len(model))
+			for k, v := range model {
+				m[k] = v
+			}
+			history = append(history, version{snap: tree.Snapshot(), model: m})
+		}
+	}
+	for h, ver := range history {
+		if ver.snap.Size() != len(ver.model) {
+			t.Fatalf("version %v: size %v, want %v", h, ver.snap.Size(), len(ver.model))
+		}
+		prev := ""
+		i := 0
+		for key, lf := range Ascend(ver.snap, nil, nil) {
+			if want, ok := ver.model[string(key)]; !ok || want != lf.(*Leaf).Value.(int) {
+				t.Fatalf("version %v: key %q value %v, want %v (present %v)", h, key, lf.(*Leaf).Value, want, ok)
+			}
+			if i > 0 && string(key) <= prev {
+				t.Fatalf("version %v: out of order", h)
+			}
+			_, idx, _ := ver.snap.FindExact(key)
+			if idx != i {
+				t.Fatalf("version %v: index of %q is %v, want %v", h, key, idx, i)
+			}
+			prev = string(key)
+			i++
+		}
+	}
+}
+
+func TestSnapshot_release(t *testing.T) {
+	tree := NewArtTree(WithSlabAllocator())
+	key := func(i int) Key { return Key(fmt.Sprintf("%04d", i)) }
+	for i := range 1000 {
+		tree.Insert(key(i), i)
+	}
+	s1 := tree.Snapshot()
+	s2 := tree.Snapshot()
+	s1.Release()
+	s1.Release()
+	if !tree.cow {
+		t.Fatalf("thawed with a snapshot still out")
+	}
+	if s1.Size() != 0 || !s1.IsEmpty() {
+		t.Fatalf("released snapshot has size %v", s1.Size())
+	}
+	for i := range 100 {
+		tree.Remove(key(i))
+	}
+	if s2.Size() != 1000 {
+		t.Fatalf("snapshot size %v", s2.Size())
+	}
+	s2.Release()
+	if tree.cow {
+		t.Fatalf("still copy-on-write after the last Release")
+	}
+
+	// writes change nodes in place again,
+	// and free them to the allocator.
+	for i := 100; i < 600; i++ {
+		tree.Remove(key(i))
+	}
+	if len(tree.slab.freeB) == 0 || len(tree.slab.free4) == 0 {
+		t.Fatalf("nothing recycled after Release")
+	}
+	for i := range 1000 {
+		v, _, ok := tree.FindExact(key(i))
+		if ok != (i >= 600) || (ok && v.(int) != i) {
+			t.Fatalf("%s: %v %v", key(i), v, ok)
+		}
+	}
+	verifySubN(tree.root)
+
+	// a tree split from a snapshot shares its
+	// nodes for good, as does a split of the tree.
+	s3 := tree.Snapshot()
+	left, right := s3.SplitAt(key(800))
+	s3.Release()
+	if !tree.cow {
+		t.Fatalf("thawed while SplitAt shares its nodes")
+	}
+	for i := 600; i < 1000; i++ {
+		tree.Insert(key(i), -i)
+	}
+	if left.Size() != 200 || right.Size() != 200 {
+		t.Fatalf("split sizes %v, %v", left.Size(), right.Size())
+	}
+	for i := 600; i < 1000; i++ {
+		half := left
+		if i >= 800 {
+			half = right
+		}
+		if v, _, ok := half.FindExact(key(i)); !ok || v.(int) != i {
+			t.Fatalf("split %s: %v %v", key(i), v, ok)
+		}
+	}
+}
+
+// snapshots are released in random order while
+// others are still read; the tree thaws when none
+// is left, and freezes again at the next Snapshot.
+func TestSnapshot_release_randomized(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(5, 5))
+	tree := NewArtTree(WithSlabAllocator())
+	model := map[string]int{}
+
+	type version struct {
+		snap  *Tree
+		model map[string]int
+	}
+	var live []version
+	thawed := 0
+	for op := range 30000 {
+		k := fmt.Sprintf("%x", rng.IntN(3000))
+		if rng.IntN(3) == 0 {
+			tree.Remove(Key(k))
+			delete(model, k)
+		} else {
+			tree.Insert(Key(k), op)
+			model[k] = op
+		}
+		switch {
+		case op%500 == 0 && len(live) < 4:
+			live = append(live, version{snap: tree.Snapshot(), model: maps.Clone(model)})
+		case op%300 == 0 && len(live) > 0:
+			i := rng.IntN(len(live))
+			ver := live[i]
+			if ver.snap.Size() != len(ver.model) {
+				t.Fatalf("op %v: size %v, want %v", op, ver.snap.Size(), len(ver.model))
+			}
+			for key, lf := range Ascend(ver.snap, nil, nil) {
+				if want, ok := ver.model[string(key)]; !ok || want != lf.(*Leaf).Value.(int) {
+					t.Fatalf("op %v: key %q value %v, want %v (present %v)", op, key, lf.(*Leaf).Value, want, ok)
+				}
+			}
+			ver.snap.Release()
+			live = slices.Delete(live, i, i+1)
+			if tree.cow != (len(live) > 0) {
+				t.Fatalf("op %v: cow %v with %v snapshots out", op, tree.cow, len(live))
+			}
+			if len(live) == 0 {
+				thawed++
+			}
+		}
+	}
+	if thawed == 0 {
+		t.Fatalf("never thawed")
+	}
+	if tree.Size() != len(model) {
+		t.Fatalf("size %v, want %v", tree.Size(), len(model))
+	}
+	for k, v := range model {
+		if got, _, ok := tree.FindExact(Key(k)); !ok || got.(int) != v {
+			t.Fatalf("%q: %v %v, want %v", k, got, ok, v)
+		}
+	}
+	verifySubN(tree.root)
+}
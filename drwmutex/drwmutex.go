@@ -39,29 +39,11 @@ an `n`-way RWMutex, also known as a "big reader" lock, which gives each
 CPU core its own RWMutex. Readers take only a read lock local to their
 core, whereas writers must take all locks in order.
 
-**Note that the current implementation only supports x86 processors on
-Linux; other combinations will revert (automatically) to the old
-sync.RWMutex behaviour. To support other architectures and OSes, the
-appropriate `cpu_GOARCH.go` and `cpus_GOOS.go` files need to be written.
-If you have a different setup available, and have the time to write one
-of these, I'll happily accept patches.**
-
-## Finding the current CPU
-
-To determine which lock to take, readers use the CPUID instruction,
-which gives the APICID of the currently active CPU without having to
-issue a system call or modify the runtime. This instruction is supported
-on both Intel and AMD processors; ARM CPUs should use the [CPU ID
-register](http://infocenter.arm.com/help/index.jsp?topic=/com.arm.doc.ddi0360e/CACEDHJG.html)
-instead. For systems with more than 256 processors, x2APIC must be used,
-and the EDX register after CPUID with EAX=0xb should be used instead. A
-mapping from APICID to CPU index is constructed (using CPU affinity
-syscalls) when the program is started, as it is static for the lifetime
-of a process.  Since the CPUID instruction can be fairly expensive,
-goroutines will also only periodically update their estimate of what
-core they are running on.  More frequent updates lead to less inter-core
-lock traffic, but also increases the time spent on CPUID instructions
-relative to the actual locking.
+On x86 Linux, readers find their CPU with the RDPID (or RDTSCP) instruction,
+which reads the CPU number that the kernel keeps in the TSC_AUX
+register, without a system call. On other Linux architectures the
+getcpu system call is used. Other OSes fall back to a single lock,
+which behaves like a plain sync.RWMutex.
 
 **Stale CPU information.**
 The information of which CPU a goroutine is running on *might* be stale
@@ -109,19 +91,10 @@ for further discussion.
 */
 
 import (
-	"fmt"
-	"os"
 	"runtime"
 	"sync"
-	"time"
-	// commented for zero dependencies.
-	//"github.com/klauspost/cpuid"
 )
 
-var _ = fmt.Sprintf
-var _ = os.Create
-var _ = runtime.NumCPU
-
 /*
 Linux / Zen.
 go test -v
@@ -149,62 +122,58 @@ logcpu = 33; rdtscp = 36; PDPID = 36
 
 */
 
-func Cpu2() (cpu int) {
-	// dynamically detects current core, supports many architecture/OS.
-	//return uint64(cpuid.CPU.LogicalCPU())
-
-	rdpid, ok := tryRDPID()
-	_ = rdpid
-	// on mac, always 0, so assume it failed if 0.
-	// some linux will take longer, but okay for the moment.
-	if ok && rdpid > 0 {
-		//fmt.Printf("tryRDPID got rdpid = %v in %v\n", rdpid, e0)
-		// So rpid does not need the APIC ID translation... but to
-		// stay compat with Cpu2() returning logical CPU,
-		// and then caller using cpu map on it, we will
-		// temporarily but it back in that domain, TODO: remove map and inv map
-		// if we keep the RDPID...
-		return inverseCpus[int(rdpid)]
-	}
-
-	//rdpidDebug, eax, edx := debugRDTSCP()
-	// ecx has the cpu id.
-	eax, ebx, ecx, edx := asmRdtscpAsm()
-	_, _, _, _ = eax, ebx, ecx, edx
-
-	rdtscp := int(getCurrentCPUViaRDTSCP())
-
-	panic(" klauspost/cpuid was only commented out for zero dependency. it is needed here!")
-	//logcpu := cpuid.CPU.LogicalCPU()
-	logcpu := 0 // delete me if cpuid comes back!
+// how Cpu2 finds the current CPU; see detectCPU.
+const (
+	viaNone = iota
+	viaRDPID
+	viaRDTSCP
+	viaGetcpu
+)
 
-	if rdtscp != 0 {
-		//fmt.Printf("RDTSCP was non-zero! logcpu = %v; rdtscp = %v\n", logcpu, rdtscp)
+var cpuVia = detectCPU()
+
+// detectCPU picks the cheapest way to learn the
+// current CPU that works on this machine. On Linux
+// the kernel stores the CPU number in the low 12 bits of
+// the TSC_AUX register, which the RDPID and RDTSCP
+// instructions read without a system call. Failing
+// those, the getcpu system call is used. Elsewhere
+// we have no way to ask, and every reader
+// shares the first lock.
+func detectCPU() int {
+	switch {
+	case tscAuxIsCPU && hasRDPID():
+		return viaRDPID
+	case tscAuxIsCPU && hasRDTSCP():
+		return viaRDTSCP
 	}
-
-	//mac := MacOSOnlySysctlGetLogicalCPU()
-
-	//if logcpu != rdtscp {
-	//fmt.Printf("logcpu = %v; rdtscp = %v; RDPID = %v; eax=%v, ebx=%v; ecx=%v; edx=%v\n", logcpu, rdtscp, rdpid, eax, ebx, ecx, edx) // rdpidDebug, eax, edx) // , mac)
-	//}
-	return logcpu
+	if _, ok := getcpu(); ok {
+		return viaGetcpu
+	}
+	return viaNone
 }
 
-// cpus maps (non-consecutive) CPUID values to integer indices.
-var cpus map[int]int
-var inverseCpus map[int]int
-
-// init will construct the cpus map so that CPUIDs can be looked up to
-// determine a particular core's lock index.
-func init() {
-	start := time.Now()
-	cpus = map_cpus() // from from APICID -> "processor"
-	inverseCpus = make(map[int]int)
-	for k, v := range cpus {
-		inverseCpus[v] = k
+// Cpu2 returns the number of the logical CPU
+// the calling goroutine is running on, as
+// numbered by the operating system (the
+// "processor" lines of /proc/cpuinfo on Linux).
+// It returns 0 if there is no way to tell.
+//
+// The answer may be stale by the time it is
+// used, since the goroutine can be moved to
+// another CPU at any time. This only costs performance,
+// not correctness, as the DRWMutex remembers which
+// lock a reader took.
+func Cpu2() (cpu int) {
+	switch cpuVia {
+	case viaRDPID:
+		return int(rdpid() & 0xfff)
+	case viaRDTSCP:
+		return int(getCurrentCPUViaRDTSCP())
+	case viaGetcpu:
+		cpu, _ = getcpu()
 	}
-	_ = start
-	//fmt.Fprintf(os.Stderr, "%d/%d cpus found in %v: %v\n", len(cpus), runtime.NumCPU(), time.Now().Sub(start), cpus)
+	return
 }
 
 type paddedRWMutex struct {
@@ -218,15 +187,19 @@ type paddedRWMutex struct {
 	mu sync.RWMutex
 }
 
+// DRWMutex is a distributed RWMutex: one
+// sync.RWMutex per CPU. Readers take only the
+// lock of the CPU they are running on, so
+// readers on different CPUs do not contend on a
+// shared cache line. Writers take all the locks.
 type DRWMutex struct {
-	slc  []paddedRWMutex
-	last RLocker
+	slc []paddedRWMutex
 }
 
 // New returns a new, unlocked, distributed RWMutex.
 func NewDRWMutex() *DRWMutex {
 	return &DRWMutex{
-		slc: make([]paddedRWMutex, len(cpus)),
+		slc: make([]paddedRWMutex, runtime.NumCPU()),
 	}
 }
 
@@ -245,6 +218,13 @@ func (mx DRWMutex) Unlock() {
 	}
 }
 
+// local returns the lock for the current CPU. CPU
+// numbers can exceed runtime.NumCPU when the process
+// is limited to some of the CPUs, hence the modulo.
+func (mx DRWMutex) local() *sync.RWMutex {
+	return &mx.slc[Cpu2()%len(mx.slc)].mu
+}
+
 type RLocker interface {
 	RLock()
 	RUnlock()
@@ -252,19 +232,19 @@ type RLocker interface {
 	Unlock()
 }
 
-// RLocker returns a sync.Locker presenting Lock() and Unlock() methods that
-// take and release a non-exclusive *reader* lock. Note that this call may be
+// RLocker returns the sync.RWMutex of the current CPU, without
+// locking it; take a non-exclusive *reader* lock with its RLock, and
+// release it with RUnlock. Note that this call may be
 // relatively slow, depending on the underlying system architechture, and so
 // its result should be cached if possible.
-func (mx DRWMutex) RLocker() *sync.RWMutex { // sync.Locker {
-	return &(mx.slc[cpus[Cpu2()]].mu) // .RLocker()
+func (mx DRWMutex) RLocker() *sync.RWMutex {
+	return mx.local()
 }
 
 // RLock takes out a non-exclusive reader lock, and returns the lock that was
-// taken so that it can later be released.
-func (mx DRWMutex) RLock() (l *sync.RWMutex) { // RLocker) { // (l sync.Locker) {
-	l = &(mx.slc[cpus[Cpu2()]].mu) // .RLocker()
-	mx.last = l
-	l.Lock()
+// taken so that it can later be released with RUnlock.
+func (mx DRWMutex) RLock() (l *sync.RWMutex) {
+	l = mx.local()
+	l.RLock()
 	return
 }
@@ -3,18 +3,13 @@
 
 package drwmutex
 
-// cpu returns a unique identifier for the core the current goroutine is
-// executing on. This function is platform dependent, and is implemented in
-// cpu_*.s.
-func cpu() uint64 {
-	// this reverts the behaviour to that of a regular DRWMutex
-	return 0
-}
+// Without RDPID and RDTSCP, Cpu2 can only
+// use getcpu, where the OS provides it.
 
-func getCurrentCPUViaRDTSCP() uint32 {
-	return 0
-}
+func hasRDPID() bool { return false }
 
-func tryRDPID() (cpu uint32, ok bool) {
-	return
-}
+func hasRDTSCP() bool { return false }
+
+func rdpid() uint32 { return 0 }
+
+func getCurrentCPUViaRDTSCP() uint32 { return 0 }
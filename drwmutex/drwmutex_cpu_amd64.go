@@ -1,11 +1,29 @@
 package drwmutex
 
-func cpu() uint64
-
+// getCurrentCPUViaRDTSCP returns the low 12 bits of
+// TSC_AUX, read with the RDTSCP instruction.
 func getCurrentCPUViaRDTSCP() uint32
 
-func tryRDPID() (cpu uint32, ok bool)
+// rdpid returns TSC_AUX, read with the RDPID instruction.
+func rdpid() uint32
+
+// cpuid executes the CPUID instruction.
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
 
-func debugRDTSCP() (cpu, eax, edx uint32)
+// hasRDPID reports whether CPUID.(EAX=07H,ECX=0):ECX bit 22 is set.
+func hasRDPID() bool {
+	if maxID, _, _, _ := cpuid(0, 0); maxID < 7 {
+		return false
+	}
+	_, _, ecx, _ := cpuid(7, 0)
+	return ecx&(1<<22) != 0
+}
 
-func asmRdtscpAsm() (eax, ebx, ecx, edx uint32)
+// hasRDTSCP reports whether CPUID.80000001H:EDX bit 27 is set.
+func hasRDTSCP() bool {
+	if maxExt, _, _, _ := cpuid(0x80000000, 0); maxExt < 0x80000001 {
+		return false
+	}
+	_, _, _, edx := cpuid(0x80000001, 0)
+	return edx&(1<<27) != 0
+}
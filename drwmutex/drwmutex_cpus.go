@@ -1,9 +1,13 @@
-//go:build !linux && !darwin
-// +build !linux,!darwin
+//go:build !linux
+// +build !linux
 
 package drwmutex
 
-func map_cpus() (cpus map[int]int) {
-	cpus = make(map[int]int)
+// tscAuxIsCPU is true where the OS is known to keep
+// the current CPU number in TSC_AUX. On darwin,
+// for example, RDPID always reads 0.
+const tscAuxIsCPU = false
+
+func getcpu() (cpu int, ok bool) {
 	return
 }
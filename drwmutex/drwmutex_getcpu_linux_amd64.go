@@ -0,0 +1,4 @@
+package drwmutex
+
+// the syscall package leaves out SYS_GETCPU on amd64.
+const sysGetcpu = 309
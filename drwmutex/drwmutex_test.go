@@ -2,7 +2,6 @@ package drwmutex_test
 
 import (
 	"flag"
-	"fmt"
 	"math/rand"
 	"os"
 	"testing"
@@ -10,6 +9,7 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/glycerine/uart/drwmutex"
@@ -53,16 +53,24 @@ var cost []time.Duration // on average 150 nanoseconds Cpu2() call.
 func TestDrwmutex(t *testing.T) {
 	//intercept_SIGINT()
 	if true {
-		fmt.Printf("runtime.NumCPU() = %v\n", runtime.NumCPU()) // 8 with is logical cores.
+		t.Logf("runtime.NumCPU() = %v", runtime.NumCPU()) // 8 with is logical cores.
+		var wg sync.WaitGroup
 		for i := range 2 {
+			wg.Add(1)
 			go func(i int) {
-				for {
-					time.Sleep(time.Second)
-					fmt.Printf("i=%v LogicalCPUID from klauspost/cpuid: %v\n", i, drwmutex.Cpu2())
+				defer wg.Done()
+				for range 3 {
+					time.Sleep(10 * time.Millisecond)
+					cpu := drwmutex.Cpu2()
+					t.Logf("i=%v Cpu2: %v", i, cpu)
+					if cpu < 0 {
+						t.Errorf("negative cpu %v", cpu)
+					}
 				}
 			}(i)
 		}
-		select {}
+		wg.Wait()
+		return
 	}
 	/*
 		cpuprofile := flag.Bool("cpuprofile", false, "enable CPU profiling")
@@ -161,3 +169,41 @@ func TestDrwmutex(t *testing.T) {
 		}
 	*/
 }
+
+func TestDRWMutex_excludes(t *testing.T) {
+	mx := drwmutex.NewDRWMutex()
+	var writing, readers atomic.Int32
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := range 2000 {
+				if (g+i)%50 == 0 {
+					mx.Lock()
+					if writing.Add(1) != 1 || readers.Load() != 0 {
+						t.Errorf("writer is not alone")
+					}
+					writing.Add(-1)
+					mx.Unlock()
+					continue
+				}
+				var l drwmutex.RLocker
+				if i%2 == 0 {
+					l = mx.RLock()
+				} else {
+					// RLocker hands out the lock, unlocked.
+					l = mx.RLocker()
+					l.RLock()
+				}
+				readers.Add(1)
+				if writing.Load() != 0 {
+					t.Errorf("reader overlaps a writer")
+				}
+				readers.Add(-1)
+				l.RUnlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+}
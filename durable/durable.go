@@ -0,0 +1,590 @@
+// Package durable keeps a uart.Tree on disk, so
+// that it survives a restart or a crash.
+//
+// Every write is appended to a write-ahead log
+// before it is applied to the in-memory tree and
+// acknowledged. Open replays the log to rebuild the
+// tree. A checkpoint writes the whole tree out as a
+// uart snapshot (see uart.Tree.WriteTo), after which
+// the log written before it can be discarded, so
+// that the log does not grow without bound and
+// Open does not have to replay all of history.
+//
+// A directory holds one Tree. In it,
+//
+//	snap-<seq>.uart  is the latest checkpoint, and
+//	wal-<seq>.log    are the log segments,
+//
+// where <seq> is a 16 digit hex number. The checkpoint
+// snap-N holds everything written to the segments
+// before wal-N; to recover, Open loads snap-N, and
+// replays wal-N and the segments after it in order.
+package durable
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glycerine/uart"
+)
+
+// SyncPolicy says when the log is flushed
+// to stable storage with fsync.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs the log before each write
+	// returns. An acknowledged write is never lost.
+	SyncAlways SyncPolicy = iota
+
+	// SyncInterval fsyncs the log in the background,
+	// every Options.SyncEvery. Each write still reaches
+	// the operating system before it returns, so it
+	// survives a crash of the process, but a crash of
+	// the machine can lose the writes of the last interval.
+	SyncInterval
+
+	// SyncNever leaves flushing to the operating system,
+	// except at checkpoints and Close.
+	SyncNever
+)
+
+// Options configure Open. The zero Options
+// fsync every write and never checkpoint
+// on their own.
+type Options struct {
+	Sync SyncPolicy
+
+	// SyncEvery is the interval for SyncInterval.
+	// It defaults to 100ms.
+	SyncEvery time.Duration
+
+	// CheckpointEvery, if not zero, has a
+	// Checkpoint taken in the background this often.
+	CheckpointEvery time.Duration
+
+	// ValueCodec encodes the values, in the log and in
+	// the checkpoints. If nil, uart.BytesCodec is used.
+	ValueCodec uart.ValueCodec
+
+	// TreeOptions are passed to uart.NewArtTree.
+	TreeOptions []uart.TreeOption
+}
+
+// ErrClosed is returned by the writes made
+// to a Tree after Close.
+var ErrClosed = errors.New("durable: Tree is closed")
+
+// ErrFailed is returned (wrapped) by the writes made
+// to a Tree after its log could not be written and then
+// could not be cut back to its last whole record, or after
+// an fsync of it failed. Once an fsync has failed, what
+// the log holds on disk is not known, so no later write
+// is safe to acknowledge. Reopening the Tree recovers
+// what the log does hold.
+var ErrFailed = errors.New("durable: log failed")
+
+// logFile is what the log is written through: an
+// *os.File, or in the tests, one that can fail.
+type logFile interface {
+	Write(p []byte) (n int, err error)
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// Tree is a uart.Tree whose writes are logged.
+// All of its methods are safe for concurrent use.
+type Tree struct {
+	dir   string
+	opts  Options
+	tree  *uart.Tree
+	codec uart.ValueCodec
+
+	// mu serializes the writes, so that the log holds
+	// them in the order they are applied to tree.
+	// It guards the fields below.
+	mu     sync.Mutex
+	log    logFile
+	seq    uint64 // of the log segment
+	end    int64  // of the last whole record in the log
+	buf    []byte // scratch space for records
+	val    []byte // scratch space for values
+	dirty  bool   // written but not fsynced
+	failed error  // wraps ErrFailed, once the log has failed
+	closed bool
+
+	// ckmu lets one Checkpoint run at a time;
+	// ckErr is the last error of a background one.
+	ckmu  sync.Mutex
+	ckErr error
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the Tree kept in the directory dir,
+// creating the directory if need be, and rebuilds
+// the tree from the latest checkpoint and the log.
+// If the last log record was only partly written when
+// the process stopped, it is truncated away; damage
+// anywhere else is reported as ErrCorruptLog.
+// opts may be nil.
+func Open(dir string, opts *Options) (*Tree, error) {
+	d := &Tree{dir: dir, stop: make(chan struct{})}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.SyncEvery <= 0 {
+		d.opts.SyncEvery = 100 * time.Millisecond
+	}
+	d.codec = d.opts.ValueCodec
+	if d.codec == nil {
+		d.codec = uart.BytesCodec{}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := d.removeTmp(); err != nil {
+		return nil, err
+	}
+	if err := d.recover(); err != nil {
+		return nil, err
+	}
+	if d.opts.Sync == SyncInterval {
+		d.every(d.opts.SyncEvery, d.syncLog)
+	}
+	if d.opts.CheckpointEvery > 0 {
+		d.every(d.opts.CheckpointEvery, func() {
+			err := d.Checkpoint()
+			if err == ErrClosed {
+				return
+			}
+			d.ckmu.Lock()
+			d.ckErr = err
+			d.ckmu.Unlock()
+		})
+	}
+	return d, nil
+}
+
+// Tree returns the in-memory tree, for reading.
+// Writes must go through the durable Tree:
+// changes made to the uart.Tree directly
+// are not logged, and are lost on restart.
+func (d *Tree) Tree() *uart.Tree {
+	return d.tree
+}
+
+// Insert logs, and then makes, t.Tree().Insert(key, value).
+// The value must be encodable by the ValueCodec.
+func (d *Tree) Insert(key uart.Key, value any) (updated bool, err error) {
+	lf := uart.NewLeaf(append(uart.Key{}, key...), value, nil)
+	return d.InsertLeaf(lf)
+}
+
+// InsertLeaf logs, and then makes, t.Tree().InsertLeaf(lf).
+// As for uart.Tree.InsertLeaf, lf must own its Key.
+func (d *Tree) InsertLeaf(lf *uart.Leaf) (updated bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.val, err = d.codec.AppendValue(d.val[:0], lf.Value)
+	if err != nil {
+		return false, err
+	}
+	if err = d.append(opInsert, lf.Key, d.val); err != nil {
+		return false, err
+	}
+	return d.tree.InsertLeaf(lf), nil
+}
+
+// Remove logs, and then makes, t.Tree().Remove(key).
+// Nothing is logged if key is not in the tree.
+//
+// The record is written before the tree is changed,
+// so that a failed write leaves the tree as it was.
+// Holding d.mu, no other write can come between
+// finding the key and removing it.
+func (d *Tree) Remove(key uart.Key) (deleted bool, deletedLeaf *uart.Leaf, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false, nil, ErrClosed
+	}
+	if d.failed != nil {
+		return false, nil, d.failed
+	}
+	if _, _, found := d.tree.FindExact(key); !found {
+		return false, nil, nil
+	}
+	if err = d.append(opRemove, key, nil); err != nil {
+		return false, nil, err
+	}
+	deleted, deletedLeaf = d.tree.Remove(key)
+	return
+}
+
+// append writes a record to the log.
+// The caller holds d.mu.
+//
+// If the write fails, the log is cut back to the
+// last whole record, so that the records after it
+// are not lost behind a damaged one on Open; and
+// if it cannot be, or if an fsync fails, d fails.
+func (d *Tree) append(op byte, key uart.Key, val []byte) error {
+	if d.closed {
+		return ErrClosed
+	}
+	if d.failed != nil {
+		return d.failed
+	}
+	d.buf = appendRecord(d.buf[:0], op, key, val)
+	if _, err := d.log.Write(d.buf); err != nil {
+		if err2 := d.log.Truncate(d.end); err2 != nil {
+			d.failed = fmt.Errorf("%w: %v; then %v", ErrFailed, err, err2)
+		}
+		return err
+	}
+	if d.opts.Sync == SyncAlways {
+		if err := d.log.Sync(); err != nil {
+			// the write is not acknowledged, so it must
+			// not be replayed after a restart either.
+			d.log.Truncate(d.end)
+			d.failed = fmt.Errorf("%w: %v", ErrFailed, err)
+			return err
+		}
+	} else {
+		d.dirty = true
+	}
+	d.end += int64(len(d.buf))
+	return nil
+}
+
+// syncLog fsyncs the log if it has unsynced writes.
+func (d *Tree) syncLog() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dirty && !d.closed {
+		if err := d.log.Sync(); err != nil {
+			// as in append: the writes since the last
+			// fsync may or may not be on disk.
+			d.failed = fmt.Errorf("%w: %v", ErrFailed, err)
+			return
+		}
+		d.dirty = false
+	}
+}
+
+// every calls fn every interval, in a goroutine,
+// until Close.
+func (d *Tree) every(interval time.Duration, fn func()) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				fn()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Checkpoint writes the whole tree to a new
+// checkpoint file, and then removes the log segments
+// and the checkpoint it replaces. Writes carry
+// on while the checkpoint is written: it is taken
+// from a uart Snapshot, and the writes after the
+// snapshot go to a new log segment. The snapshot
+// is released once written, so the tree goes back
+// to changing its nodes in place (see uart.Tree.Release).
+func (d *Tree) Checkpoint() error {
+	d.ckmu.Lock()
+	defer d.ckmu.Unlock()
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrClosed
+	}
+	if d.failed != nil {
+		d.mu.Unlock()
+		return d.failed
+	}
+	if err := d.log.Sync(); err != nil {
+		d.failed = fmt.Errorf("%w: %v", ErrFailed, err)
+		d.mu.Unlock()
+		return err
+	}
+	next := d.seq + 1
+	f, err := os.OpenFile(d.path("wal", next), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	snap := d.tree.Snapshot()
+	defer snap.Release()
+	d.log.Close()
+	d.log, d.seq, d.end, d.dirty = f, next, 0, false
+	d.mu.Unlock()
+
+	if err := syncDir(d.dir); err != nil {
+		return err
+	}
+	if err := d.writeSnap(snap, next); err != nil {
+		return err
+	}
+	return d.removeBefore(next)
+}
+
+// writeSnap writes the checkpoint snap-seq, by
+// way of a temporary file, so that a crash leaves
+// either the whole checkpoint or none of it.
+func (d *Tree) writeSnap(snap *uart.Tree, seq uint64) error {
+	final := d.path("snap", seq)
+	tmp := final + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	_, err = snap.WriteTo(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp, final)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(d.dir)
+}
+
+// Close takes no checkpoint; it stops the background
+// work, and fsyncs and closes the log. It returns the
+// error of the last background checkpoint, if that failed.
+func (d *Tree) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	close(d.stop)
+	d.wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.log.Sync()
+	if err2 := d.log.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		d.ckmu.Lock()
+		err = d.ckErr
+		d.ckmu.Unlock()
+	}
+	return err
+}
+
+// path returns the name of the file of
+// the given kind, "wal" or "snap", and seq.
+func (d *Tree) path(kind string, seq uint64) string {
+	ext := ".log"
+	if kind == "snap" {
+		ext = ".uart"
+	}
+	return filepath.Join(d.dir, fmt.Sprintf("%v-%016x%v", kind, seq, ext))
+}
+
+// files returns the sequence numbers of the
+// files of the given kind in d.dir, in order.
+func (d *Tree) files(kind string) ([]uint64, error) {
+	ents, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, e := range ents {
+		if seq, ok := d.seqOf(kind, e.Name()); ok {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// seqOf returns the sequence number of the file
+// name, if it is d.path(kind, seq) for some seq.
+func (d *Tree) seqOf(kind, name string) (uint64, bool) {
+	rest, ok := strings.CutPrefix(name, kind+"-")
+	if !ok {
+		return 0, false
+	}
+	rest, _, _ = strings.Cut(rest, ".")
+	seq, err := strconv.ParseUint(rest, 16, 64)
+	if err != nil || d.path(kind, seq) != filepath.Join(d.dir, name) {
+		return 0, false
+	}
+	return seq, true
+}
+
+// removeTmp removes the temporary files left
+// by checkpoints cut short, snap-<seq>.uart.tmp
+// (see writeSnap). Other files are left alone.
+func (d *Tree) removeTmp() error {
+	ents, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range ents {
+		name, ok := strings.CutSuffix(e.Name(), ".tmp")
+		if !ok {
+			continue
+		}
+		if _, ok := d.seqOf("snap", name); ok {
+			if err := os.Remove(filepath.Join(d.dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeBefore removes the checkpoints and log
+// segments made obsolete by the checkpoint snap-seq.
+func (d *Tree) removeBefore(seq uint64) error {
+	for _, kind := range []string{"wal", "snap"} {
+		seqs, err := d.files(kind)
+		if err != nil {
+			return err
+		}
+		for _, s := range seqs {
+			if s < seq {
+				if err := os.Remove(d.path(kind, s)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// recover rebuilds the tree from the files in d.dir,
+// and opens the last log segment for appending.
+func (d *Tree) recover() error {
+	d.tree = uart.NewArtTree(d.opts.TreeOptions...)
+	d.tree.ValueCodec = d.opts.ValueCodec
+
+	snaps, err := d.files("snap")
+	if err != nil {
+		return err
+	}
+	var start uint64
+	if len(snaps) > 0 {
+		start = snaps[len(snaps)-1]
+		f, err := os.Open(d.path("snap", start))
+		if err != nil {
+			return err
+		}
+		_, err = d.tree.ReadFrom(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("durable: reading checkpoint: %w", err)
+		}
+	}
+	// a crash during Checkpoint can leave
+	// behind files it should have removed.
+	if err := d.removeBefore(start); err != nil {
+		return err
+	}
+
+	segs, err := d.files("wal")
+	if err != nil {
+		return err
+	}
+	apply := func(r record) error {
+		if r.op == opRemove {
+			d.tree.Remove(r.key)
+			return nil
+		}
+		v, err := d.codec.DecodeValue(r.val)
+		if err != nil {
+			return err
+		}
+		d.tree.Insert(r.key, v)
+		return nil
+	}
+	for i, seq := range segs {
+		f, err := os.OpenFile(d.path("wal", seq), os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		good, torn, err := replaySegment(f, apply)
+		if err == nil && torn {
+			if i < len(segs)-1 {
+				err = fmt.Errorf("%w: %v is damaged at offset %v", ErrCorruptLog, f.Name(), good)
+			} else {
+				// the tail of the last write.
+				err = f.Truncate(good)
+				if err == nil {
+					err = f.Sync()
+				}
+			}
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	d.seq = start
+	if len(segs) > 0 {
+		d.seq = segs[len(segs)-1]
+	}
+	f, err := os.OpenFile(d.path("wal", d.seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	d.log, d.end = f, fi.Size()
+	return syncDir(d.dir)
+}
+
+// syncDir fsyncs the directory dir, so that the
+// files created or renamed in it are durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	return err
+}
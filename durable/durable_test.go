@@ -0,0 +1,362 @@
+package durable
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glycerine/uart"
+)
+
+// checkHas fails unless d holds exactly ref.
+func checkHas(t *testing.T, d *Tree, ref map[string]string) {
+	t.Helper()
+	tree := d.Tree()
+	if tree.Size() != len(ref) {
+		t.Fatalf("Size %v, want %v", tree.Size(), len(ref))
+	}
+	for k, v := range ref {
+		val, _, found := tree.FindExact(uart.Key(k))
+		if !found || string(val.([]byte)) != v {
+			t.Fatalf("%q is %v (found %v), want %q", k, val, found, v)
+		}
+	}
+}
+
+func mustOpen(t *testing.T, dir string, opts *Options) *Tree {
+	t.Helper()
+	d, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return d
+}
+
+func TestDurable_replay(t *testing.T) {
+	for _, sync := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		dir := t.TempDir()
+		d := mustOpen(t, dir, &Options{Sync: sync, SyncEvery: time.Millisecond})
+		ref := make(map[string]string)
+		for i := range 500 {
+			k := fmt.Sprintf("key%03d", i%200)
+			switch i % 7 {
+			case 3:
+				deleted, _, err := d.Remove(uart.Key(k))
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, ok := ref[k]
+				if deleted != ok {
+					t.Fatalf("Remove(%q) = %v", k, deleted)
+				}
+				delete(ref, k)
+			default:
+				v := fmt.Sprint(i)
+				if _, err := d.Insert(uart.Key(k), []byte(v)); err != nil {
+					t.Fatal(err)
+				}
+				ref[k] = v
+			}
+		}
+		checkHas(t, d, ref)
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Insert(uart.Key("x"), nil); err != ErrClosed {
+			t.Fatalf("Insert after Close: %v", err)
+		}
+
+		d = mustOpen(t, dir, nil)
+		checkHas(t, d, ref)
+		d.Close()
+	}
+}
+
+func TestDurable_torn_tail(t *testing.T) {
+	dir := t.TempDir()
+	d := mustOpen(t, dir, nil)
+	ref := make(map[string]string)
+	for i := range 10 {
+		k := fmt.Sprint(i)
+		d.Insert(uart.Key(k), []byte(k))
+		ref[k] = k
+	}
+	d.Close()
+
+	// a crash part way through the next write.
+	name := filepath.Join(dir, fmt.Sprintf("wal-%016x.log", 0))
+	rec := appendRecord(nil, opInsert, uart.Key("torn"), []byte{1, 'x'})
+	for _, cut := range []int{3, 8, len(rec) - 1} {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(rec[:cut])
+		f.Close()
+		fi, _ := os.Stat(name)
+		size := fi.Size()
+
+		d = mustOpen(t, dir, nil)
+		checkHas(t, d, ref)
+		fi, _ = os.Stat(name)
+		if fi.Size() != size-int64(cut) {
+			t.Fatalf("cut %v: log is %v bytes, want %v", cut, fi.Size(), size-int64(cut))
+		}
+		// writes go on after the truncated tail.
+		k := fmt.Sprint("after", cut)
+		d.Insert(uart.Key(k), []byte(k))
+		ref[k] = k
+		d.Close()
+		d = mustOpen(t, dir, nil)
+		checkHas(t, d, ref)
+		d.Close()
+	}
+}
+
+func TestDurable_checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	d := mustOpen(t, dir, nil)
+	ref := make(map[string]string)
+	for round := range 3 {
+		for i := range 100 {
+			k := fmt.Sprintf("%v-%v", round, i)
+			d.Insert(uart.Key(k), []byte(k))
+			ref[k] = k
+		}
+		d.Remove(uart.Key(fmt.Sprintf("%v-%v", round, 5)))
+		delete(ref, fmt.Sprintf("%v-%v", round, 5))
+		if err := d.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Insert(uart.Key("last"), []byte("x"))
+	ref["last"] = "x"
+	d.Close()
+
+	ents, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range ents {
+		names = append(names, e.Name())
+	}
+	want := []string{"snap-0000000000000003.uart", "wal-0000000000000003.log"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("files %v, want %v", names, want)
+	}
+
+	// a checkpoint cut short leaves a temporary file;
+	// other .tmp files are not ours to remove.
+	os.WriteFile(filepath.Join(dir, "snap-0000000000000004.uart.tmp"), []byte("junk"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.tmp"), []byte("keep"), 0644)
+	d = mustOpen(t, dir, nil)
+	checkHas(t, d, ref)
+	d.Close()
+	if _, err := os.Stat(filepath.Join(dir, "snap-0000000000000004.uart.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("temporary file not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.tmp")); err != nil {
+		t.Fatalf("unrelated file removed: %v", err)
+	}
+}
+
+func TestDurable_background_checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	d := mustOpen(t, dir, &Options{Sync: SyncNever, CheckpointEvery: time.Millisecond})
+	ref := make(map[string]string)
+	for i := range 2000 {
+		k := fmt.Sprint(i)
+		d.Insert(uart.Key(k), []byte(k))
+		ref[k] = k
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("wal-%016x.log", 0))); !os.IsNotExist(err) {
+		t.Fatalf("first log segment not discarded: %v", err)
+	}
+	d = mustOpen(t, dir, nil)
+	checkHas(t, d, ref)
+	d.Close()
+}
+
+// Damage before the end of the last
+// segment cannot be a torn write.
+func TestDurable_corrupt_segment(t *testing.T) {
+	dir := t.TempDir()
+	d := mustOpen(t, dir, nil)
+	d.Insert(uart.Key("a"), []byte("1"))
+	d.Insert(uart.Key("b"), []byte("2"))
+	d.Close()
+
+	// a checkpoint whose file was lost leaves
+	// two segments to replay.
+	os.WriteFile(filepath.Join(dir, fmt.Sprintf("wal-%016x.log", 1)), appendRecord(nil, opRemove, uart.Key("a"), nil), 0644)
+	d = mustOpen(t, dir, nil)
+	checkHas(t, d, map[string]string{"b": "2"})
+	d.Close()
+
+	name := filepath.Join(dir, fmt.Sprintf("wal-%016x.log", 0))
+	b, _ := os.ReadFile(name)
+	b[len(b)-1] ^= 0xff
+	os.WriteFile(name, b, 0644)
+	if _, err := Open(dir, nil); !errors.Is(err, ErrCorruptLog) {
+		t.Fatalf("Open: %v, want ErrCorruptLog", err)
+	}
+}
+
+func TestDurable_codec(t *testing.T) {
+	dir := t.TempDir()
+	d := mustOpen(t, dir, nil)
+	if _, err := d.Insert(uart.Key("a"), 42); err == nil {
+		t.Fatalf("BytesCodec encoded an int")
+	}
+	if d.Tree().Size() != 0 {
+		t.Fatalf("a write that failed to log was applied")
+	}
+	d.Close()
+}
+
+// failLog is a log file whose next write can be
+// made to stop part way, and whose fsyncs and
+// truncates can be made to fail.
+type failLog struct {
+	*os.File
+	cut                    int // bytes of the next write to make, if > 0
+	failSync, failTruncate bool
+}
+
+var errInjected = errors.New("injected failure")
+
+func (f *failLog) Write(p []byte) (int, error) {
+	if f.cut > 0 {
+		n, _ := f.File.Write(p[:min(f.cut, len(p))])
+		f.cut = 0
+		return n, errInjected
+	}
+	return f.File.Write(p)
+}
+
+func (f *failLog) Sync() error {
+	if f.failSync {
+		return errInjected
+	}
+	return f.File.Sync()
+}
+
+func (f *failLog) Truncate(size int64) error {
+	if f.failTruncate {
+		return errInjected
+	}
+	return f.File.Truncate(size)
+}
+
+// injectFailures has d write its log through a failLog.
+func injectFailures(d *Tree) *failLog {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := &failLog{File: d.log.(*os.File)}
+	d.log = f
+	return f
+}
+
+// A write or fsync that fails is not acknowledged, and
+// is not replayed; and the writes acknowledged after
+// a failed write survive a restart.
+func TestDurable_failed_writes(t *testing.T) {
+	for _, sync := range []SyncPolicy{SyncAlways, SyncNever} {
+		dir := t.TempDir()
+		d := mustOpen(t, dir, &Options{Sync: sync})
+		ref := map[string]string{"a": "1", "b": "2"}
+		d.Insert(uart.Key("a"), []byte("1"))
+		d.Insert(uart.Key("b"), []byte("2"))
+		f := injectFailures(d)
+
+		// a write cut short is cut back out of the log.
+		f.cut = 5
+		if _, err := d.Insert(uart.Key("c"), []byte("3")); err != errInjected {
+			t.Fatalf("Insert: %v", err)
+		}
+		// nor does a failed Remove touch the tree: the
+		// first event seen is that of the next Insert.
+		w := d.Tree().Watch(nil, nil)
+		f.cut = 3
+		if _, _, err := d.Remove(uart.Key("a")); err != errInjected {
+			t.Fatalf("Remove: %v", err)
+		}
+		checkHas(t, d, ref)
+		d.Insert(uart.Key("d"), []byte("4"))
+		if ev := <-w.C; ev.Kind != uart.KeyInserted || string(ev.Key) != "d" {
+			t.Fatalf("after a failed Remove, got event %v %q", ev.Kind, ev.Key)
+		}
+		w.Close()
+		d.Remove(uart.Key("b"))
+		ref["d"] = "4"
+		delete(ref, "b")
+
+		// one that cannot be cut back fails the Tree.
+		f.cut, f.failTruncate = 5, true
+		if _, err := d.Insert(uart.Key("e"), []byte("5")); err != errInjected {
+			t.Fatalf("Insert: %v", err)
+		}
+		if _, err := d.Insert(uart.Key("f"), []byte("6")); !errors.Is(err, ErrFailed) {
+			t.Fatalf("Insert after a failed truncate: %v", err)
+		}
+		if _, _, err := d.Remove(uart.Key("a")); !errors.Is(err, ErrFailed) {
+			t.Fatalf("Remove after a failed truncate: %v", err)
+		}
+		checkHas(t, d, ref)
+		d.Close()
+
+		d = mustOpen(t, dir, &Options{Sync: sync})
+		checkHas(t, d, ref)
+		if sync != SyncAlways {
+			d.Close()
+			continue
+		}
+
+		// under SyncAlways, a failed fsync fails the Tree,
+		// and its write is not replayed after a restart.
+		f = injectFailures(d)
+		f.failSync = true
+		if _, err := d.Insert(uart.Key("g"), []byte("7")); err != errInjected {
+			t.Fatalf("Insert: %v", err)
+		}
+		f.failSync = false
+		if _, err := d.Insert(uart.Key("h"), []byte("8")); !errors.Is(err, ErrFailed) {
+			t.Fatalf("Insert after a failed fsync: %v", err)
+		}
+		checkHas(t, d, ref)
+		d.Close()
+		d = mustOpen(t, dir, nil)
+		checkHas(t, d, ref)
+		d.Close()
+	}
+}
+
+// A failed background fsync, or the one
+// in Checkpoint, fails the Tree too.
+func TestDurable_failed_sync(t *testing.T) {
+	for _, ck := range []bool{false, true} {
+		d := mustOpen(t, t.TempDir(), &Options{Sync: SyncInterval, SyncEvery: time.Millisecond})
+		f := injectFailures(d)
+		f.failSync = true
+		if _, err := d.Insert(uart.Key("a"), []byte("1")); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		if ck {
+			if err := d.Checkpoint(); err != errInjected {
+				t.Fatalf("Checkpoint: %v", err)
+			}
+		} else {
+			d.syncLog()
+		}
+		if _, err := d.Insert(uart.Key("b"), []byte("2")); !errors.Is(err, ErrFailed) {
+			t.Fatalf("Insert after a failed fsync: %v", err)
+		}
+		f.failSync = false
+		d.Close()
+	}
+}
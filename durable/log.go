@@ -0,0 +1,143 @@
+package durable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/glycerine/uart"
+)
+
+// The log is a sequence of segment files, each a
+// sequence of records. A record is
+//
+//	length  uint32   big-endian payload length
+//	crc     uint32   big-endian CRC-32C (Castagnoli) of the payload
+//	payload [length]byte
+//
+// just like a frame of the uart snapshot format.
+// The payload is an op byte, then
+//
+//	opInsert: uvarint key length, key, value
+//	opRemove: key
+//
+// where the value is encoded by the ValueCodec, and
+// runs to the end of the payload.
+
+const (
+	opInsert = 1
+	opRemove = 2
+)
+
+// records longer than this are taken to be corrupt.
+const maxRecord = 1 << 30
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruptLog is returned (possibly wrapped) by Open
+// when a log segment other than the last one is damaged.
+// Damage at the end of the last segment is expected
+// after a crash, and is truncated away instead.
+var ErrCorruptLog = errors.New("durable: corrupt log")
+
+// appendRecord appends the record for an op
+// on key to dst, with the header filled in.
+func appendRecord(dst []byte, op byte, key uart.Key, val []byte) []byte {
+	start := len(dst)
+	dst = append(dst, make([]byte, 8)...)
+	dst = append(dst, op)
+	if op == opInsert {
+		dst = binary.AppendUvarint(dst, uint64(len(key)))
+		dst = append(dst, key...)
+		dst = append(dst, val...)
+	} else {
+		dst = append(dst, key...)
+	}
+	payload := dst[start+8:]
+	binary.BigEndian.PutUint32(dst[start:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(dst[start+4:], crc32.Checksum(payload, crc32c))
+	return dst
+}
+
+// record is one decoded log entry.
+type record struct {
+	op  byte
+	key uart.Key
+	val []byte
+}
+
+// decodeRecord parses a payload whose checksum
+// has already been verified.
+func decodeRecord(p []byte) (r record, err error) {
+	if len(p) == 0 {
+		return r, fmt.Errorf("%w: empty record", ErrCorruptLog)
+	}
+	r.op = p[0]
+	p = p[1:]
+	switch r.op {
+	case opInsert:
+		n, k := binary.Uvarint(p)
+		if k <= 0 || n > uint64(len(p)-k) {
+			return r, fmt.Errorf("%w: bad key length", ErrCorruptLog)
+		}
+		p = p[k:]
+		r.key = uart.Key(p[:n])
+		r.val = p[n:]
+	case opRemove:
+		r.key = uart.Key(p)
+	default:
+		return r, fmt.Errorf("%w: unknown op %v", ErrCorruptLog, r.op)
+	}
+	return r, nil
+}
+
+// replaySegment calls apply with each record of
+// the segment file f, in order. It returns the offset
+// just past the last good record. A torn or damaged
+// record stops the replay with torn true; any other
+// error, from reading f or from apply, is returned.
+func replaySegment(f *os.File, apply func(record) error) (good int64, torn bool, err error) {
+	var hdr [8]byte
+	var buf []byte
+	for {
+		_, err := io.ReadFull(f, hdr[:])
+		if err == io.EOF {
+			return good, false, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return good, true, nil
+		}
+		if err != nil {
+			return good, false, err
+		}
+		length := binary.BigEndian.Uint32(hdr[:4])
+		sum := binary.BigEndian.Uint32(hdr[4:])
+		if length > maxRecord {
+			return good, true, nil
+		}
+		if cap(buf) < int(length) {
+			buf = make([]byte, length)
+		}
+		buf = buf[:length]
+		if _, err := io.ReadFull(f, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return good, true, nil
+			}
+			return good, false, err
+		}
+		if crc32.Checksum(buf, crc32c) != sum {
+			return good, true, nil
+		}
+		r, err := decodeRecord(buf)
+		if err != nil {
+			return good, false, err
+		}
+		if err := apply(r); err != nil {
+			return good, false, err
+		}
+		good += 8 + int64(length)
+	}
+}
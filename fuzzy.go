@@ -0,0 +1,204 @@
+package uart
+
+import (
+	"unicode/utf8"
+)
+
+// FuzzyMatch is a key found by FuzzyFind,
+// with its edit distance from the query.
+type FuzzyMatch struct {
+	// Leaf holds the key and its value. In a tree
+	// with compact leaves, it is a copy holding the
+	// whole key; see WithCompactLeaves.
+	Leaf *Leaf
+
+	// Dist is the Levenshtein distance between
+	// the key and the query.
+	Dist int
+}
+
+// FuzzyFind returns the keys within maxEdits edits of
+// query, in key order, each with its distance. An edit
+// inserts, deletes, or changes one character; keys
+// are taken as UTF-8, so a change to a Chinese
+// character is one edit, not three. A byte that
+// is not part of valid UTF-8 counts as a character
+// of its own.
+//
+// FuzzyFind goes down the tree keeping a row of the
+// Levenshtein table for the path so far against
+// each prefix of the query, as in a Levenshtein
+// automaton, and skips a whole subtree as soon as
+// every entry of the row exceeds maxEdits, since
+// no key under it can then come within maxEdits.
+func (t *Tree) FuzzyFind(query Key, maxEdits int) (matches []FuzzyMatch) {
+	if !t.SkipLocking {
+		rl := t.rlock()
+		defer rl.RUnlock()
+	}
+	if t.root == nil || maxEdits < 0 {
+		return nil
+	}
+	w := &fuzzyWalk{k: maxEdits}
+	for rest := []byte(query); len(rest) > 0; {
+		c, n := decodeChar(rest, true)
+		w.query = append(w.query, c)
+		rest = rest[n:]
+	}
+	first := make([]int, len(w.query)+1)
+	for j := range first {
+		first[j] = j
+	}
+	w.rows = append(w.rows, first)
+	w.walk(t.root, 0, fuzzyState{})
+	return w.out
+}
+
+// decodeChar returns the first character of b, and
+// its length in bytes: a rune, or for a byte that
+// does not start valid UTF-8, the byte as -1-b, so
+// that it differs from every rune and other byte.
+// An incomplete rune at the end of b is taken as
+// such bytes only if end is set; otherwise n is 0.
+func decodeChar(b []byte, end bool) (c rune, n int) {
+	if !end && !utf8.FullRune(b) {
+		return 0, 0
+	}
+	c, n = utf8.DecodeRune(b)
+	if c == utf8.RuneError && n == 1 {
+		c = -1 - rune(b[0])
+	}
+	return
+}
+
+// fuzzyWalk is the state of a FuzzyFind
+// going down the tree.
+type fuzzyWalk struct {
+	query []rune // as decodeChar gives it
+	k     int
+
+	// rows[i] is the row of the Levenshtein table
+	// after the i-th character of the path: rows[i][j]
+	// is the distance between the path's first i
+	// characters and the query's first j. The rows
+	// are reused by each subtree in turn.
+	rows [][]int
+
+	// path holds the key bytes from the
+	// root to the node being walked.
+	path []byte
+
+	out []FuzzyMatch
+}
+
+// fuzzyState is where a fuzzyWalk is in a key: at
+// row level, with the first np bytes of a rune
+// in pend, not yet complete.
+type fuzzyState struct {
+	level int
+	pend  [utf8.UTFMax]byte
+	np    int
+}
+
+// feed returns st after the bytes of b, and
+// whether any key going on from there can
+// still come within k edits.
+func (w *fuzzyWalk) feed(st fuzzyState, b []byte, end bool) (fuzzyState, bool) {
+	for i := 0; i < len(b) || (end && st.np > 0); {
+		if i < len(b) {
+			st.pend[st.np] = b[i]
+			st.np++
+			i++
+		}
+		c, n := decodeChar(st.pend[:st.np], end && i == len(b))
+		for n > 0 {
+			if !w.step(st.level, c) {
+				return st, false
+			}
+			st.level++
+			copy(st.pend[:], st.pend[n:st.np])
+			st.np -= n
+			c, n = decodeChar(st.pend[:st.np], end && i == len(b))
+		}
+	}
+	return st, true
+}
+
+// step fills rows[level+1] from rows[level],
+// for one more character c of the path. It
+// reports whether the new row has an entry
+// within k.
+func (w *fuzzyWalk) step(level int, c rune) bool {
+	if len(w.rows) == level+1 {
+		w.rows = append(w.rows, make([]int, len(w.query)+1))
+	}
+	prev, row := w.rows[level], w.rows[level+1]
+	row[0] = prev[0] + 1
+	best := row[0]
+	for j, q := range w.query {
+		cost := prev[j]
+		if q != c {
+			cost++
+		}
+		row[j+1] = min(cost, prev[j+1]+1, row[j]+1)
+		best = min(best, row[j+1])
+	}
+	return best <= w.k
+}
+
+// walk adds the matches under b to w.out, where
+// st is the state after the first depth bytes
+// of its keys, which are in w.path.
+func (w *fuzzyWalk) walk(b *bnode, depth int, st fuzzyState) {
+	if b.isLeaf {
+		w.leaf(b.leaf, depth, st)
+		return
+	}
+	n := b.inner
+	if bk, ok := n.asBucket(); ok {
+		for _, lf := range bk.leaves {
+			w.leaf(lf, depth, st)
+		}
+		return
+	}
+	st, ok := w.feed(st, n.compressed, false)
+	if !ok {
+		return
+	}
+	w.path = append(w.path, n.compressed...)
+	pos := depth + len(n.compressed)
+	for kb, ch := range n.kids() {
+		if ch.isLeaf {
+			// a leaf holds its own key byte.
+			w.leaf(ch.leaf, pos, st)
+			continue
+		}
+		if _, ok := ch.inner.asBucket(); ok {
+			w.walk(ch, pos, st)
+			continue
+		}
+		if st2, ok := w.feed(st, []byte{kb}, false); ok {
+			w.path = append(w.path, kb)
+			w.walk(ch, pos+1, st2)
+			w.path = w.path[:pos]
+		}
+	}
+	w.path = w.path[:depth]
+}
+
+// leaf adds lf to w.out if it matches, where
+// st is the state after the first depth bytes
+// of its key.
+func (w *fuzzyWalk) leaf(lf *Leaf, depth int, st fuzzyState) {
+	rest := lf.Key
+	if lf.base == 0 {
+		rest = lf.Key[min(depth, len(lf.Key)):]
+	}
+	st, ok := w.feed(st, rest, true)
+	if !ok {
+		return
+	}
+	if d := w.rows[st.level][len(w.query)]; d <= w.k {
+		w.out = append(w.out, FuzzyMatch{Leaf: lf.full(w.path), Dist: d})
+	}
+}
@@ -0,0 +1,194 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// levenshtein is the plain dynamic program,
+// over the characters decodeChar gives.
+func levenshtein(a, b []byte) int {
+	chars := func(s []byte) (cs []rune) {
+		for len(s) > 0 {
+			c, n := decodeChar(s, true)
+			cs = append(cs, c)
+			s = s[n:]
+		}
+		return
+	}
+	x, y := chars(a), chars(b)
+	row := make([]int, len(y)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for _, c := range x {
+		prev := row[0]
+		row[0]++
+		for j, d := range y {
+			cost := prev
+			if c != d {
+				cost++
+			}
+			prev = row[j+1]
+			row[j+1] = min(cost, row[j+1]+1, row[j]+1)
+		}
+	}
+	return row[len(y)]
+}
+
+// bruteFuzzy is FuzzyFind by checking every key.
+func bruteFuzzy(keys [][]byte, query []byte, k int) (out []string) {
+	for _, key := range keys {
+		if d := levenshtein(key, query); d <= k {
+			out = append(out, fmt.Sprintf("%s:%v", key, d))
+		}
+	}
+	return
+}
+
+func fuzzyStrings(ms []FuzzyMatch) (out []string) {
+	for _, m := range ms {
+		out = append(out, fmt.Sprintf("%s:%v", m.Leaf.Key, m.Dist))
+	}
+	return
+}
+
+func TestFuzzyFind_small(t *testing.T) {
+	var keys [][]byte
+	tree := NewArtTree()
+	for _, k := range []string{"act", "at", "cart", "cast", "cat", "catalog", "coat", "dog", "中国", "中文", "国"} {
+		keys = append(keys, []byte(k))
+		tree.Insert(Key(k), k)
+	}
+	for _, c := range []struct {
+		query string
+		k     int
+		want  string
+	}{
+		{"cat", 0, "[cat:0]"},
+		{"cat", 1, "[at:1 cart:1 cast:1 cat:0 coat:1]"},
+		{"dgo", 2, "[dog:2]"},
+		{"", 2, "[at:2 中国:2 中文:2 国:1]"},
+		{"中国", 1, "[中国:0 中文:1 国:1]"},
+		{"cat", -1, "[]"},
+	} {
+		if got := fmt.Sprint(fuzzyStrings(tree.FuzzyFind(Key(c.query), c.k))); got != c.want {
+			t.Fatalf("FuzzyFind(%q, %v) = %v, want %v", c.query, c.k, got, c.want)
+		}
+	}
+	if ms := tree.FuzzyFind(Key("cat"), 0); ms[0].Leaf.Value != "cat" {
+		t.Fatalf("value %v", ms[0].Leaf.Value)
+	}
+	for _, q := range []string{"cta", "catlog", "c", "", "中", "国中", "dogs"} {
+		for k := range 4 {
+			got := fuzzyStrings(tree.FuzzyFind(Key(q), k))
+			if want := bruteFuzzy(keys, []byte(q), k); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("FuzzyFind(%q, %v) = %v, want %v", q, k, got, want)
+			}
+		}
+	}
+	if ms := NewArtTree().FuzzyFind(Key("a"), 3); ms != nil {
+		t.Fatalf("empty tree: %v", ms)
+	}
+}
+
+// FuzzyFind against a check of every word, with the
+// words of both corpora, and typos made at random.
+func TestFuzzyFind_matches_brute_force(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(24, 24))
+	for _, file := range []string{"assets/words.txt", "assets/hsk_words.txt"} {
+		words := sortedKeys(loadTestFile(file))
+		for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}, {WithBuckets(16)}} {
+			tree := NewArtTree(opts...)
+			for _, w := range words {
+				tree.Insert(w, nil)
+			}
+			for trial := range 8 {
+				q := typo(rng, words[rng.IntN(len(words))])
+				k := trial % 3
+				got := fuzzyStrings(tree.FuzzyFind(q, k))
+				want := bruteFuzzy(words, q, k)
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Fatalf("%v %v: FuzzyFind(%q, %v) = %v\nwant %v", file, opts, q, k, got, want)
+				}
+			}
+		}
+	}
+}
+
+// typo returns w with an edit or two made at
+// random: a character inserted, deleted, or
+// changed, or two swapped.
+func typo(rng *mathrand2.Rand, w []byte) []byte {
+	r := []rune(string(w))
+	for range 1 + rng.IntN(2) {
+		i := rng.IntN(len(r) + 1)
+		switch rng.IntN(4) {
+		case 0:
+			r = append(r[:i], append([]rune{'a' + rune(rng.IntN(26))}, r[i:]...)...)
+		case 1:
+			if i < len(r) {
+				r = append(r[:i], r[i+1:]...)
+			}
+		case 2:
+			if i < len(r) {
+				r[i] = 'e'
+			}
+		default:
+			if i+1 < len(r) {
+				r[i], r[i+1] = r[i+1], r[i]
+			}
+		}
+	}
+	return []byte(string(r))
+}
+
+func TestFuzzyFind_binary_keys(t *testing.T) {
+	tree := NewArtTree()
+	keys := [][]byte{{0xff, 'a'}, {0xfe, 'a'}, {0xe4, 0xb8}, {0xe4, 0xb8, 0xad}, []byte("a")}
+	for _, k := range keys {
+		tree.Insert(k, nil)
+	}
+	for _, q := range [][]byte{{0xff}, {0xe4}, {0xe4, 0xb8}, []byte("中"), []byte("a")} {
+		for k := range 3 {
+			got := fuzzyStrings(tree.FuzzyFind(q, k))
+			want := bruteFuzzy(sortedKeys(keys), q, k)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("FuzzyFind(%x, %v) = %q, want %q", q, k, got, want)
+			}
+		}
+	}
+}
+
+func sortedKeys(keys [][]byte) [][]byte {
+	s := append([][]byte{}, keys...)
+	slices.SortFunc(s, bytes.Compare)
+	return s
+}
+
+// FuzzyFind on the words, against checking every one.
+func BenchmarkFuzzyFind(b *testing.B) {
+	for _, file := range []string{"assets/words.txt", "assets/hsk_words.txt"} {
+		words := loadTestFile(file)
+		tree := NewArtTree()
+		for _, w := range words {
+			tree.Insert(w, nil)
+		}
+		q := words[len(words)/3]
+		for k := range 3 {
+			b.Run(fmt.Sprintf("%v/k_%v/FuzzyFind", file, k), func(b *testing.B) {
+				for range b.N {
+					tree.FuzzyFind(q, k)
+				}
+			})
+		}
+		b.Run(fmt.Sprintf("%v/k_2/brute_force", file), func(b *testing.B) {
+			for range b.N {
+				bruteFuzzy(words, q, 2)
+			}
+		})
+	}
+}
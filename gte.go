@@ -150,6 +150,9 @@ func (n *inner) getGTE(
 		} else {
 			dir = needPrevLeaf
 			value, _ = n.recursiveFirst()
+			// set found to allow GTE queries smaller
+			// than the smallest key in the tree to answer correctly.
+			found = (calldepth == 0)
 			return
 		}
 	} // end if !fullmatch
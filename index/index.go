@@ -0,0 +1,362 @@
+// Package index keeps secondary indexes of a
+// uart.Tree in step with it.
+//
+// A Table holds records by their primary key, in
+// a primary uart.Tree, and any number of named
+// indexes, each a uart.Tree of its own. An index is
+// defined by a func that extracts the index key from
+// a record, and may require its keys to be unique.
+// Composite index keys, such as (owner, created),
+// are best made with the keyenc package, so that
+// they order as the tuples would.
+//
+// Put and Delete update the primary tree and every
+// index together: a Put that would break a unique
+// index changes nothing, and no reader of the Table
+// sees a record in one tree but not yet in another.
+//
+// In an index tree, each record has the key
+//
+//	keyenc.Encode(indexKey, primaryKey)
+//
+// whose value is the primary key. keyenc keeps the
+// entries in index key order, then primary key
+// order, and the encoding of indexKey alone is a
+// prefix of the keys of all its entries, and of
+// no others. So an index counts each record once
+// in its order statistics, however many records
+// share an index key, and Lookup and At reach
+// the records by their rank in the index.
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/glycerine/uart"
+	"github.com/glycerine/uart/keyenc"
+)
+
+// ErrUnique is returned (wrapped) by Put and AddIndex
+// when two records would have the same key in a
+// unique index.
+var ErrUnique = errors.New("index: duplicate key in a unique index")
+
+// ErrIndexExists is returned by AddIndex when the
+// Table already has an index of the name.
+var ErrIndexExists = errors.New("index: index already exists")
+
+// Extractor returns the key of the record v in an
+// index. If ok is false, the record is left out of
+// the index, as for a record without an email in
+// an index of emails. An Extractor must depend only
+// on v, and must not call the Table.
+type Extractor[V any] func(v V) (key uart.Key, ok bool)
+
+// Seek says where a Lookup goes in an index: to
+// the first record whose index key is Mod Key,
+// for GTE, GT, and Exact, or to the last one, for
+// LTE and LT. Among records with the same index
+// key, first and last go by primary key.
+type Seek struct {
+	Mod uart.SearchModifier
+	Key uart.Key
+}
+
+// Table is a primary tree of records of type V, and
+// its secondary indexes. All of its methods are safe
+// for concurrent use.
+type Table[V any] struct {
+	opts []uart.TreeOption
+
+	// mu makes each Put and Delete one change to all
+	// of the trees, as far as the readers can tell.
+	// Reads that search a tree (Find, FindExact, At)
+	// take it exclusively too: they bring the tree's
+	// lazily kept ranks, and At's cache, up to date.
+	// Only Size and CountPrefix are pure reads.
+	mu      sync.RWMutex
+	primary *uart.Tree
+	indexes map[string]*secondary[V]
+	order   []*secondary[V] // in the order added
+}
+
+// secondary is one index of a Table.
+type secondary[V any] struct {
+	name    string
+	extract Extractor[V]
+	unique  bool
+	tree    *uart.Tree
+}
+
+// New returns a new, empty Table with no indexes.
+// The opts are applied to the primary tree, and
+// to the tree of each index.
+func New[V any](opts ...uart.TreeOption) *Table[V] {
+	return &Table[V]{
+		opts:    opts,
+		primary: newTree(opts),
+		indexes: make(map[string]*secondary[V]),
+	}
+}
+
+// newTree returns a tree with opts, whose
+// locking is left to its Table.
+func newTree(opts []uart.TreeOption) *uart.Tree {
+	t := uart.NewArtTree(opts...)
+	t.SkipLocking = true
+	return t
+}
+
+// AddIndex adds the index name, whose keys are
+// given by extract, and fills it from the records
+// already in the Table. If unique is set, no two
+// records may have the same key in the index: if
+// two already do, AddIndex returns ErrUnique and
+// adds no index.
+func (t *Table[V]) AddIndex(name string, extract Extractor[V], unique bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.indexes[name]; ok {
+		return fmt.Errorf("%w: %q", ErrIndexExists, name)
+	}
+	s := &secondary[V]{
+		name:    name,
+		extract: extract,
+		unique:  unique,
+		tree:    newTree(t.opts),
+	}
+	for pk, lf := range uart.Ascend(t.primary, nil, nil) {
+		v, _ := lf.(*uart.Leaf).Value.(V)
+		key, ok := extract(v)
+		if !ok {
+			continue
+		}
+		if unique && s.tree.CountPrefix(entryPrefix(key)) > 0 {
+			return s.clash(key)
+		}
+		s.tree.Insert(entryKey(key, pk), append(uart.Key{}, pk...))
+	}
+	t.indexes[name] = s
+	t.order = append(t.order, s)
+	return nil
+}
+
+// entryPrefix returns the prefix of the keys of the
+// entries of index key in an index tree.
+func entryPrefix(key uart.Key) uart.Key {
+	return keyenc.MustEncode([]byte(key))
+}
+
+// entryKey returns the key of the entry of the
+// record with primary key pk in an index tree.
+func entryKey(key, pk uart.Key) uart.Key {
+	return keyenc.MustEncode([]byte(key), []byte(pk))
+}
+
+// prefixEnd returns the smallest key greater
+// than every key starting with prefix, which
+// ends in keyenc's terminator, and so never
+// in 0xff.
+func prefixEnd(prefix uart.Key) uart.Key {
+	end := append(uart.Key{}, prefix...)
+	end[len(end)-1]++
+	return end
+}
+
+func (s *secondary[V]) clash(key uart.Key) error {
+	return fmt.Errorf("%w: key %q in index %q", ErrUnique, key, s.name)
+}
+
+// change is the update that a Put makes to
+// one index: the entry keys of the record
+// before and after, or nil if it has none.
+type change struct {
+	old, new uart.Key
+}
+
+// Put stores v as the record of primary key pk,
+// replacing any record pk had, and updates every
+// index to match. If v would have the key of
+// another record in a unique index, Put returns
+// ErrUnique and changes nothing.
+func (t *Table[V]) Put(pk uart.Key, v V) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old, had := t.get(pk)
+
+	// work out every change before making any, so
+	// that a clash, or a panic in an Extractor,
+	// leaves the trees as they were.
+	changes := make([]change, len(t.order))
+	for i, s := range t.order {
+		c := &changes[i]
+		if had {
+			if key, ok := s.extract(old); ok {
+				c.old = entryKey(key, pk)
+			}
+		}
+		key, ok := s.extract(v)
+		if !ok {
+			continue
+		}
+		c.new = entryKey(key, pk)
+		// pk's own entry for key is no clash.
+		if s.unique && !bytes.Equal(c.old, c.new) &&
+			s.tree.CountPrefix(entryPrefix(key)) > 0 {
+			return s.clash(key)
+		}
+	}
+
+	t.primary.Insert(pk, v)
+	for i, s := range t.order {
+		c := &changes[i]
+		if c.old != nil && !bytes.Equal(c.old, c.new) {
+			s.tree.Remove(c.old)
+		}
+		if c.new != nil {
+			s.tree.Insert(c.new, append(uart.Key{}, pk...))
+		}
+	}
+	return nil
+}
+
+// Delete removes the record of primary key pk, and
+// its entries in the indexes. It returns the record,
+// and whether there was one.
+func (t *Table[V]) Delete(pk uart.Key) (v V, deleted bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, deleted = t.get(pk)
+	if !deleted {
+		return
+	}
+	var olds []uart.Key
+	for _, s := range t.order {
+		var old uart.Key
+		if key, ok := s.extract(v); ok {
+			old = entryKey(key, pk)
+		}
+		olds = append(olds, old)
+	}
+	t.primary.Remove(pk)
+	for i, s := range t.order {
+		if olds[i] != nil {
+			s.tree.Remove(olds[i])
+		}
+	}
+	return
+}
+
+// get returns the record of pk. The
+// caller holds mu.
+func (t *Table[V]) get(pk uart.Key) (v V, ok bool) {
+	val, _, ok := t.primary.FindExact(pk)
+	v, _ = val.(V)
+	return
+}
+
+// Get returns the record of primary key pk, and
+// whether there is one.
+func (t *Table[V]) Get(pk uart.Key) (v V, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.get(pk)
+}
+
+// Len returns the number of records in the Table.
+func (t *Table[V]) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.primary.Size()
+}
+
+// index returns the index name, and panics if
+// there is none: the indexes of a Table are set
+// up before it is used, so a missing one is a bug.
+func (t *Table[V]) index(name string) *secondary[V] {
+	s, ok := t.indexes[name]
+	if !ok {
+		panic(fmt.Sprintf("index: no index %q", name))
+	}
+	return s
+}
+
+// Lookup finds the record that seek goes to in
+// the index name, and returns its primary key,
+// the record, and its rank idx in the index: the
+// number of records before it in index order.
+// At(name, idx+1) is then the next record in the
+// index, and At(name, idx-1) the one before.
+// Lookup panics if the Table has no index name.
+func (t *Table[V]) Lookup(name string, seek Seek) (pk uart.Key, v V, idx int, found bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.index(name)
+	prefix := entryPrefix(seek.Key)
+	var lf *uart.Leaf
+	switch seek.Mod {
+	case uart.GTE:
+		lf, idx, found = s.tree.Find(uart.GTE, prefix)
+	case uart.GT:
+		lf, idx, found = s.tree.Find(uart.GTE, prefixEnd(prefix))
+	case uart.LTE:
+		lf, idx, found = s.tree.Find(uart.LT, prefixEnd(prefix))
+	case uart.LT:
+		lf, idx, found = s.tree.Find(uart.LT, prefix)
+	default:
+		// the entries of seek.Key all start with prefix.
+		lf, idx, found = s.tree.Find(uart.GTE, prefix)
+		if found && !bytes.HasPrefix(lf.Key, prefix) {
+			found = false
+		}
+	}
+	if !found {
+		return nil, v, idx, false
+	}
+	pk = lf.Value.(uart.Key)
+	v, _ = t.get(pk)
+	return
+}
+
+// At returns the primary key and record of the
+// i-th record in the index name, in index order.
+// ok is false if i is out of range. At panics if
+// the Table has no index name.
+func (t *Table[V]) At(name string, i int) (pk uart.Key, v V, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lf, ok := t.index(name).tree.At(i)
+	if !ok {
+		return nil, v, false
+	}
+	pk = lf.Value.(uart.Key)
+	v, _ = t.get(pk)
+	return
+}
+
+// Count returns the number of records whose key
+// in the index name is key. Count panics if the
+// Table has no index name.
+func (t *Table[V]) Count(name string, key uart.Key) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.index(name).tree.CountPrefix(entryPrefix(key))
+}
+
+// IndexLen returns the number of records in the
+// index name, which is fewer than Len if its
+// Extractor leaves some records out. IndexLen
+// panics if the Table has no index name.
+func (t *Table[V]) IndexLen(name string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.index(name).tree.Size()
+}
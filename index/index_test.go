@@ -0,0 +1,345 @@
+package index
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glycerine/uart"
+	"github.com/glycerine/uart/keyenc"
+)
+
+type record struct {
+	ID      string
+	Owner   string
+	Created time.Time
+	Email   string // may be empty
+}
+
+func byOwnerCreated(r record) (uart.Key, bool) {
+	return keyenc.MustEncode(r.Owner, r.Created), true
+}
+
+func byEmail(r record) (uart.Key, bool) {
+	return uart.Key(r.Email), r.Email != ""
+}
+
+func newTable(t *testing.T, opts ...uart.TreeOption) *Table[record] {
+	tab := New[record](opts...)
+	if err := tab.AddIndex("owner_created", byOwnerCreated, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := tab.AddIndex("email", byEmail, true); err != nil {
+		t.Fatal(err)
+	}
+	return tab
+}
+
+// checkTable fails unless each index of tab holds
+// exactly the records of model, in index order.
+func checkTable(t *testing.T, tab *Table[record], model map[string]record) {
+	t.Helper()
+	if tab.Len() != len(model) {
+		t.Fatalf("Len %v, want %v", tab.Len(), len(model))
+	}
+	for name, extract := range map[string]Extractor[record]{
+		"owner_created": byOwnerCreated, "email": byEmail,
+	} {
+		// in index order: by index key, then id.
+		type entry struct{ key, id string }
+		var want []entry
+		for id, r := range model {
+			if key, ok := extract(r); ok {
+				want = append(want, entry{string(key), id})
+			}
+		}
+		slices.SortFunc(want, func(a, b entry) int {
+			return cmp.Or(strings.Compare(a.key, b.key), strings.Compare(a.id, b.id))
+		})
+		if n := tab.IndexLen(name); n != len(want) {
+			t.Fatalf("%v: IndexLen %v, want %v", name, n, len(want))
+		}
+		for i, w := range want {
+			pk, r, ok := tab.At(name, i)
+			if !ok || string(pk) != w.id || r != model[w.id] {
+				t.Fatalf("%v: At(%v) = %q, %v, %v; want %q", name, i, pk, r, ok, w.id)
+			}
+		}
+		if _, _, ok := tab.At(name, len(want)); ok {
+			t.Fatalf("%v: At past the end", name)
+		}
+	}
+}
+
+func TestTable_matches_model(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(22, 22))
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, opts := range [][]uart.TreeOption{nil, {uart.WithBuckets(4)}} {
+		tab := newTable(t, opts...)
+		model := map[string]record{}
+		emails := map[string]string{} // email to id
+		for i := range 3000 {
+			id := fmt.Sprintf("id%02d", rng.IntN(60))
+			if rng.IntN(4) == 0 {
+				old, had := model[id]
+				r, deleted := tab.Delete(uart.Key(id))
+				if deleted != had || r != old {
+					t.Fatalf("Delete(%v) = %v, %v; want %v, %v", id, r, deleted, old, had)
+				}
+				delete(model, id)
+				delete(emails, old.Email)
+				continue
+			}
+			r := record{
+				ID:      id,
+				Owner:   fmt.Sprintf("owner%v", rng.IntN(5)),
+				Created: t0.Add(time.Duration(rng.IntN(20)) * time.Hour),
+			}
+			if rng.IntN(3) != 0 {
+				r.Email = fmt.Sprintf("u%v@example.com", rng.IntN(40))
+			}
+			err := tab.Put(uart.Key(id), r)
+			if other, taken := emails[r.Email]; r.Email != "" && taken && other != id {
+				if !errors.Is(err, ErrUnique) {
+					t.Fatalf("Put of taken email %v: err %v", r.Email, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Put(%v): %v", id, err)
+				}
+				delete(emails, model[id].Email)
+				model[id] = r
+				if r.Email != "" {
+					emails[r.Email] = id
+				}
+			}
+			if i%300 == 0 {
+				checkTable(t, tab, model)
+			}
+		}
+		checkTable(t, tab, model)
+		for id, r := range model {
+			if got, ok := tab.Get(uart.Key(id)); !ok || got != r {
+				t.Fatalf("Get(%v) = %v, %v", id, got, ok)
+			}
+		}
+	}
+}
+
+func TestTable_Lookup(t *testing.T) {
+	tab := New[record]()
+	if err := tab.AddIndex("owner", func(r record) (uart.Key, bool) { return uart.Key(r.Owner), true }, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []record{
+		{ID: "1", Owner: "bob"}, {ID: "2", Owner: "carol"}, {ID: "3", Owner: "bob"},
+		{ID: "4", Owner: "ann"}, {ID: "5", Owner: "carol"}, {ID: "6", Owner: "bob"},
+	} {
+		if err := tab.Put(uart.Key(r.ID), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// in index order: ann/4, bob/1, bob/3, bob/6, carol/2, carol/5.
+	for _, c := range []struct {
+		mod   uart.SearchModifier
+		key   string
+		id    string
+		idx   int
+		found bool
+	}{
+		{uart.Exact, "bob", "1", 1, true},
+		{uart.Exact, "bo", "", 1, false},
+		{uart.GTE, "bob", "1", 1, true},
+		{uart.GT, "bob", "2", 4, true},
+		{uart.LTE, "bob", "6", 3, true},
+		{uart.LT, "bob", "4", 0, true},
+		{uart.GTE, "b", "1", 1, true},
+		{uart.LT, "ann", "", 0, false},
+		{uart.GT, "carol", "", 6, false},
+		{uart.LTE, "zed", "5", 5, true},
+	} {
+		pk, r, idx, found := tab.Lookup("owner", Seek{Mod: c.mod, Key: uart.Key(c.key)})
+		if found != c.found || (found && (string(pk) != c.id || r.ID != c.id || idx != c.idx)) {
+			t.Fatalf("Lookup(%v %q) = %q, %v, %v; want %q, %v, %v", c.mod, c.key, pk, idx, found, c.id, c.idx, c.found)
+		}
+	}
+	if n := tab.Count("owner", uart.Key("bob")); n != 3 {
+		t.Fatalf("Count(bob) = %v", n)
+	}
+
+	// step through bob's records from the first.
+	_, _, idx, _ := tab.Lookup("owner", Seek{Key: uart.Key("bob")})
+	var ids []string
+	for i := idx; ; i++ {
+		pk, r, ok := tab.At("owner", i)
+		if !ok || r.Owner != "bob" {
+			break
+		}
+		ids = append(ids, string(pk))
+	}
+	if fmt.Sprint(ids) != "[1 3 6]" {
+		t.Fatalf("bob's records: %v", ids)
+	}
+}
+
+func TestTable_unique_clash_changes_nothing(t *testing.T) {
+	tab := newTable(t)
+	a := record{ID: "a", Owner: "x", Email: "a@x"}
+	b := record{ID: "b", Owner: "y", Email: "b@y"}
+	tab.Put(uart.Key("a"), a)
+	tab.Put(uart.Key("b"), b)
+
+	// b takes a's email: refused, and b keeps its
+	// old record, owner and all.
+	err := tab.Put(uart.Key("b"), record{ID: "b", Owner: "z", Email: "a@x"})
+	if !errors.Is(err, ErrUnique) || !strings.Contains(err.Error(), `"email"`) {
+		t.Fatalf("err = %v", err)
+	}
+	checkTable(t, tab, map[string]record{"a": a, "b": b})
+
+	// a record may keep its own email.
+	a.Owner = "w"
+	if err := tab.Put(uart.Key("a"), a); err != nil {
+		t.Fatal(err)
+	}
+	checkTable(t, tab, map[string]record{"a": a, "b": b})
+
+	// once a lets go of it, b can have it.
+	a.Email = ""
+	tab.Put(uart.Key("a"), a)
+	b.Email = "a@x"
+	if err := tab.Put(uart.Key("b"), b); err != nil {
+		t.Fatal(err)
+	}
+	checkTable(t, tab, map[string]record{"a": a, "b": b})
+}
+
+func TestTable_AddIndex(t *testing.T) {
+	tab := New[record]()
+	for i := range 10 {
+		id := fmt.Sprint(i)
+		tab.Put(uart.Key(id), record{ID: id, Owner: fmt.Sprint(i % 3), Email: fmt.Sprintf("%v@x", i%5)})
+	}
+	if err := tab.AddIndex("owner", func(r record) (uart.Key, bool) { return uart.Key(r.Owner), true }, true); !errors.Is(err, ErrUnique) {
+		t.Fatalf("unique AddIndex over duplicate owners: %v", err)
+	}
+	if err := tab.AddIndex("owner", func(r record) (uart.Key, bool) { return uart.Key(r.Owner), true }, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := tab.AddIndex("owner", byEmail, false); !errors.Is(err, ErrIndexExists) {
+		t.Fatalf("AddIndex of a second owner: %v", err)
+	}
+	if n := tab.Count("owner", uart.Key("0")); n != 4 {
+		t.Fatalf("Count = %v, want 4", n)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Lookup in a missing index did not panic")
+		}
+	}()
+	tab.Lookup("email", Seek{})
+}
+
+// Readers never see a record in one tree but
+// not another: each entry of the email index
+// leads to a record with that email.
+func TestTable_concurrent_readers(t *testing.T) {
+	tab := newTable(t)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := mathrand2.New(mathrand2.NewPCG(22, uint64(w)))
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				id := fmt.Sprintf("id%v", rng.IntN(20))
+				if rng.IntN(5) == 0 {
+					tab.Delete(uart.Key(id))
+				} else {
+					tab.Put(uart.Key(id), record{ID: id, Owner: "o", Email: fmt.Sprintf("%v@%v", id, i)})
+				}
+			}
+		}()
+	}
+	for range 2000 {
+		for i := range tab.IndexLen("email") {
+			pk, r, ok := tab.At("email", i)
+			if ok && (r.ID != string(pk) || r.Email == "") {
+				t.Fatalf("At(%v) = %q, whose record is %v", i, pk, r)
+			}
+			key := uart.Key(fmt.Sprintf("%s@%v", pk, i))
+			if pk, r, _, found := tab.Lookup("email", Seek{Key: key}); found && (r.ID != string(pk) || r.Email != string(key)) {
+				t.Fatalf("Lookup(%s) = %q, whose record is %v", key, pk, r)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// Reads may bring a tree's lazily kept state up
+// to date, so several readers at once, between
+// writes, must not race; go test -race checks.
+func TestTable_concurrent_At_Lookup(t *testing.T) {
+	tab := newTable(t)
+	for i := range 200 {
+		id := fmt.Sprintf("id%03v", i)
+		tab.Put(uart.Key(id), record{ID: id, Owner: "o", Email: id + "@x"})
+	}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rng := mathrand2.New(mathrand2.NewPCG(22, 1))
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			id := fmt.Sprintf("id%03v", rng.IntN(200))
+			tab.Put(uart.Key(id), record{ID: id, Owner: "o", Email: id + "@x"})
+		}
+	}()
+	var readers sync.WaitGroup
+	for g := range 4 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := range 2000 {
+				j := (i*7 + g) % 200
+				pk, r, ok := tab.At("email", j)
+				if !ok || r.ID != string(pk) {
+					t.Errorf("At(%v) = %q, %v, %v", j, pk, r, ok)
+					return
+				}
+				key := uart.Key(r.Email)
+				pk2, _, idx, found := tab.Lookup("email", Seek{Key: key})
+				if !found || string(pk2) != r.ID || idx != j {
+					t.Errorf("Lookup(%s) = %q, %v, %v; want %q, %v", key, pk2, idx, found, r.ID, j)
+					return
+				}
+				if _, ok := tab.Get(pk); !ok {
+					t.Errorf("Get(%q) missed", pk)
+					return
+				}
+			}
+		}()
+	}
+	readers.Wait()
+	close(stop)
+	wg.Wait()
+}
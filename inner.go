@@ -40,13 +40,26 @@ func (n *inner) compressedMismatch(key Key, depth int) (idx int) {
 
 // selfb must be the bnode holding us, such that
 // selfb.inner == n, always.
-func (n *inner) insert(lf *Leaf, depth int, selfb *bnode, tree *Tree, parent *inner) (replacement *bnode, updated bool) {
+func (n *inner) insert(lf *Leaf, depth int, selfb *bnode, tree *Tree, parent *inner, u *upsert) (replacement *bnode, updated bool) {
+
+	if tree.cow && n.gen != tree.gen {
+		// n is shared with a Snapshot: copy it first.
+		n = n.cowClone(tree.gen)
+		selfb.inner = n
+	}
+	if bk, ok := n.asBucket(); ok {
+		return n.insertBucket(bk, lf, depth, selfb, tree, u)
+	}
+	tree.latch(n)
 
 	// biggest mis is len(n.Compressed) for
 	// full matching with lf.Key
 	mis := n.compressedMismatch(lf.Key, depth)
 
 	if mis < len(n.compressed) {
+		if lf, _ = u.at(nil, lf); lf == nil {
+			return selfb, true
+		}
 
 		// lazy expand
 		// we will overwrite ourself (n) with a new n4 split.
@@ -54,22 +67,27 @@ func (n *inner) insert(lf *Leaf, depth int, selfb *bnode, tree *Tree, parent *in
 		newChildKey := n.compressed[mis]
 		parentCompressed := append([]byte{}, n.compressed[:mis]...)
 
-		newChild := &inner{
+		newChild := tree.slab.inner()
+		*newChild = inner{
 			Node:       n.Node,
 			compressed: n.compressed[mis+1:],
 			// keep path stuff for debugging!
 			//path:       append([]byte{}, lf.Key[:depth+mis]...),
 			SubN: n.SubN,
+			gen:  tree.gen,
 		}
 		//vv("assigned path '%v' to %p", string(newChild.path), newChild)
 		newChild.keybyte = newChildKey
 
 		// n becomes the new parent of newChild and lf
-		n4 := &node4{}
+		n4 := tree.slab.node4()
 		leafKeybyte := lf.Key.At(depth + mis)
+		if tree.compact {
+			lf.rebase(depth+mis, tree.slab)
+		}
 		lf.keybyte = leafKeybyte
-		n4.addChild(leafKeybyte, bnodeLeaf(lf))
-		n4.addChild(newChildKey, bnodeInner(newChild))
+		n4.addChild(leafKeybyte, tree.slab.bnodeLeaf(lf))
+		n4.addChild(newChildKey, tree.slab.bnodeInner(newChild))
 
 		n.Node = n4
 
@@ -106,22 +124,48 @@ func (n *inner) insert(lf *Leaf, depth int, selfb *bnode, tree *Tree, parent *in
 	idx, next := n.Node.child(nextkey)
 
 	if next == nil {
+		if lf, _ = u.at(nil, lf); lf == nil {
+			return selfb, true
+		}
 
 		if n.Node.full() {
-			n.Node = n.Node.grow()
+			old := n.Node
+			n.Node = old.grow(tree.slab)
+			tree.recycler().freeNode(old)
 		}
 		addkey := lf.Key.At(nextDepth)
+		if tree.compact {
+			lf.rebase(nextDepth, tree.slab)
+		}
 		lf.keybyte = addkey
-		n.Node.addChild(addkey, bnodeLeaf(lf))
+		n.Node.addChild(addkey, tree.slab.bnodeLeaf(lf))
 		n.SubN++
 		n.prenOK = false
 
 		return selfb, false
 	}
 
+	if next.isLeaf && u != nil && next.leaf.compare(lf.Key, 0) == 0 {
+		// the key is here. Removing it changes n,
+		// so the decision is made here rather than
+		// in the leaf, which does only plain stores.
+		store, del := u.at(next.leaf, lf)
+		if del {
+			replacement = selfb
+			u.removed = n.delLeaf(idx, lf.Key, depth, selfb, tree, func(b *bnode) {
+				replacement = b
+			})
+			return replacement, true
+		}
+		if store == nil {
+			return selfb, true
+		}
+		lf, u = store, nil
+	}
+
 	if next.isLeaf {
 
-		replacement, updated = next.insert(lf, nextDepth+1, next, tree, n)
+		replacement, updated = next.insert(lf, nextDepth+1, next, tree, n, u)
 
 		n.Node.replace(idx, replacement, false)
 		if !updated {
@@ -140,7 +184,17 @@ func (n *inner) insert(lf *Leaf, depth int, selfb *bnode, tree *Tree, parent *in
 	}
 	// INVAR: next is not a leaf.
 
-	_, updated = next.insert(lf, nextDepth+1, next, tree, n)
+	replacement, updated = next.insert(lf, nextDepth+1, next, tree, n, u)
+	if u != nil && u.removed != nil {
+		if replacement != next {
+			n.Node.replace(idx, replacement, true)
+		}
+		n.SubN--
+		n.prenOK = false
+		n.Node.redoPren()
+		tree.collapseSmall(n)
+		return selfb, true
+	}
 	if !updated {
 		n.SubN++
 		n.prenOK = false
@@ -150,7 +204,11 @@ func (n *inner) insert(lf *Leaf, depth int, selfb *bnode, tree *Tree, parent *in
 	return selfb, updated
 }
 
-func (n *inner) del(key Key, depth int, selfb *bnode, parentUpdate func(*bnode)) (deleted bool, deletedNode *bnode) {
+func (n *inner) del(key Key, depth int, selfb *bnode, tree *Tree, parentUpdate func(*bnode)) (deleted bool, deletedNode *bnode) {
+
+	if bk, ok := n.asBucket(); ok {
+		return n.delBucket(bk, key, selfb, tree, parentUpdate)
+	}
 
 	if _, fullmatch, _ := n.checkCompressed(key, depth); !fullmatch {
 		// key is not found, check for concurrent writes and exit
@@ -166,69 +224,110 @@ func (n *inner) del(key Key, depth int, selfb *bnode, parentUpdate func(*bnode))
 		return false, nil
 	}
 
-	if next.isLeaf && next.leaf.equal(key) {
-		n.SubN--
-		n.prenOK = false
-
-		// deleting a leaf in next
-		_, isNode4 := n.Node.(*node4)
-		atmin := n.Node.min()
-		if isNode4 && atmin {
-			// update parent pointer. current node will
-			// be collapsed from n4 -> leaf.
-
-			deletedNode = n.Node.replace(idx, nil, true)
-
-			// get the left node
-			leftKey, left := n.Node.next(nil)
-
-			// during delete of n, have to give leftB n's prefix
-			if left.isLeaf {
-				left.leaf.addPrefixBefore(n, leftKey)
-			} else {
-				left.inner.addPrefixBefore(n, leftKey)
-			}
-			// left.addPrefixBefore(n, leftB)
-
-			// left is replacing n, because n shrank.
-			parentUpdate(left)
-
-			// NB: replace() is used to delete as well as update,
-			// and happens via the above parentUpdate callback.
-			// In particular, the keys are updated alongside
-			// children pointers.
-
-			// deleted, deletedNode
-			return true, deletedNode
+	if next.isLeaf && next.leaf.compare(key, 0) == 0 {
+		if tree.cow && n.gen != tree.gen {
+			n = n.cowClone(tree.gen)
+			selfb.inner = n
+			idx, _ = n.Node.child(delkey)
 		}
-		// deleting a leaf in next.
-		// n is > node4
-
-		// local change. parent not affected.
-
-		deletedNode = n.Node.replace(idx, nil, true)
-		if atmin && !isNode4 {
-			n.Node = n.Node.shrink()
-		}
-		return true, deletedNode
-
+		tree.latch(n)
+		return true, n.delLeaf(idx, key, depth, selfb, tree, parentUpdate)
 	} else if next.isLeaf {
 		// key is not found.
 		return false, deletedNode
 	}
 	// INVAR: next is not a leaf
 
-	deleted, deletedNode = next.del(key, nextDepth+1, next, func(bn *bnode) {
+	if tree.cow && n.gen != tree.gen {
+		n = n.cowClone(tree.gen)
+		selfb.inner = n
+		idx, next = n.Node.child(delkey)
+	}
+	tree.latch(n)
+	deleted, deletedNode = next.del(key, nextDepth+1, next, tree, func(bn *bnode) {
 		n.Node.replace(idx, bn, true)
 	})
 	if deleted {
 		n.SubN--
 		n.prenOK = false
 		n.Node.redoPren() // essential! for LeafIndex/id to be correct.
+		tree.collapseSmall(n)
 	}
 	return deleted, deletedNode
 }
 
+// delLeaf removes the leaf at idx of n, which holds
+// key, and which n's caller has made its own. If that
+// leaves n with one child, the child takes n's place,
+// by way of parentUpdate. It returns the leaf's bnode.
+func (n *inner) delLeaf(idx int, key Key, depth int, selfb *bnode, tree *Tree, parentUpdate func(*bnode)) (deletedNode *bnode) {
+	n.SubN--
+	n.prenOK = false
+
+	// deleting a leaf in next
+	_, isNode4 := n.Node.(*node4)
+	atmin := n.Node.min()
+	if isNode4 && atmin {
+		// update parent pointer. current node will
+		// be collapsed from n4 -> leaf.
+
+		deletedNode = n.Node.replace(idx, nil, true)
+
+		// get the left node
+		leftKey, left := n.Node.next(nil)
+
+		// during delete of n, have to give leftB n's prefix
+		if left.isLeaf {
+			left.leaf.addPrefixBefore(n, leftKey)
+			if left.leaf.base > 0 {
+				// a compact leaf moves up to n's parent,
+				// which dispatches on the byte before
+				// n's prefix. key shares left's path.
+				lf := left.leaf
+				left = &bnode{
+					leaf:   lf.rebased(lf.fullKey(key), max(depth-1, 0)),
+					isLeaf: true,
+					pren:   left.pren,
+				}
+			}
+		} else {
+			if tree.cow && left.inner.gen != tree.gen {
+				left.inner = left.inner.cowClone(tree.gen)
+			}
+			tree.latch(left.inner)
+			left.inner.addPrefixBefore(n, leftKey)
+		}
+		// left.addPrefixBefore(n, leftB)
+
+		// left is replacing n, because n shrank.
+		parentUpdate(left)
+		if a := tree.recycler(); a != nil {
+			a.freeInner(n)
+			a.freeBnode(selfb)
+		}
+
+		// NB: replace() is used to delete as well as update,
+		// and happens via the above parentUpdate callback.
+		// In particular, the keys are updated alongside
+		// children pointers.
+
+		return deletedNode
+	}
+	// deleting a leaf in next.
+	// n is > node4
+
+	// local change. parent not affected.
+
+	deletedNode = n.Node.replace(idx, nil, true)
+	if atmin && !isNode4 {
+		old := n.Node
+		n.Node = old.shrink(tree.slab)
+		tree.recycler().freeNode(old)
+	}
+	tree.collapseSmall(n)
+	return deletedNode
+}
+
 // checkCompressed returns the number
 // of prefix characters shared between
 // the key and node. fullmatch is
@@ -308,7 +407,7 @@ func (n *inner) get(key Key, depth int, selfb *bnode, calldepth int, tree *Tree)
 	// inlining get(): saves about 20%
 	// value, found, dir, id = next.get(key, nextDepth+1, next, calldepth+1, tree)
 	if next.isLeaf {
-		value, found, dir, id = next.leaf.get(key, nextDepth+1, next)
+		value, found, dir, id = next.leaf.get(key, 0, next)
 	} else {
 		value, found, dir, id = next.inner.get(key, nextDepth+1, next, calldepth+1, tree)
 	}
@@ -329,6 +428,11 @@ func memcpy[T any](dst []T, src []T, len int) {
 // durring delete of node, n needs to have nodes' prefix pre-pended.
 func (n *inner) addPrefixBefore(node *inner, key byte) {
 
+	if _, ok := n.asBucket(); ok {
+		// a bucket has no prefix; its
+		// leaves hold their whole keys.
+		return
+	}
 	// new prefix: { node prefix } { key } { n(this) prefix }
 	nCompressed := n.compressed
 	nodeCompressed := node.compressed
@@ -402,6 +506,12 @@ func (n *inner) FlatString(depth int, recurse int, selfb *bnode) (s string) {
 	if recurse == 0 {
 		return s // just this node.
 	}
+	if bk, ok := n.asBucket(); ok {
+		for _, lf := range bk.leaves {
+			s += lf.FlatString(depth+1, recurse-1)
+		}
+		return s
+	}
 	key, node := n.Node.next(nil)
 	k := 0
 	_ = k
@@ -446,21 +556,27 @@ func (b *bnode) rangestr() string {
 }
 
 func (n *inner) rfirst() *Leaf {
-	_, b := n.first()
 	for {
+		if bk, ok := n.asBucket(); ok {
+			return bk.leaves[0]
+		}
+		_, b := n.first()
 		if b.isLeaf {
 			return b.leaf
 		}
-		_, b = b.first()
+		n = b.inner
 	}
 }
 func (n *inner) rlast() *Leaf {
-	_, b := n.last()
 	for {
+		if bk, ok := n.asBucket(); ok {
+			return bk.leaves[len(bk.leaves)-1]
+		}
+		_, b := n.last()
 		if b.isLeaf {
 			return b.leaf
 		}
-		_, b = b.last()
+		n = b.inner
 	}
 }
 
@@ -497,6 +613,12 @@ func (n *inner) stringNoKeys(depth int, recurse int, selfb *bnode) (s string) {
 	if recurse == 0 {
 		return s // just this node.
 	}
+	if bk, ok := n.asBucket(); ok {
+		for _, lf := range bk.leaves {
+			s += lf.stringNoKeys(depth + 1)
+		}
+		return s
+	}
 	key, node := n.Node.next(nil)
 	k := 0
 	_ = k
@@ -248,6 +248,11 @@ type checkpoint struct {
 	node   *inner
 	curkey *byte
 
+	// at counts the leaves of a bucket node
+	// already passed, in the direction of
+	// iteration; see WithBuckets.
+	at int
+
 	prev *checkpoint
 }
 
@@ -373,6 +378,7 @@ func (i *iterator) init() (exit bool, nextOK bool) {
 
 		chk.prev = nil
 		chk.curkey = nil
+		chk.at = 0
 		// about to overwrite chk.node so no need to clear it.
 	}
 	chk.node = root.inner
@@ -380,9 +386,105 @@ func (i *iterator) init() (exit bool, nextOK bool) {
 	//i.stack = &checkpoint{
 	//	node: root.inner,
 	//}
+	if len(i.cursor) > 0 {
+		i.seek()
+	}
 	return false, false
 }
 
+// seek extends the stack from the root down
+// toward i.cursor, so that iteration begins
+// next to the cursor rather than at the first
+// (last, in reverse) leaf of the tree and
+// scanning forward from there.
+func (i *iterator) seek() {
+	key := i.cursor
+	depth := 0
+	tail := i.stack
+	for {
+		n := tail.node
+		if bk, ok := n.asBucket(); ok {
+			// pass over the leaves behind the cursor.
+			j, found := bk.search(key)
+			if i.reverse {
+				if found {
+					j++
+				}
+				tail.at = len(bk.leaves) - j
+			} else {
+				tail.at = j
+			}
+			return
+		}
+		c := n.compressed
+		part := key[min(depth, len(key)):min(depth+len(c), len(key))]
+		if cmp := bytes.Compare(c, part); cmp != 0 {
+			if (cmp > 0) != i.reverse {
+				// all of n lies ahead of the
+				// cursor; visit all of it.
+				return
+			}
+			// all of n lies behind the cursor,
+			// and our parent's curkey already
+			// points at n, so just skip n.
+			i.stack = tail.prev
+			return
+		}
+		pos := depth + len(c)
+		if pos >= len(key) {
+			// every key under n is >= the cursor.
+			if i.reverse {
+				// only a key equal to the cursor, under
+				// keybyte 0, is not behind us.
+				one := byte(1)
+				tail.curkey = &one
+			}
+			return
+		}
+		kb := key[pos]
+		_, ch := n.Node.child(kb)
+		if ch != nil && !ch.isLeaf {
+			tail.curkey = &kb
+			chk := i.freelist
+			if chk == nil {
+				chk = &checkpoint{}
+			} else {
+				i.freelist = chk.prev
+				chk.curkey = nil
+				chk.at = 0
+			}
+			chk.node = ch.inner
+			chk.prev = tail
+			i.stack = chk
+			tail = chk
+			depth = pos + 1
+			continue
+		}
+		// have next() resume at kb.
+		if !i.reverse && kb > 0 {
+			before := kb - 1
+			tail.curkey = &before
+		}
+		if i.reverse && kb < 255 {
+			after := kb + 1
+			tail.curkey = &after
+		}
+		return
+	}
+}
+
+// pastEnd returns true if key is beyond i.terminate
+// in the direction of iteration.
+func (i *iterator) pastEnd(key []byte) bool {
+	if len(i.terminate) == 0 {
+		return false
+	}
+	if i.reverse {
+		return bytes.Compare(key, i.terminate) <= 0
+	}
+	return bytes.Compare(key, i.terminate) >= 0
+}
+
 func (i *iterator) iterate() bool {
 	for i.stack != nil {
 		more, restart := i.tryAdvance()
@@ -412,8 +514,23 @@ func (i *iterator) tryAdvance() (bool, bool) {
 
 		tail := i.stack
 
+		bk, isBucket := tail.node.asBucket()
+		if isBucket && tail.at < len(bk.leaves) {
+			// a bucket hands out its leaves in turn.
+			j := tail.at
+			if i.reverse {
+				j = len(bk.leaves) - 1 - j
+			}
+			tail.at++
+			return i.visit(bk.leaves[j]), false
+		}
+
 		//vv("tryAdv calling i.next() with tail.curkey = '%#v'", tail.curkey) // nil on first call
-		curkey, child := i.next(tail.node, tail.curkey)
+		var curkey byte
+		var child *bnode
+		if !isBucket {
+			curkey, child = i.next(tail.node, tail.curkey)
+		}
 		if child == nil {
 
 			// inner node is exhausted, move one level up the stack
@@ -434,18 +551,7 @@ func (i *iterator) tryAdvance() (bool, bool) {
 		tail.curkey = &curkey
 
 		if child.isLeaf {
-			l := child.leaf
-			if i.inRange(l.Key) {
-				//vv("inRange true")
-				i.key = l.Key
-				i.value = l.Value
-				i.cursor = l.Key
-				i.leaf = l
-				return true, false
-			}
-			//vv("inRange false")
-			return false, false
-
+			return i.visit(child.leaf), false
 		}
 		chk := i.freelist
 		if chk == nil {
@@ -453,6 +559,7 @@ func (i *iterator) tryAdvance() (bool, bool) {
 		} else {
 			i.freelist = chk.prev
 			chk.curkey = nil
+			chk.at = 0
 			// about to overwrite chk.node and chk.prev, so no need to clear
 		}
 		chk.node = child.inner
@@ -466,6 +573,28 @@ func (i *iterator) tryAdvance() (bool, bool) {
 	}
 }
 
+// visit makes l, the next leaf in the direction of
+// iteration, the current one if it is in range,
+// and reports whether it did.
+func (i *iterator) visit(l *Leaf) bool {
+	key := i.fullKey(l)
+	if i.inRange(key) {
+		//vv("inRange true")
+		i.key = key
+		i.value = l.Value
+		i.cursor = key
+		i.leaf = l
+		return true
+	}
+	//vv("inRange false")
+	if i.pastEnd(key) {
+		// keys come in order, so no later
+		// leaf can be in range either.
+		i.stack = nil
+	}
+	return false
+}
+
 func (i *iterator) next(n *inner, curkey *byte) (keyb byte, b *bnode) {
 	//defer func() {
 	//	vv("it.next returning keyb='%v', b='%v'", string(keyb), b.String())
@@ -476,7 +605,45 @@ func (i *iterator) next(n *inner, curkey *byte) (keyb byte, b *bnode) {
 	return n.Node.prev(curkey)
 }
 
+// fullKey returns the whole key of l, a child
+// of the node on top of the stack. A compact leaf
+// holds only its last bytes; the rest are the
+// path of compressed prefixes and keybytes that
+// the stack took to get to it.
+func (i *iterator) fullKey(l *Leaf) Key {
+	if l.base == 0 {
+		return l.Key
+	}
+	b := int(l.base)
+	k := make(Key, b+len(l.Key))
+	copy(k[b:], l.Key)
+	end := 0
+	for c := i.stack; c != nil; c = c.prev {
+		end += len(c.node.compressed) + 1
+	}
+	// fill in the path from the leaf up.
+	for c := i.stack; c != nil; c = c.prev {
+		end--
+		if end < b {
+			k[end] = *c.curkey
+		}
+		for j := len(c.node.compressed) - 1; j >= 0; j-- {
+			end--
+			if end < b {
+				k[end] = c.node.compressed[j]
+			}
+		}
+	}
+	return k
+}
+
+// Leaf returns the current leaf. In a tree with
+// compact leaves, it is a copy holding the
+// whole key; see WithCompactLeaves.
 func (i *iterator) Leaf() *Leaf {
+	if i.leaf != nil && i.leaf.base > 0 {
+		return &Leaf{Key: i.key, Value: i.leaf.Value}
+	}
 	return i.leaf
 }
 
@@ -550,12 +717,15 @@ func Descend(t *Tree, endx, start Key) iter.Seq2[Key, any] {
 	}
 }
 
-// dfs does depth-first-search.
+// dfs does depth-first-search, yielding each
+// node after its children, with its depth:
+// 0 for the root, 1 for its children, and so on.
 //
 // Useful for debugging/visualizing
-// the full tree. Used in some tests.
-func dfs(root *bnode) iter.Seq2[*bnode, bool] {
-	return func(yield func(*bnode, bool) bool) {
+// the full tree. Used in some tests,
+// and by CompressedStats and MemStats.
+func dfs(root *bnode) iter.Seq2[*bnode, int] {
+	return func(yield func(*bnode, int) bool) {
 
 		// Helper function for recursive traversal
 		var visit func(keybyte byte, root *bnode, depth int) bool
@@ -563,7 +733,7 @@ func dfs(root *bnode) iter.Seq2[*bnode, bool] {
 
 			if root.isLeaf {
 				//case *Leaf:
-				return yield(root, true)
+				return yield(root, d)
 			} else {
 				//case *inner:
 				inode := root.inner.Node // interface
@@ -602,9 +772,15 @@ func dfs(root *bnode) iter.Seq2[*bnode, bool] {
 							}
 						}
 					}
+				case *bucket:
+					for _, lf := range n.leaves {
+						if !yield(bnodeLeaf(lf), d+1) {
+							return false
+						}
+					}
 				}
 				// self after children
-				return yield(root, true)
+				return yield(root, d)
 			}
 			return true
 		}
@@ -616,7 +792,7 @@ func dfs(root *bnode) iter.Seq2[*bnode, bool] {
 		var k byte
 
 		if root.isLeaf {
-			yield(root, true)
+			yield(root, 0)
 			return
 		}
 		visit(k, root, 0)
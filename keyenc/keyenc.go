@@ -0,0 +1,298 @@
+// Package keyenc encodes tuples of typed values
+// into keys for a uart.Tree, such that comparing
+// the keys byte by byte, as the tree does, orders
+// them as the tuples would be ordered: component
+// by component, each by its natural order.
+//
+// The supported component types are int64, uint64,
+// float64, string, []byte, bool, and time.Time. The
+// other int and uint types are encoded as int64 and
+// uint64. Wrapping a component in Desc reverses its
+// order, for newest-first timestamps and the like.
+//
+// Each component is self-delimiting, so the encoding
+// of the first components of a tuple is a prefix of
+// the encoding of the whole tuple. So a prefix scan,
+// with uart.AscendPrefix, can select the keys of
+// (tenant, timestamp, id) tuples for one tenant,
+// and Iter over the encodings of (tenant, t0) and
+// (tenant, t1) selects a range of timestamps.
+//
+// Each component starts with a tag byte giving its
+// type, so that Decode needs no schema. Then comes:
+//
+//	int64:     8 bytes big-endian, with the sign bit flipped
+//	uint64:    8 bytes big-endian
+//	float64:   the 8 bytes of the IEEE 754 bits, big-endian,
+//	           all flipped if negative, else the sign bit flipped
+//	string,    the bytes, each 0 byte escaped as 0 0xff,
+//	[]byte:    then the two bytes 0 1 as a terminator
+//	bool:      nothing; false and true have tags of their own
+//	time.Time: the Unix seconds as an int64, then the
+//	           nanoseconds as 4 bytes big-endian
+//
+// A Desc component is encoded in the same way,
+// and then every byte of it, tag included, is
+// complemented.
+package keyenc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The tags. Components of different types
+// order by their tags. A complemented tag,
+// with the high bit set, marks a Desc component.
+const (
+	tagFalse  = 0x02
+	tagTrue   = 0x03
+	tagInt    = 0x04
+	tagUint   = 0x05
+	tagFloat  = 0x06
+	tagString = 0x07
+	tagBytes  = 0x08
+	tagTime   = 0x09
+)
+
+// ErrBadKey is returned (possibly wrapped) by Decode
+// for a key that is not a keyenc encoding.
+var ErrBadKey = errors.New("keyenc: bad key")
+
+// Descending wraps a component to be encoded
+// so that it sorts in descending order.
+type Descending struct {
+	V any
+}
+
+// Desc returns v wrapped to sort in descending order.
+func Desc(v any) Descending {
+	return Descending{V: v}
+}
+
+// Append appends the encoding of the tuple vals to dst.
+func Append(dst []byte, vals ...any) ([]byte, error) {
+	for _, v := range vals {
+		var err error
+		if dst, err = appendOne(dst, v, false); err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
+}
+
+// Encode returns the encoding of the tuple vals.
+func Encode(vals ...any) ([]byte, error) {
+	return Append(nil, vals...)
+}
+
+// MustEncode is like Encode, but panics if a
+// component has a type that cannot be encoded.
+func MustEncode(vals ...any) []byte {
+	key, err := Encode(vals...)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// appendOne appends the encoding of a single component.
+func appendOne(dst []byte, v any, desc bool) ([]byte, error) {
+	start := len(dst)
+	switch x := v.(type) {
+	case Descending:
+		return appendOne(dst, x.V, !desc)
+	case bool:
+		if x {
+			dst = append(dst, tagTrue)
+		} else {
+			dst = append(dst, tagFalse)
+		}
+	case int:
+		dst = appendInt(dst, int64(x))
+	case int8:
+		dst = appendInt(dst, int64(x))
+	case int16:
+		dst = appendInt(dst, int64(x))
+	case int32:
+		dst = appendInt(dst, int64(x))
+	case int64:
+		dst = appendInt(dst, x)
+	case uint:
+		dst = appendUint(dst, uint64(x))
+	case uint8:
+		dst = appendUint(dst, uint64(x))
+	case uint16:
+		dst = appendUint(dst, uint64(x))
+	case uint32:
+		dst = appendUint(dst, uint64(x))
+	case uint64:
+		dst = appendUint(dst, x)
+	case float32:
+		dst = appendFloat(dst, float64(x))
+	case float64:
+		dst = appendFloat(dst, x)
+	case string:
+		dst = appendEscaped(append(dst, tagString), x)
+	case []byte:
+		dst = appendEscaped(append(dst, tagBytes), x)
+	case time.Time:
+		dst = append(dst, tagTime)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(x.Unix())^(1<<63))
+		dst = binary.BigEndian.AppendUint32(dst, uint32(x.Nanosecond()))
+	default:
+		return dst[:start], fmt.Errorf("keyenc: cannot encode %T", v)
+	}
+	if desc {
+		for i := start; i < len(dst); i++ {
+			dst[i] = ^dst[i]
+		}
+	}
+	return dst, nil
+}
+
+func appendInt(dst []byte, x int64) []byte {
+	return binary.BigEndian.AppendUint64(append(dst, tagInt), uint64(x)^(1<<63))
+}
+
+func appendUint(dst []byte, x uint64) []byte {
+	return binary.BigEndian.AppendUint64(append(dst, tagUint), x)
+}
+
+// appendFloat orders the floats as numbers. -0 is
+// encoded as 0, since they are equal, and every NaN
+// as the same NaN, which sorts after +Inf.
+func appendFloat(dst []byte, x float64) []byte {
+	if x == 0 {
+		x = 0
+	}
+	if x != x {
+		x = math.NaN()
+	}
+	bits := math.Float64bits(x)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(append(dst, tagFloat), bits)
+}
+
+func appendEscaped[S string | []byte](dst []byte, s S) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 {
+			dst = append(dst, 0, 0xff)
+		} else {
+			dst = append(dst, s[i])
+		}
+	}
+	return append(dst, 0, 1)
+}
+
+// Decode returns the components of the tuple encoded
+// in key, as int64, uint64, float64, string, []byte,
+// bool, and time.Time values. A component encoded
+// with Desc is returned without the Descending
+// wrapper. Times are returned in UTC.
+func Decode(key []byte) (vals []any, err error) {
+	for len(key) > 0 {
+		var v any
+		v, key, err = decodeOne(key)
+		if err != nil {
+			return vals, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// decodeOne decodes the first component of
+// key, and returns it with the rest of key.
+func decodeOne(key []byte) (v any, rest []byte, err error) {
+	var mask byte
+	raw := key[0]
+	tag := raw
+	if tag&0x80 != 0 {
+		mask = 0xff
+		tag = ^tag
+	}
+	key = key[1:]
+	fixed := func(n int) ([]byte, error) {
+		if len(key) < n {
+			return nil, fmt.Errorf("%w: short %v component", ErrBadKey, tag)
+		}
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = key[i] ^ mask
+		}
+		key = key[n:]
+		return b, nil
+	}
+	switch tag {
+	case tagFalse:
+		return false, key, nil
+	case tagTrue:
+		return true, key, nil
+	case tagInt, tagUint, tagFloat:
+		b, err := fixed(8)
+		if err != nil {
+			return nil, nil, err
+		}
+		u := binary.BigEndian.Uint64(b)
+		switch tag {
+		case tagInt:
+			return int64(u ^ (1 << 63)), key, nil
+		case tagUint:
+			return u, key, nil
+		}
+		if u&(1<<63) != 0 {
+			u &^= 1 << 63
+		} else {
+			u = ^u
+		}
+		return math.Float64frombits(u), key, nil
+	case tagTime:
+		b, err := fixed(12)
+		if err != nil {
+			return nil, nil, err
+		}
+		sec := int64(binary.BigEndian.Uint64(b) ^ (1 << 63))
+		nsec := int64(binary.BigEndian.Uint32(b[8:]))
+		return time.Unix(sec, nsec).UTC(), key, nil
+	case tagString, tagBytes:
+		var out []byte
+		for i := 0; ; i++ {
+			if i >= len(key) {
+				return nil, nil, fmt.Errorf("%w: unterminated string", ErrBadKey)
+			}
+			c := key[i] ^ mask
+			if c != 0 {
+				out = append(out, c)
+				continue
+			}
+			if i+1 >= len(key) {
+				return nil, nil, fmt.Errorf("%w: unterminated string", ErrBadKey)
+			}
+			switch key[i+1] ^ mask {
+			case 0xff:
+				out = append(out, 0)
+				i++
+			case 1:
+				key = key[i+2:]
+				if tag == tagString {
+					return string(out), key, nil
+				}
+				if out == nil {
+					out = []byte{}
+				}
+				return out, key, nil
+			default:
+				return nil, nil, fmt.Errorf("%w: bad escape in string", ErrBadKey)
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: unknown tag %#x", ErrBadKey, raw)
+}
@@ -0,0 +1,195 @@
+package keyenc
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	mathrand2 "math/rand/v2"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/glycerine/uart"
+)
+
+// compareVal is the natural order of two
+// decoded components of the same type.
+func compareVal(a, b any) int {
+	switch x := a.(type) {
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case int64:
+		return cmp.Compare(x, b.(int64))
+	case uint64:
+		return cmp.Compare(x, b.(uint64))
+	case float64:
+		return cmp.Compare(x, b.(float64))
+	case string:
+		return cmp.Compare(x, b.(string))
+	case []byte:
+		return bytes.Compare(x, b.([]byte))
+	case time.Time:
+		return x.Compare(b.(time.Time))
+	}
+	panic(fmt.Sprintf("%T", a))
+}
+
+func randVal(rng *mathrand2.Rand, kind int) any {
+	switch kind {
+	case 0:
+		return rng.IntN(2) == 0
+	case 1:
+		return []int64{math.MinInt64, -1, 0, 1, math.MaxInt64, rng.Int64() - math.MaxInt64/2}[rng.IntN(6)]
+	case 2:
+		return []uint64{0, 1, math.MaxUint64, rng.Uint64()}[rng.IntN(4)]
+	case 3:
+		return []float64{math.Inf(-1), -1e300, -1, -1e-300, 0, 1e-300, 0.5, 1, 1e300, math.Inf(1), rng.NormFloat64()}[rng.IntN(11)]
+	case 4, 5:
+		alphabet := []byte{0, 1, 'a', 0xfe, 0xff}
+		b := make([]byte, rng.IntN(4))
+		for i := range b {
+			b[i] = alphabet[rng.IntN(len(alphabet))]
+		}
+		if kind == 4 {
+			return string(b)
+		}
+		return b
+	}
+	return time.Unix(rng.Int64N(1<<40)-1<<39, rng.Int64N(1e9)).UTC()
+}
+
+func TestEncode_order_and_round_trip(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(16, 16))
+	for trial := range 200 {
+		// a schema: the kind of each
+		// component, and whether it descends.
+		n := 1 + rng.IntN(3)
+		kinds := make([]int, n)
+		desc := make([]bool, n)
+		for i := range kinds {
+			kinds[i] = rng.IntN(7)
+			desc[i] = rng.IntN(3) == 0
+		}
+		var tuples [][]any
+		var keys [][]byte
+		for range 50 {
+			tup := make([]any, n)
+			enc := make([]any, n)
+			for i := range tup {
+				tup[i] = randVal(rng, kinds[i])
+				enc[i] = tup[i]
+				if desc[i] {
+					enc[i] = Desc(tup[i])
+				}
+			}
+			key, err := Encode(enc...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decode(key)
+			if err != nil || !reflect.DeepEqual(got, tup) {
+				t.Fatalf("trial %v: Decode(Encode(%v)) = %v, %v", trial, tup, got, err)
+			}
+			tuples = append(tuples, tup)
+			keys = append(keys, key)
+		}
+		compareTuples := func(a, b []any) int {
+			for i := range a {
+				c := compareVal(a[i], b[i])
+				if desc[i] {
+					c = -c
+				}
+				if c != 0 {
+					return c
+				}
+			}
+			return 0
+		}
+		for i := range tuples {
+			for j := range tuples {
+				want := compareTuples(tuples[i], tuples[j])
+				if got := bytes.Compare(keys[i], keys[j]); got != want {
+					t.Fatalf("trial %v: %v vs %v: keys compare %v, want %v", trial, tuples[i], tuples[j], got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEncode_floats(t *testing.T) {
+	if !bytes.Equal(MustEncode(math.Copysign(0, -1)), MustEncode(0.0)) {
+		t.Fatalf("-0 and 0 differ")
+	}
+	nan, _ := Encode(math.NaN())
+	inf, _ := Encode(math.Inf(1))
+	if bytes.Compare(nan, inf) <= 0 {
+		t.Fatalf("NaN does not sort after +Inf")
+	}
+	v, err := Decode(MustEncode(float32(1.5), int8(-3), uint16(7)))
+	if err != nil || !reflect.DeepEqual(v, []any{1.5, int64(-3), uint64(7)}) {
+		t.Fatalf("got %v, %v", v, err)
+	}
+}
+
+func TestEncode_errors(t *testing.T) {
+	if _, err := Encode(1, struct{}{}); err == nil {
+		t.Fatalf("no error for a struct")
+	}
+	good := MustEncode("abc", int64(5), Desc("x"))
+	for i := 1; i < len(good); i++ {
+		if i == 6 || i == 15 {
+			continue // whole components
+		}
+		if _, err := Decode(good[:i]); !errors.Is(err, ErrBadKey) {
+			t.Fatalf("Decode of %v bytes of %v: %v", i, len(good), err)
+		}
+	}
+	if _, err := Decode([]byte{0x42}); !errors.Is(err, ErrBadKey) {
+		t.Fatalf("unknown tag: %v", err)
+	}
+}
+
+// Composite (tenant, timestamp, id) keys, newest first
+// within a tenant, found with prefix and range scans.
+func TestEncode_composite_keys_in_tree(t *testing.T) {
+	tree := uart.NewArtTree()
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tenant := range []string{"acme", "acme\x00corp", "beta"} {
+		for i := range 5 {
+			ts := t0.Add(time.Duration(i) * time.Hour)
+			tree.Insert(MustEncode(tenant, Desc(ts), uint64(i)), i)
+		}
+	}
+
+	var got []string
+	for key := range uart.AscendPrefix(tree, MustEncode("acme")) {
+		v, err := Decode(key)
+		if err != nil || v[0] != "acme" {
+			t.Fatalf("Decode: %v, %v", v, err)
+		}
+		got = append(got, fmt.Sprint(v[2]))
+	}
+	if fmt.Sprint(got) != "[4 3 2 1 0]" {
+		t.Fatalf("acme, newest first: %v", got)
+	}
+
+	// hours 3 down to 2, newest first.
+	got = got[:0]
+	it := tree.Iter(MustEncode("beta", Desc(t0.Add(3*time.Hour))), MustEncode("beta", Desc(t0.Add(1*time.Hour))))
+	for it.Next() {
+		v, _ := Decode(it.Key())
+		got = append(got, fmt.Sprint(v[2]))
+	}
+	if fmt.Sprint(got) != "[3 2]" {
+		t.Fatalf("beta range: %v", got)
+	}
+}
@@ -66,6 +66,12 @@ type Leaf struct {
 	// has/path compression.
 	keybyte byte `zid:"1"`
 
+	// base is the number of leading key bytes that
+	// Key leaves out. It is 0 except in a tree made
+	// WithCompactLeaves, whose leaves drop the bytes
+	// their parent's path already holds; see fullKey.
+	base uint32 `msg:"-"`
+
 	Key   Key         `zid:"0"`
 	Value interface{} `msg:"-"`
 }
@@ -82,6 +88,79 @@ func (n *Leaf) clone() (c *Leaf) {
 	return c
 }
 
+// fullKey returns the whole key of lf. path must
+// hold the key bytes leading to lf's parent, at
+// least the first lf.base of them; a path too short,
+// as a search key can be, is taken to go on with 0
+// bytes, as Key.At does. With base 0, lf.Key is
+// the whole key and is returned as is.
+func (lf *Leaf) fullKey(path []byte) Key {
+	if lf.base == 0 {
+		return lf.Key
+	}
+	b := int(lf.base)
+	k := make(Key, b+len(lf.Key))
+	copy(k, path[:min(b, len(path))])
+	copy(k[b:], lf.Key)
+	return k
+}
+
+// full returns lf, if it holds its whole key, and
+// otherwise a new Leaf with lf's value and whole
+// key, for handing to users. See fullKey.
+func (lf *Leaf) full(path []byte) *Leaf {
+	if lf.base == 0 {
+		return lf
+	}
+	return &Leaf{Key: lf.fullKey(path), Value: lf.Value}
+}
+
+// rebased returns a new leaf with lf's value, for
+// key full, to go under a parent that dispatches on
+// byte pos of it. It keeps only the key bytes from
+// pos on, so that Key is just the suffix of full.
+func (lf *Leaf) rebased(full Key, pos int) *Leaf {
+	pos = min(pos, len(full))
+	return &Leaf{
+		keybyte: lf.keybyte,
+		base:    uint32(pos),
+		Key:     append(Key{}, full[pos:]...),
+		Value:   lf.Value,
+	}
+}
+
+// rebase is rebased for lf, a new leaf from
+// newLeaf, as it is stored in a tree with compact
+// leaves: it changes lf itself, since nothing
+// else holds it, and copies into it, from a,
+// the bytes of its key from pos on. Every new
+// leaf a compact tree stores must be rebased, as
+// its key is still the one newLeaf was given.
+func (lf *Leaf) rebase(pos int, a *slabs) {
+	pos = min(pos, len(lf.Key))
+	lf.base = uint32(pos)
+	lf.Key = a.key(lf.Key[pos:])
+}
+
+// compare returns bytes.Compare(key, the whole key
+// of lf), for a key whose descent reached lf. The
+// descent has matched the key against the path to
+// lf until keyCmpPath, if not 0, recorded the first
+// difference. Any later difference is in lf.Key.
+func (lf *Leaf) compare(key Key, keyCmpPath int) int {
+	if lf.base == 0 {
+		return bytes.Compare(key, lf.Key)
+	}
+	if keyCmpPath != 0 {
+		return keyCmpPath
+	}
+	if len(key) < int(lf.base) {
+		// key is a proper prefix of the path.
+		return -1
+	}
+	return bytes.Compare(key[lf.base:], lf.Key)
+}
+
 func NewLeaf(key Key, v any, x []byte) *Leaf {
 	return &Leaf{
 		Key:   key,
@@ -93,7 +172,11 @@ func (lf *Leaf) kind() kind {
 	return _Leafy
 }
 
-func (lf *Leaf) insert(other *Leaf, depth int, selfb *bnode, tree *Tree, par *inner) (value *bnode, updated bool) {
+// insert adds other beside lf, or in lf's place if
+// it has the same key. With u, the leaf u decides on is
+// added instead; a caller that finds other's key in lf
+// decides for it, and passes no u; see inner.insert.
+func (lf *Leaf) insert(other *Leaf, depth int, selfb *bnode, tree *Tree, par *inner, u *upsert) (value *bnode, updated bool) {
 
 	if lf == other {
 		// due to restarts (now elided though),
@@ -102,44 +185,79 @@ func (lf *Leaf) insert(other *Leaf, depth int, selfb *bnode, tree *Tree, par *in
 		return selfb, false
 	}
 
-	if other.equal(lf.Key) {
-		value = bnodeLeaf(other)
+	// a compact lf holds only the end of its key;
+	// other took the same path to get here.
+	lfKey := lf.fullKey(other.Key)
+
+	if other.equal(lfKey) {
+		if tree.compact {
+			// other takes over lf's key, rather than
+			// having its own copied; see rebase.
+			other.keybyte, other.base, other.Key = lf.keybyte, lf.base, lf.Key
+		}
+		value = tree.slab.bnodeLeaf(other)
 		updated = true
 		// avoid forcing a full re-compute of pren.
 		value.pren = selfb.pren
+		// value replaces selfb in the parent.
+		tree.recycler().freeBnode(selfb)
 		return
 	}
 
-	longestPrefix := comparePrefix(lf.Key, other.Key, depth)
+	if other, _ = u.at(nil, other); other == nil {
+		return selfb, true
+	}
+	if tree.buckets > 0 {
+		// the two leaves start a bucket.
+		pair := []*Leaf{lf, other}
+		if bytes.Compare(other.Key, lfKey) < 0 {
+			pair[0], pair[1] = other, lf
+		}
+		selfb.isLeaf = false
+		selfb.leaf = nil
+		selfb.inner = newBucket(tree.slab, pair, lf.keybyte, tree.gen)
+		return selfb, false
+	}
+	longestPrefix := comparePrefix(lfKey, other.Key, depth)
 	//vv("longestPrefix = %v; lf.Key='%v', other.key='%v', depth=%v", longestPrefix, string(lf.Key), string(other.Key), depth)
-	n4 := &node4{}
-	nn := &inner{
+	n4 := tree.slab.node4()
+	nn := tree.slab.inner()
+	*nn = inner{
 		Node: n4,
 
 		// keep commented out path stuff for debugging!
 		//path: append([]byte{}, lf.Key[:depth+longestPrefix]...),
 		SubN: 2,
+		gen:  tree.gen,
 	}
 	//vv("assigned path '%v' to %p", string(nn.path), nn)
 	if longestPrefix > 0 {
-		nn.compressed = append([]byte{}, lf.Key[depth:depth+longestPrefix]...)
+		nn.compressed = append([]byte{}, lfKey[depth:depth+longestPrefix]...)
 	}
 	//vv("leaf insert: lef nn.PrefixLen = %v (longestPrefix)", nn.PrefixLen)
 
-	child0key := lf.Key.At(depth + longestPrefix)
-	child1key := other.Key.At(depth + longestPrefix)
+	pos := depth + longestPrefix
+	child0key := lfKey.At(pos)
+	child1key := other.Key.At(pos)
 
 	//vv("child0key = 0x%x; lf.Key = '%v' (len %v); depth=%v; longestPrefix=%v; depth+longestPrefix=%v", child0key, string(lf.Key), len(lf.Key), depth, longestPrefix, depth+longestPrefix)
 
-	nn.Node.addChild(child0key, bnodeLeaf(lf))
-	nn.Node.addChild(child1key, bnodeLeaf(other))
+	if tree.compact {
+		// both leaves now hang from nn.
+		lf = lf.rebased(lfKey, pos)
+		other.rebase(pos, tree.slab)
+	}
+
+	nn.Node.addChild(child0key, tree.slab.bnodeLeaf(lf))
+	nn.Node.addChild(child1key, tree.slab.bnodeLeaf(other))
 
 	selfb.isLeaf = false
+	selfb.leaf = nil
 	selfb.inner = nn
 	return selfb, false
 }
 
-func (lf *Leaf) del(key Key, depth int, selfb *bnode, parentUpdate func(*bnode)) (deleted bool, deletedNode *bnode) {
+func (lf *Leaf) del(key Key, depth int, selfb *bnode, tree *Tree, parentUpdate func(*bnode)) (deleted bool, deletedNode *bnode) {
 
 	if !lf.equalUnlocked(key) {
 		return false, nil
@@ -150,8 +268,10 @@ func (lf *Leaf) del(key Key, depth int, selfb *bnode, parentUpdate func(*bnode))
 	return true, selfb
 }
 
-func (lf *Leaf) get(key Key, i int, selfb *bnode) (value *bnode, found bool, dir direc, id int) {
-	cmp := bytes.Compare(key, lf.Key)
+// get compares key with lf, which its descent has
+// reached with keyCmpPath; see compare.
+func (lf *Leaf) get(key Key, keyCmpPath int, selfb *bnode) (value *bnode, found bool, dir direc, id int) {
+	cmp := lf.compare(key, keyCmpPath)
 	//pp("top of Leaf get, cmp = %v from lf.Key='%v'; key='%v'", cmp, string(lf.Key), string(key))
 	//defer func() {
 	//pp("Leaf '%v' returns found=%v, dir=%v", string(lf.Key), found, dir)
@@ -0,0 +1,586 @@
+package uart
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"iter"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pattern is a compiled glob or regular expression,
+// for Tree.Match. A Pattern matches whole keys, and
+// is safe for concurrent use.
+type Pattern struct {
+	expr string // as given
+	prog *syntax.Prog
+}
+
+// ErrBadPattern is returned (wrapped) by
+// CompileGlob for a malformed glob.
+var ErrBadPattern = errors.New("uart: bad glob pattern")
+
+// CompileGlob compiles a glob over keys that are
+// slash separated paths. As in path.Match,
+//
+//	'*'     matches any run of bytes other than '/'
+//	'?'     matches any one character other than '/'
+//	'[abc]' matches one character in the class, which
+//	        may hold ranges such as a-z, and is
+//	        negated by a leading ^ or !
+//	'\c'    matches the character c
+//
+// and further,
+//
+//	'**'    matches any run of bytes, '/' included;
+//	        a/**/b matches a/b as well as a/x/y/b
+//	'{a,b}' matches any one of the comma separated
+//	        alternatives, which may themselves be globs
+//
+// Every other character matches itself.
+func CompileGlob(glob string) (*Pattern, error) {
+	re, err := globToRegexp(glob)
+	if err != nil {
+		return nil, err
+	}
+	p, err := compilePattern(re)
+	if err != nil {
+		return nil, err
+	}
+	p.expr = glob
+	return p, nil
+}
+
+// CompileRegexp compiles a regular expression, in the
+// syntax of the regexp package. The Pattern matches
+// the keys that the expression matches as a whole,
+// as if it were written ^(?:expr)$; to find it
+// anywhere in a key, surround it with .*
+func CompileRegexp(expr string) (*Pattern, error) {
+	return compilePattern(expr)
+}
+
+func compilePattern(expr string) (*Pattern, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{expr: expr, prog: prog}, nil
+}
+
+// String returns the glob or expression p
+// was compiled from.
+func (p *Pattern) String() string {
+	return p.expr
+}
+
+// Match iterates in ascending order over the keys
+// that p matches. Like Ascend, it yields the *Leaf
+// as the value.
+//
+// Match runs p as an automaton down the tree,
+// byte by byte, through the compressed prefix and
+// keybyte of each inner node, and skips any subtree
+// whose path p can no longer match. So a pattern
+// such as drivers/*/usb/*.c visits only the subtrees
+// under drivers/, and under usb/ in those, rather
+// than all the keys of the tree.
+//
+// As with Iter, the iteration does no synchronization,
+// and may write to the tree; after a write, Match
+// goes on from the key after the last one it yielded.
+func (t *Tree) Match(p *Pattern) iter.Seq2[Key, any] {
+	return func(yield func(Key, any) bool) {
+		t.match(p, yield)
+	}
+}
+
+// match does Match, and returns the number
+// of inner nodes it visited, for the tests.
+func (t *Tree) match(p *Pattern, yield func(Key, any) bool) (visits int) {
+	w := &matchWalk{tree: t, d: newDFA(p.prog), yield: yield}
+	for t.root != nil {
+		w.version = t.treeVersion
+		w.restart = false
+		w.path = w.path[:0]
+		w.walk(t.root, 0, w.d.start)
+		if !w.restart {
+			break
+		}
+	}
+	return w.visits
+}
+
+// matchWalk is the state of a Match
+// going down the tree.
+type matchWalk struct {
+	tree    *Tree
+	d       *dfa
+	yield   func(Key, any) bool
+	version int64
+
+	// path holds the key bytes from the
+	// root to the node being walked.
+	path []byte
+
+	// after, once set, is the last key yielded
+	// before the tree changed; the walk then
+	// starts over, and yields only keys after it.
+	after   Key
+	restart bool
+
+	visits int
+}
+
+// walk yields the keys under b that match, where the
+// automaton is in state s after the first depth bytes
+// of them, which are in w.path. It returns false if
+// the walk is over: the caller broke off, or the
+// tree changed under it.
+func (w *matchWalk) walk(b *bnode, depth int, s int32) bool {
+	if b.isLeaf {
+		return w.leaf(b.leaf, depth, s)
+	}
+	n := b.inner
+	if bk, ok := n.asBucket(); ok {
+		for _, lf := range bk.leaves {
+			if !w.leaf(lf, depth, s) {
+				return false
+			}
+		}
+		return true
+	}
+	w.visits++
+	for _, c := range n.compressed {
+		if s = w.d.step(s, c); s == deadState {
+			return true
+		}
+	}
+	w.path = append(w.path, n.compressed...)
+	defer func() { w.path = w.path[:depth] }()
+	if w.before() {
+		return true
+	}
+	pos := depth + len(n.compressed)
+	for kb, ch := range n.kids() {
+		if ch.isLeaf {
+			// a leaf holds its own key byte
+			// (or ends here, with none).
+			if !w.leaf(ch.leaf, pos, s) {
+				return false
+			}
+			continue
+		}
+		if _, ok := ch.inner.asBucket(); ok {
+			if !w.walk(ch, pos, s) {
+				return false
+			}
+			continue
+		}
+		s2 := w.d.step(s, kb)
+		if s2 == deadState {
+			continue
+		}
+		w.path = append(w.path, kb)
+		ok := w.walk(ch, pos+1, s2)
+		w.path = w.path[:pos]
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// before reports whether every key starting
+// with w.path comes before w.after.
+func (w *matchWalk) before() bool {
+	if w.after == nil {
+		return false
+	}
+	a := w.after[:min(len(w.path), len(w.after))]
+	return bytes.Compare(w.path, a) < 0
+}
+
+// leaf yields lf if it matches, where the automaton
+// is in state s after the first depth bytes of its
+// key. See walk for what it returns.
+func (w *matchWalk) leaf(lf *Leaf, depth int, s int32) bool {
+	rest := lf.Key
+	if lf.base == 0 {
+		rest = lf.Key[min(depth, len(lf.Key)):]
+	}
+	if w.after != nil && bytes.Compare(lf.fullKey(w.path), w.after) <= 0 {
+		return true
+	}
+	for _, c := range rest {
+		if s = w.d.step(s, c); s == deadState {
+			return true
+		}
+	}
+	if !w.d.accepts(s) {
+		return true
+	}
+	full := lf.full(w.path)
+	if !w.yield(full.Key, full) {
+		return false
+	}
+	if w.tree.treeVersion != w.version {
+		w.after = full.Key
+		w.restart = true
+		return false
+	}
+	return true
+}
+
+// globToRegexp translates glob, as
+// CompileGlob takes it, to a regular expression.
+func globToRegexp(glob string) (string, error) {
+	var sb strings.Builder
+	depth := 0 // of {} nesting
+	for i := 0; i < len(glob); {
+		r, n := utf8.DecodeRuneInString(glob[i:])
+		i += n
+		switch r {
+		case '*':
+			if i < len(glob) && glob[i] == '*' {
+				i++
+				if i < len(glob) && glob[i] == '/' {
+					// a/**/b also matches a/b.
+					i++
+					sb.WriteString(`(?:.*/)?`)
+				} else {
+					sb.WriteString(`.*`)
+				}
+			} else {
+				sb.WriteString(`[^/]*`)
+			}
+		case '?':
+			sb.WriteString(`[^/]`)
+		case '[':
+			end, err := globClass(&sb, glob, i)
+			if err != nil {
+				return "", err
+			}
+			i = end
+		case '{':
+			depth++
+			sb.WriteString(`(?:`)
+		case ',':
+			if depth == 0 {
+				sb.WriteString(`,`)
+			} else {
+				sb.WriteString(`|`)
+			}
+		case '}':
+			if depth == 0 {
+				return "", fmt.Errorf("%w: unmatched } in %q", ErrBadPattern, glob)
+			}
+			depth--
+			sb.WriteString(`)`)
+		case '\\':
+			if i == len(glob) {
+				return "", fmt.Errorf("%w: trailing \\ in %q", ErrBadPattern, glob)
+			}
+			r, n = utf8.DecodeRuneInString(glob[i:])
+			i += n
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if depth > 0 {
+		return "", fmt.Errorf("%w: unmatched { in %q", ErrBadPattern, glob)
+	}
+	return sb.String(), nil
+}
+
+// globClass writes the class that starts at glob[i],
+// just after its '[', to sb as a regexp class, and
+// returns the index just after its ']'.
+func globClass(sb *strings.Builder, glob string, i int) (int, error) {
+	sb.WriteString(`[`)
+	if i < len(glob) && (glob[i] == '^' || glob[i] == '!') {
+		sb.WriteString(`^`)
+		i++
+	}
+	// next returns the class character at glob[i],
+	// unescaping it, and the index after it.
+	next := func(i int) (rune, int, error) {
+		if i < len(glob) && glob[i] == '\\' {
+			i++
+		}
+		if i >= len(glob) {
+			return 0, i, fmt.Errorf("%w: unterminated [ in %q", ErrBadPattern, glob)
+		}
+		r, n := utf8.DecodeRuneInString(glob[i:])
+		return r, i + n, nil
+	}
+	empty := true
+	for {
+		if i < len(glob) && glob[i] == ']' && !empty {
+			sb.WriteString(`]`)
+			return i + 1, nil
+		}
+		lo, j, err := next(i)
+		if err != nil {
+			return 0, err
+		}
+		hi := lo
+		if j+1 < len(glob) && glob[j] == '-' && glob[j+1] != ']' {
+			if hi, j, err = next(j + 1); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("%w: bad range in %q", ErrBadPattern, glob)
+			}
+		}
+		fmt.Fprintf(sb, `\x{%x}-\x{%x}`, lo, hi)
+		empty = false
+		i = j
+	}
+}
+
+// dfa runs a regexp/syntax program as a
+// deterministic automaton over the bytes of
+// keys, building its states as it comes to them:
+// at most one new state for each node that
+// Match walks. A dfa is used by one Match.
+//
+// A state is the set of program threads that
+// are alive, each at an instruction that needs a
+// rune (or an empty-width condition) to go on.
+// Keys are taken as UTF-8: the bytes of a rune
+// are held in the state until it is complete,
+// and an invalid byte counts as utf8.RuneError,
+// as in the regexp package. The state also holds
+// the kind of rune before, for \b, ^, and $.
+type dfa struct {
+	prog   *syntax.Prog
+	states []dstate
+	ids    map[string]int32
+	start  int32
+}
+
+type dstate struct {
+	pcs     []uint32
+	pending []byte // an incomplete rune
+	prev    byte   // the kind of rune before; see runeKind
+
+	// next[b] is 1 + the state after byte b, or 0
+	// if not yet known. accept is 1 + whether the
+	// state matches at the end of the key, or 0.
+	next   [256]int32
+	accept int8
+}
+
+// deadState is the state with no
+// threads, from which nothing matches.
+const deadState = 0
+
+// The kinds of rune that the empty-width
+// conditions look at.
+const (
+	kindStart byte = iota // no rune: the start of the key
+	kindNewline
+	kindWord
+	kindOther
+)
+
+func runeKind(r rune) byte {
+	switch {
+	case r < 0:
+		return kindStart
+	case r == '\n':
+		return kindNewline
+	case syntax.IsWordChar(r):
+		return kindWord
+	}
+	return kindOther
+}
+
+// emptyOps returns the empty-width conditions that
+// hold between a rune of kind prev and next, a
+// rune or -1 for the end of the key.
+func emptyOps(prev byte, next rune) (op syntax.EmptyOp) {
+	if prev == kindStart {
+		op |= syntax.EmptyBeginText | syntax.EmptyBeginLine
+	}
+	if prev == kindNewline {
+		op |= syntax.EmptyBeginLine
+	}
+	if next < 0 {
+		op |= syntax.EmptyEndText | syntax.EmptyEndLine
+	}
+	if next == '\n' {
+		op |= syntax.EmptyEndLine
+	}
+	if (prev == kindWord) != (next >= 0 && syntax.IsWordChar(next)) {
+		op |= syntax.EmptyWordBoundary
+	} else {
+		op |= syntax.EmptyNoWordBoundary
+	}
+	return
+}
+
+func newDFA(prog *syntax.Prog) *dfa {
+	d := &dfa{prog: prog, ids: make(map[string]int32)}
+	d.states = append(d.states, dstate{accept: 1}) // deadState
+	seen := make([]bool, len(prog.Inst))
+	d.start = d.intern(d.follow(nil, seen, uint32(prog.Start), 0, false), nil, kindStart)
+	return d
+}
+
+// follow adds pc to pcs, with the instructions it
+// leads to without taking a rune; seen marks those
+// already followed. If withOps is set, the threads
+// pass the empty-width conditions that ops allows;
+// otherwise they stop at them, to be followed once
+// the next rune is known.
+func (d *dfa) follow(pcs []uint32, seen []bool, pc uint32, ops syntax.EmptyOp, withOps bool) []uint32 {
+	if seen[pc] {
+		return pcs
+	}
+	seen[pc] = true
+	in := &d.prog.Inst[pc]
+	switch in.Op {
+	case syntax.InstAlt, syntax.InstAltMatch:
+		pcs = d.follow(pcs, seen, in.Out, ops, withOps)
+		return d.follow(pcs, seen, in.Arg, ops, withOps)
+	case syntax.InstNop, syntax.InstCapture:
+		return d.follow(pcs, seen, in.Out, ops, withOps)
+	case syntax.InstEmptyWidth:
+		if !withOps {
+			return append(pcs, pc)
+		}
+		if syntax.EmptyOp(in.Arg)&^ops == 0 {
+			return d.follow(pcs, seen, in.Out, ops, withOps)
+		}
+		return pcs
+	case syntax.InstFail:
+		return pcs
+	}
+	return append(pcs, pc)
+}
+
+// passEmpty follows the threads of pcs past the
+// empty-width conditions that ops allows.
+func (d *dfa) passEmpty(pcs []uint32, ops syntax.EmptyOp) []uint32 {
+	var out []uint32
+	seen := make([]bool, len(d.prog.Inst))
+	for _, pc := range pcs {
+		out = d.follow(out, seen, pc, ops, true)
+	}
+	return out
+}
+
+// intern returns the state of pcs, pending, and
+// prev, making it if it is new.
+func (d *dfa) intern(pcs []uint32, pending []byte, prev byte) int32 {
+	// keep only the threads that need a
+	// rune, or the end, to go on.
+	live := pcs[:0:0]
+	for _, pc := range pcs {
+		switch d.prog.Inst[pc].Op {
+		case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny,
+			syntax.InstRuneAnyNotNL, syntax.InstMatch, syntax.InstEmptyWidth:
+			live = append(live, pc)
+		}
+	}
+	if len(live) == 0 {
+		return deadState
+	}
+	slices.Sort(live)
+	live = slices.Compact(live)
+	id := make([]byte, 0, 2+len(pending)+4*len(live))
+	id = append(id, prev, byte(len(pending)))
+	id = append(id, pending...)
+	for _, pc := range live {
+		id = append(id, byte(pc>>24), byte(pc>>16), byte(pc>>8), byte(pc))
+	}
+	if s, ok := d.ids[string(id)]; ok {
+		return s
+	}
+	s := int32(len(d.states))
+	d.states = append(d.states, dstate{
+		pcs:     live,
+		pending: slices.Clone(pending),
+		prev:    prev,
+	})
+	d.ids[string(id)] = s
+	return s
+}
+
+// stepRune returns the threads of pcs, after
+// a rune r that follows one of kind prev.
+func (d *dfa) stepRune(pcs []uint32, prev byte, r rune) []uint32 {
+	var out []uint32
+	seen := make([]bool, len(d.prog.Inst))
+	for _, pc := range d.passEmpty(pcs, emptyOps(prev, r)) {
+		in := &d.prog.Inst[pc]
+		ok := false
+		switch in.Op {
+		case syntax.InstRune, syntax.InstRune1:
+			ok = in.MatchRune(r)
+		case syntax.InstRuneAny:
+			ok = true
+		case syntax.InstRuneAnyNotNL:
+			ok = r != '\n'
+		}
+		if ok {
+			out = d.follow(out, seen, in.Out, 0, false)
+		}
+	}
+	return out
+}
+
+// step returns the state after byte c from state s.
+func (d *dfa) step(s int32, c byte) int32 {
+	if s == deadState {
+		return deadState
+	}
+	if next := d.states[s].next[c]; next > 0 {
+		return next - 1
+	}
+	st := &d.states[s]
+	pcs, prev := st.pcs, st.prev
+	pending := append(slices.Clone(st.pending), c)
+	for len(pcs) > 0 && utf8.FullRune(pending) {
+		r, n := utf8.DecodeRune(pending)
+		pcs = d.stepRune(pcs, prev, r)
+		prev = runeKind(r)
+		pending = pending[n:]
+	}
+	next := d.intern(pcs, pending, prev)
+	d.states[s].next[c] = next + 1
+	return next
+}
+
+// accepts reports whether a key that ends
+// in state s matches.
+func (d *dfa) accepts(s int32) bool {
+	st := &d.states[s]
+	if st.accept == 0 {
+		pcs, prev := st.pcs, st.prev
+		// the bytes of an incomplete rune
+		// are each a utf8.RuneError.
+		for range st.pending {
+			pcs = d.stepRune(pcs, prev, utf8.RuneError)
+			prev = kindOther
+		}
+		st.accept = 1
+		for _, pc := range d.passEmpty(pcs, emptyOps(prev, -1)) {
+			if d.prog.Inst[pc].Op == syntax.InstMatch {
+				st.accept = 2
+			}
+		}
+	}
+	return st.accept == 2
+}
@@ -0,0 +1,223 @@
+package uart
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// matchKeys returns the keys of tree that p matches.
+func matchKeys(tree *Tree, p *Pattern) (got []string) {
+	for key := range tree.Match(p) {
+		got = append(got, string(key))
+	}
+	return
+}
+
+func TestMatch_regexp(t *testing.T) {
+	keys := append(loadPaths(),
+		"", "a", "ab", "a\nb", "b\na", "café", "caf\xc3", "caf\xff", "été",
+		"x y", "x-y", "xy", "foo.go", "foo.go.bak")
+	for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}, {WithBuckets(8)}} {
+		tree := NewArtTree(opts...)
+		for _, k := range keys {
+			tree.Insert(Key(k), nil)
+		}
+		for _, expr := range []string{
+			`src/net/http/[^/]*\.go`,
+			`.*_test\.go`,
+			`src/(runtime|os)/.*\.s`,
+			`.*/testdata/.*`,
+			`a`, `a.*`, ``, `.*`, `.`,
+			`a\nb`, `(?s)a.b`, `(?m)a$\n^b`, `.*\bb`, `x\by`, `x\By`, `x\b.\by`,
+			`caf.`, `caf\x{fffd}`, `caf\xe9`, `\p{L}+`, `(?i)CAF\x{e9}`,
+			`^foo\.go$`, `foo\.go(\.bak)?`, `(f|fo|foo)+\.go`, `(a*)*`, `(|a)+b?`,
+			`src/[a-c].*/doc\.go`, `api/go1\.\d+\.txt`, `[^s].{0,3}`,
+		} {
+			p, err := CompileRegexp(expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			re := regexp.MustCompile(`^(?:` + expr + `)$`)
+			var want []string
+			for _, k := range keys {
+				if re.MatchString(k) {
+					want = append(want, k)
+				}
+			}
+			slices.Sort(want)
+			want = slices.Compact(want)
+			if got := matchKeys(tree, p); !slices.Equal(got, want) {
+				t.Fatalf("%v: Match(%q) = %q (%v keys), want %q (%v keys)",
+					opts, expr, first(got, 5), len(got), first(want, 5), len(want))
+			}
+		}
+	}
+}
+
+func first(s []string, n int) []string {
+	return s[:min(n, len(s))]
+}
+
+func TestMatch_glob(t *testing.T) {
+	keys := loadPaths()
+	tree := NewArtTree()
+	for _, k := range keys {
+		tree.Insert(Key(k), nil)
+	}
+	// the globs that path.Match understands.
+	for _, glob := range []string{
+		"src/net/http/*.go", "src/*/*_test.go", "src/?s/*", "src/[a-c]*/doc.go",
+		"src/[^a-r]*", "api/go1.[0-9].txt", "src/net/\\h\\ttp", "*",
+		"src/*/*/testdata/*.golden", "lib/time/zoneinfo.zip", "nope/*",
+	} {
+		p, err := CompileGlob(glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for _, k := range keys {
+			if ok, _ := path.Match(glob, k); ok {
+				want = append(want, k)
+			}
+		}
+		if got := matchKeys(tree, p); !slices.Equal(got, want) {
+			t.Fatalf("Match(%q) = %q (%v keys), want %q (%v keys)",
+				glob, first(got, 5), len(got), first(want, 5), len(want))
+		}
+		if p.String() != glob {
+			t.Fatalf("String() = %q", p.String())
+		}
+	}
+
+	// ** and {}, which it does not.
+	small := NewArtTree()
+	for _, k := range []string{"a/b", "a/x/b", "a/x/y/b", "a/xb", "ab", "b", "a/b.c", "a/b.h", "a/b.go", "a,b"} {
+		small.Insert(Key(k), nil)
+	}
+	for glob, want := range map[string]string{
+		"a/**/b":        "[a/b a/x/b a/x/y/b]",
+		"**/b":          "[a/b a/x/b a/x/y/b b]",
+		"a/**":          "[a/b a/b.c a/b.go a/b.h a/x/b a/x/y/b a/xb]",
+		"a/b.{c,h}":     "[a/b.c a/b.h]",
+		"a/{b,x/*}":     "[a/b a/x/b]",
+		"a/b{,.{c,g*}}": "[a/b a/b.c a/b.go]",
+		"a,b":           "[a,b]",
+		"[!a]":          "[b]",
+	} {
+		p, err := CompileGlob(glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(matchKeys(small, p)); got != want {
+			t.Fatalf("Match(%q) = %v, want %v", glob, got, want)
+		}
+	}
+
+	for _, bad := range []string{"a[", "a[b", "a[]", "a[z-a]", "a{b", "a}", "a\\", "a[\\"} {
+		if _, err := CompileGlob(bad); !errors.Is(err, ErrBadPattern) {
+			t.Fatalf("CompileGlob(%q): %v", bad, err)
+		}
+	}
+	if _, err := CompileRegexp("a("); err == nil {
+		t.Fatalf("CompileRegexp(a() did not fail")
+	}
+}
+
+// Match visits only the subtrees that
+// can hold a match.
+func TestMatch_prunes(t *testing.T) {
+	tree := NewArtTree()
+	for _, k := range loadPaths() {
+		tree.Insert(Key(k), nil)
+	}
+	inner := 0
+	for b := range dfs(tree.root) {
+		if !b.isLeaf {
+			inner++
+		}
+	}
+	for _, glob := range []string{"src/net/http/*.go", "src/*/doc.go"} {
+		p, _ := CompileGlob(glob)
+		n := 0
+		visits := tree.match(p, func(Key, any) bool { n++; return true })
+		t.Logf("%v: %v matches, %v of %v inner nodes visited", glob, n, visits, inner)
+		if n == 0 || visits > inner/10 {
+			t.Fatalf("%v: %v matches, %v of %v inner nodes visited", glob, n, visits, inner)
+		}
+	}
+}
+
+// Match goes on from the last key it
+// yielded after the loop writes to the tree.
+func TestMatch_writes_during_iteration(t *testing.T) {
+	for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}, {WithBuckets(4)}} {
+		tree := NewArtTree(opts...)
+		for i := range 200 {
+			tree.Insert(Key(fmt.Sprintf("k/%03d", i)), i)
+		}
+		p, _ := CompileGlob("k/*[05]")
+		var got []string
+		for key, lf := range tree.Match(p) {
+			got = append(got, string(key))
+			v := lf.(*Leaf).Value.(int)
+			if v < 0 {
+				continue
+			}
+			if want := atoi(t, string(key[2:])); v != want {
+				t.Fatalf("%s has value %v", key, v)
+			}
+			// remove this key and the next that would
+			// match, and add a key just after this one,
+			// and one before them all.
+			tree.Remove(key)
+			tree.Remove(Key(fmt.Sprintf("k/%03d", v+5)))
+			tree.Insert(Key(string(key)+"x5"), -1)
+			tree.Insert(Key("k/-0"), -1)
+		}
+		var want []string
+		for i := 0; i < 200; i += 10 {
+			want = append(want, fmt.Sprintf("k/%03d", i), fmt.Sprintf("k/%03dx5", i))
+		}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Fatalf("%v: got %v\nwant %v", opts, got, want)
+		}
+	}
+}
+
+func atoi(t *testing.T, s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// A glob over assets/paths.txt, by Match and
+// by filtering a scan of every key.
+func BenchmarkMatch(b *testing.B) {
+	tree := NewArtTree()
+	for _, k := range loadPaths() {
+		tree.Insert(Key(k), nil)
+	}
+	const glob = "src/net/*/*_test.go"
+	b.Run("Match", func(b *testing.B) {
+		p, _ := CompileGlob(glob)
+		for range b.N {
+			for range tree.Match(p) {
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for range b.N {
+			for key := range Ascend(tree, nil, nil) {
+				path.Match(glob, string(key))
+			}
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package uart
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// MemStats describes the shape of a tree, and
+// estimates the memory it uses, node by node.
+// The estimates count the structs of the tree
+// and the key and prefix bytes they point to,
+// by their capacity; they leave out the values,
+// and the rounding up of the Go allocator.
+// MemStats is returned by Tree.MemStats.
+type MemStats struct {
+	// Leaves is the number of keys in the tree.
+	Leaves int
+
+	// LeafBytes is the size of the Leaf structs,
+	// and KeyBytes of the keys they hold.
+	LeafBytes int
+	KeyBytes  int
+
+	// The inner nodes, by kind. Buckets
+	// are those of WithBuckets.
+	Node4   NodeStats
+	Node16  NodeStats
+	Node48  NodeStats
+	Node256 NodeStats
+	Buckets NodeStats
+
+	// LeafDepth[d] is the number of leaves d
+	// nodes down from the root; a leaf at the
+	// root has depth 0. A leaf in a bucket is
+	// one below its bucket.
+	LeafDepth []int
+
+	// AvgFanout is the average number of children
+	// of the node4/16/48/256 inner nodes.
+	AvgFanout float64
+
+	// WastedSlots is the number of child slots
+	// that the inner nodes, and buckets, have
+	// room for but do not use, and WastedBytes
+	// the bytes they take.
+	WastedSlots int
+	WastedBytes int
+
+	// TotalBytes is the sum of the bytes of the
+	// leaves, keys, and inner nodes, and the bnode
+	// of the root.
+	TotalBytes int
+}
+
+// NodeStats is the part of a MemStats
+// for one kind of inner node.
+type NodeStats struct {
+	// Count is the number of nodes of the
+	// kind, and Children the number of
+	// children they hold, of the Slots
+	// they have room for.
+	Count    int
+	Children int
+	Slots    int
+
+	// CompressedBytes is the size of the
+	// compressed prefixes of the nodes.
+	CompressedBytes int
+
+	// Bytes is the size of the nodes: each
+	// inner, its node4/16/48/256 or bucket,
+	// its compressed prefix, and the bnodes
+	// of its children.
+	Bytes int
+}
+
+// The sizes of the structs of the tree.
+const (
+	sizeLeaf    = int(unsafe.Sizeof(Leaf{}))
+	sizeBnode   = int(unsafe.Sizeof(bnode{}))
+	sizeInner   = int(unsafe.Sizeof(inner{}))
+	sizePointer = int(unsafe.Sizeof(&bnode{}))
+)
+
+// MemStats returns the shape of the tree, and an
+// estimate of the memory it uses. It walks the
+// whole tree, holding the read lock.
+func (t *Tree) MemStats() (ms *MemStats) {
+	ms = &MemStats{}
+	if t == nil {
+		return
+	}
+	if !t.SkipLocking {
+		rl := t.rlock()
+		defer rl.RUnlock()
+	}
+	if t.root == nil {
+		return
+	}
+	ms.TotalBytes = sizeBnode
+	for b, depth := range dfs(t.root) {
+		if b.isLeaf {
+			ms.Leaves++
+			ms.LeafBytes += sizeLeaf
+			ms.KeyBytes += cap(b.leaf.Key)
+			for len(ms.LeafDepth) <= depth {
+				ms.LeafDepth = append(ms.LeafDepth, 0)
+			}
+			ms.LeafDepth[depth]++
+			continue
+		}
+		n := b.inner
+		var ns *NodeStats
+		var bytes, slots int
+		switch x := n.Node.(type) {
+		case *node4:
+			ns, bytes, slots = &ms.Node4, int(unsafe.Sizeof(*x)), len(x.children)
+		case *node16:
+			ns, bytes, slots = &ms.Node16, int(unsafe.Sizeof(*x)), len(x.children)
+		case *node48:
+			ns, bytes, slots = &ms.Node48, int(unsafe.Sizeof(*x)), len(x.children)
+		case *node256:
+			ns, bytes, slots = &ms.Node256, int(unsafe.Sizeof(*x)), len(x.children)
+		case *bucket:
+			// the leaves of a bucket have no bnodes;
+			// its slots are its slice of them.
+			ns, slots = &ms.Buckets, cap(x.leaves)
+			bytes = int(unsafe.Sizeof(*x)) + slots*sizePointer
+		}
+		nchild := n.Node.nchild()
+		if ns != &ms.Buckets {
+			bytes += nchild * sizeBnode
+		}
+		bytes += sizeInner + cap(n.compressed)
+		ns.Count++
+		ns.Children += nchild
+		ns.Slots += slots
+		ns.CompressedBytes += cap(n.compressed)
+		ns.Bytes += bytes
+		ms.WastedSlots += slots - nchild
+	}
+
+	ms.WastedBytes = ms.WastedSlots * sizePointer
+	ms.TotalBytes += ms.LeafBytes + ms.KeyBytes
+	var nodes, children int
+	for _, ns := range ms.kinds() {
+		ms.TotalBytes += ns.Bytes
+		if ns != &ms.Buckets {
+			nodes += ns.Count
+			children += ns.Children
+		}
+	}
+	if nodes > 0 {
+		ms.AvgFanout = float64(children) / float64(nodes)
+	}
+	return
+}
+
+// kinds returns the NodeStats of ms, in
+// the order that String lists them.
+func (ms *MemStats) kinds() []*NodeStats {
+	return []*NodeStats{&ms.Node4, &ms.Node16, &ms.Node48, &ms.Node256, &ms.Buckets}
+}
+
+// String lays out ms as a small table.
+func (ms *MemStats) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "MemStats: %v leaves, %v bytes total\n", ms.Leaves, ms.TotalBytes)
+	fmt.Fprintf(&sb, "  leaves: %v bytes, keys: %v bytes\n", ms.LeafBytes, ms.KeyBytes)
+	for i, ns := range ms.kinds() {
+		if ns.Count == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "  %-7v: %v nodes, %v of %v slots used, %v bytes (compressed %v)\n",
+			[]string{"node4", "node16", "node48", "node256", "bucket"}[i],
+			ns.Count, ns.Children, ns.Slots, ns.Bytes, ns.CompressedBytes)
+	}
+	fmt.Fprintf(&sb, "  fanout: %.2f, wasted slots: %v (%v bytes)\n", ms.AvgFanout, ms.WastedSlots, ms.WastedBytes)
+	fmt.Fprintf(&sb, "  leaf depths: %v\n", ms.LeafDepth)
+	return sb.String()
+}
@@ -0,0 +1,108 @@
+package uart
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemStats_small(t *testing.T) {
+	if ms := NewArtTree().MemStats(); ms.Leaves != 0 || ms.TotalBytes != 0 {
+		t.Fatalf("empty tree: %v", ms)
+	}
+	tree := NewArtTree()
+	tree.Insert(Key("ab"), 1)
+	if ms := tree.MemStats(); ms.Leaves != 1 || len(ms.LeafDepth) != 1 || ms.Node4.Count != 0 {
+		t.Fatalf("one leaf: %v", ms)
+	}
+
+	// a node4 with a compressed prefix "a",
+	// holding "ab" and a node4 for "ac", "acd".
+	tree.Insert(Key("ac"), 2)
+	tree.Insert(Key("acd"), 3)
+	ms := tree.MemStats()
+	if ms.Leaves != 3 || ms.Node4.Count != 2 || ms.Node4.Children != 4 || ms.Node4.Slots != 8 {
+		t.Fatalf("three leaves: %v", ms)
+	}
+	if ms.WastedSlots != 4 || ms.WastedBytes != 4*sizePointer || ms.AvgFanout != 2 {
+		t.Fatalf("three leaves: %v", ms)
+	}
+	if got := ms.LeafDepth; len(got) != 3 || got[1] != 1 || got[2] != 2 {
+		t.Fatalf("LeafDepth = %v", got)
+	}
+	if ms.Node4.CompressedBytes < 1 || ms.KeyBytes < 7 {
+		t.Fatalf("three leaves: %v", ms)
+	}
+	want := sizeBnode + 3*sizeLeaf + ms.KeyBytes + ms.Node4.Bytes
+	if ms.TotalBytes != want {
+		t.Fatalf("TotalBytes = %v, want %v", ms.TotalBytes, want)
+	}
+	if s := ms.String(); !strings.Contains(s, "node4  : 2 nodes, 4 of 8 slots used") {
+		t.Fatalf("String() = %v", s)
+	}
+}
+
+// MemStats of the words, against a count of the
+// nodes and the leaves.
+func TestMemStats_words(t *testing.T) {
+	words := loadTestFile("assets/words.txt")
+	for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}, {WithBuckets(DefaultBucketSize)}} {
+		tree := NewArtTree(opts...)
+		for _, w := range words {
+			tree.Insert(w, nil)
+		}
+		ms := tree.MemStats()
+		t.Logf("%v", ms)
+
+		kinds := map[kind]int{}
+		for b := range dfs(tree.root) {
+			if !b.isLeaf {
+				kinds[b.inner.Node.kind()]++
+			}
+		}
+		if ms.Node4.Count != kinds[_Node4] || ms.Node16.Count != kinds[_Node16] ||
+			ms.Node48.Count != kinds[_Node48] || ms.Node256.Count != kinds[_Node256] ||
+			ms.Buckets.Count != kinds[_Bucket] {
+			t.Fatalf("node counts differ from %v", kinds)
+		}
+		depths := 0
+		for _, n := range ms.LeafDepth {
+			depths += n
+		}
+		if ms.Leaves != tree.Size() || depths != tree.Size() {
+			t.Fatalf("Leaves %v, depths %v, want %v", ms.Leaves, depths, tree.Size())
+		}
+		if (ms.Buckets.Count > 0) != (tree.buckets > 0) {
+			t.Fatalf("%v buckets", ms.Buckets.Count)
+		}
+	}
+}
+
+// BenchmarkMemStats reports how TotalBytes compares
+// with the heap the tree takes. The estimate leaves
+// out the rounding up of the allocator, so the
+// ratio should come in under 1, but not by much.
+func BenchmarkMemStats(b *testing.B) {
+	for _, set := range keySets() {
+		for _, mode := range []struct {
+			name string
+			opts []TreeOption
+		}{
+			{"ordinary", nil},
+			{"compact", []TreeOption{WithCompactLeaves()}},
+			{"buckets", []TreeOption{WithBuckets(DefaultBucketSize)}},
+		} {
+			b.Run(set.name+"/"+mode.name, func(b *testing.B) {
+				tree := NewArtTree(mode.opts...)
+				for _, k := range set.keys {
+					tree.Insert(append(Key(nil), k...), nil)
+				}
+				var ms *MemStats
+				for range b.N {
+					ms = tree.MemStats()
+				}
+				heap := heapOf(set.keys, mode.opts...)
+				b.ReportMetric(float64(ms.TotalBytes)/float64(heap), "total/heap")
+			})
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package uart
+
+import (
+	"bytes"
+	"encoding/binary"
+	"iter"
+)
+
+// MultiTree is a multi-map: it can hold a key
+// any number of times, each time with its own value.
+// The entries of a key are kept in the order they
+// were inserted, and the order statistics count
+// every entry: Size is the number of entries, At(i)
+// returns the i-th entry, and iteration visits
+// each entry once. So percentiles of duplicated
+// measurements come straight from At.
+//
+// A MultiTree is a Tree whose keys are the user
+// keys, each extended to be unique. The user key is
+// escaped, each 0 byte becoming the two bytes 0 0xff,
+// and ended by the two bytes 0 1, after which
+// comes an 8 byte big-endian insertion sequence
+// number. This keeps the keys in user key order,
+// then insertion order, and no extended key is a
+// prefix of another. SubN and pren then count
+// entries rather than distinct keys, with no
+// change to the Tree itself.
+//
+// Concurrency is as for Tree; NewMultiTree
+// passes its opts to the underlying Tree.
+type MultiTree struct {
+	t *Tree
+
+	// seq numbers the entries. It
+	// is guarded by the tree's write lock.
+	seq uint64
+}
+
+// multiSeqLen is the length of the sequence number
+// that ends each key of the underlying Tree.
+const multiSeqLen = 8
+
+// NewMultiTree returns a new, empty MultiTree.
+// The opts are applied to the underlying Tree.
+func NewMultiTree(opts ...TreeOption) *MultiTree {
+	return &MultiTree{t: NewArtTree(opts...)}
+}
+
+// appendMultiKey appends the escaped, terminated
+// key to dst. Every entry of key, and only those,
+// has the result as its prefix.
+func appendMultiKey(dst []byte, key Key) []byte {
+	for {
+		i := bytes.IndexByte(key, 0)
+		if i < 0 {
+			break
+		}
+		dst = append(dst, key[:i]...)
+		dst = append(dst, 0, 0xff)
+		key = key[i+1:]
+	}
+	dst = append(dst, key...)
+	return append(dst, 0, 1)
+}
+
+// multiPrefix returns the prefix of the entries of key,
+// or nil, meaning unbounded, if key is nil.
+func multiPrefix(key Key) Key {
+	if key == nil {
+		return nil
+	}
+	return appendMultiKey(nil, key)
+}
+
+// multiLast returns the largest key an entry
+// of key could have, or nil if key is nil.
+func multiLast(key Key) Key {
+	if key == nil {
+		return nil
+	}
+	return append(appendMultiKey(nil, key), bytes.Repeat([]byte{0xff}, multiSeqLen)...)
+}
+
+// userKey recovers the user key from the key
+// of an entry. It shares k's memory unless
+// the user key holds 0 bytes.
+func userKey(k Key) Key {
+	k = k[:len(k)-2-multiSeqLen]
+	if bytes.IndexByte(k, 0) < 0 {
+		return k
+	}
+	out := make(Key, 0, len(k))
+	for i := 0; i < len(k); i++ {
+		out = append(out, k[i])
+		if k[i] == 0 {
+			i++ // skip the 0xff
+		}
+	}
+	return out
+}
+
+// SetSkipLocking sets the SkipLocking flag of
+// the underlying Tree. See Tree.SkipLocking.
+func (m *MultiTree) SetSkipLocking(skip bool) {
+	m.t.SkipLocking = skip
+}
+
+// Size returns the number of entries in the tree,
+// counting each entry of a key.
+func (m *MultiTree) Size() int {
+	return m.t.Size()
+}
+
+// IsEmpty returns true iff the tree has no entries.
+func (m *MultiTree) IsEmpty() bool {
+	return m.t.IsEmpty()
+}
+
+// Insert adds an entry for key with value, after
+// any entries key already has. As Tree.Insert does,
+// it makes a copy of key; value is stored as is.
+func (m *MultiTree) Insert(key Key, value any) {
+	m.t.wlockPath()
+	defer m.t.wunlock()
+
+	k := appendMultiKey(make([]byte, 0, len(key)+2+multiSeqLen), key)
+	k = binary.BigEndian.AppendUint64(k, m.seq)
+	m.seq++
+	m.t.insert_unlocked(NewLeaf(k, value, nil))
+	m.t.treeVersion++
+}
+
+// Count returns the number of entries of key.
+func (m *MultiTree) Count(key Key) int {
+	return m.t.CountPrefix(appendMultiKey(nil, key))
+}
+
+// Values returns the values of the entries
+// of key, in the order they were inserted.
+func (m *MultiTree) Values(key Key) (vals []any) {
+	for _, lf := range AscendPrefix(m.t, appendMultiKey(nil, key)) {
+		vals = append(vals, lf.(*Leaf).Value)
+	}
+	return
+}
+
+// At returns the key and value of the i-th entry,
+// counting from 0, in key order and then insertion
+// order. ok is false if i is out of range.
+// See Tree.At.
+func (m *MultiTree) At(i int) (key Key, val any, ok bool) {
+	var lf *Leaf
+	lf, ok = m.t.At(i)
+	if ok {
+		key = userKey(lf.Key)
+		val = lf.Value
+	}
+	return
+}
+
+// Rank returns the number of entries whose keys
+// are less than key, which is the index in At
+// of the first entry of key, if it has any.
+func (m *MultiTree) Rank(key Key) int {
+	return m.t.CountRange(nil, appendMultiKey(nil, key))
+}
+
+// RemoveOne removes the first inserted of the
+// entries of key, and returns its value. ok is
+// false if key has no entries.
+func (m *MultiTree) RemoveOne(key Key) (val any, ok bool) {
+	m.t.wlockPath()
+	defer m.t.wunlock()
+
+	prefix := appendMultiKey(nil, key)
+	lf, _, found := m.t.find_unlocked(GTE, prefix)
+	if !found || !bytes.HasPrefix(lf.Key, prefix) {
+		return nil, false
+	}
+	m.t.remove_unlocked(lf.Key)
+	m.t.treeVersion++
+	return lf.Value, true
+}
+
+// RemoveAll removes every entry of key, and
+// returns how many there were.
+func (m *MultiTree) RemoveAll(key Key) (n int) {
+	return m.t.DeletePrefix(appendMultiKey(nil, key))
+}
+
+// Ascend iterates over the entries with keys in
+// [beg, endx), in ascending order, the entries
+// of a key in insertion order. A nil beg or endx
+// is unbounded. See the package level Ascend function.
+func (m *MultiTree) Ascend(beg, endx Key) iter.Seq2[Key, any] {
+	return func(yield func(key Key, value any) bool) {
+		it := m.t.Iter(multiPrefix(beg), multiPrefix(endx))
+		for it.Next() {
+			if !yield(userKey(it.Key()), it.Leaf().Value) {
+				return
+			}
+		}
+	}
+}
+
+// Descend iterates over the entries with keys in
+// (endx, start], in descending order, the entries
+// of a key last inserted first. See the package
+// level Descend function.
+func (m *MultiTree) Descend(endx, start Key) iter.Seq2[Key, any] {
+	return func(yield func(key Key, value any) bool) {
+		it := m.t.RevIter(multiLast(endx), multiLast(start))
+		for it.Next() {
+			if !yield(userKey(it.Key()), it.Leaf().Value) {
+				return
+			}
+		}
+	}
+}
+
+// Snapshot returns a frozen, read-only view of the
+// tree in O(1) time. See Tree.Snapshot.
+func (m *MultiTree) Snapshot() *MultiTree {
+	return &MultiTree{t: m.t.Snapshot()}
+}
+
+// Release tells a snapshot that it will not be
+// read again. See Tree.Release.
+func (m *MultiTree) Release() {
+	m.t.Release()
+}
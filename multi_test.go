@@ -0,0 +1,169 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"sort"
+	"testing"
+)
+
+// multiEntry is an entry of the brute
+// force model of a MultiTree.
+type multiEntry struct {
+	key string
+	seq int
+}
+
+// checkMulti compares m against the model, which
+// it sorts by key and then insertion order.
+func checkMulti(t *testing.T, m *MultiTree, model []multiEntry) {
+	t.Helper()
+	sort.SliceStable(model, func(i, j int) bool {
+		if model[i].key != model[j].key {
+			return model[i].key < model[j].key
+		}
+		return model[i].seq < model[j].seq
+	})
+	if m.Size() != len(model) {
+		t.Fatalf("Size %v, want %v", m.Size(), len(model))
+	}
+	for i, e := range model {
+		key, val, ok := m.At(i)
+		if !ok || string(key) != e.key || val != e.seq {
+			t.Fatalf("At(%v) = %q, %v; want %q, %v", i, key, val, e.key, e.seq)
+		}
+	}
+	i := 0
+	for key, val := range m.Ascend(nil, nil) {
+		if string(key) != model[i].key || val != model[i].seq {
+			t.Fatalf("Ascend %v: %q, %v", i, key, val)
+		}
+		i++
+	}
+	for key, val := range m.Descend(nil, nil) {
+		i--
+		if string(key) != model[i].key || val != model[i].seq {
+			t.Fatalf("Descend %v: %q, %v", i, key, val)
+		}
+	}
+	if m.t.root != nil {
+		verifySubN(m.t.root)
+	}
+}
+
+func TestMultiTree_matches_model(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(15, 15))
+	// 0 and 0xff bytes test the escaping; short
+	// keys are prefixes of each other.
+	alphabet := []byte{0, 1, 'a', 0xff}
+	randKey := func() Key {
+		k := make(Key, rng.IntN(4))
+		for j := range k {
+			k[j] = alphabet[rng.IntN(len(alphabet))]
+		}
+		return k
+	}
+
+	m := NewMultiTree()
+	var model []multiEntry
+	for i := range 3000 {
+		k := randKey()
+		switch rng.IntN(10) {
+		case 0:
+			val, ok := m.RemoveOne(k)
+			want := -1
+			for j, e := range model {
+				if e.key == string(k) && (want < 0 || e.seq < model[want].seq) {
+					want = j
+				}
+			}
+			if ok != (want >= 0) || (ok && val != model[want].seq) {
+				t.Fatalf("RemoveOne(%q) = %v, %v", k, val, ok)
+			}
+			if ok {
+				model = append(model[:want], model[want+1:]...)
+			}
+		case 1:
+			var kept []multiEntry
+			for _, e := range model {
+				if e.key != string(k) {
+					kept = append(kept, e)
+				}
+			}
+			if n := m.RemoveAll(k); n != len(model)-len(kept) {
+				t.Fatalf("RemoveAll(%q) = %v, want %v", k, n, len(model)-len(kept))
+			}
+			model = kept
+		default:
+			m.Insert(k, i)
+			model = append(model, multiEntry{string(k), i})
+		}
+		if i%300 == 0 {
+			checkMulti(t, m, model)
+		}
+	}
+	checkMulti(t, m, model)
+
+	for range 200 {
+		k := randKey()
+		var vals []any
+		rank := 0
+		for _, e := range model {
+			if e.key == string(k) {
+				vals = append(vals, e.seq)
+			} else if e.key < string(k) {
+				rank++
+			}
+		}
+		if got := m.Values(k); fmt.Sprint(got) != fmt.Sprint(vals) {
+			t.Fatalf("Values(%q) = %v, want %v", k, got, vals)
+		}
+		if m.Count(k) != len(vals) || m.Rank(k) != rank {
+			t.Fatalf("Count(%q) = %v, Rank = %v; want %v, %v", k, m.Count(k), m.Rank(k), len(vals), rank)
+		}
+
+		// random ranges; the ends may or may not have entries.
+		lo, hi := randKey(), randKey()
+		if bytes.Compare(lo, hi) > 0 {
+			lo, hi = hi, lo
+		}
+		var asc, desc []string
+		for _, e := range model {
+			if e.key >= string(lo) && e.key < string(hi) {
+				asc = append(asc, fmt.Sprint(e.seq))
+			}
+			if e.key > string(lo) && e.key <= string(hi) {
+				desc = append([]string{fmt.Sprint(e.seq)}, desc...)
+			}
+		}
+		var gotAsc, gotDesc []string
+		for _, v := range m.Ascend(lo, hi) {
+			gotAsc = append(gotAsc, fmt.Sprint(v))
+		}
+		for _, v := range m.Descend(lo, hi) {
+			gotDesc = append(gotDesc, fmt.Sprint(v))
+		}
+		if fmt.Sprint(gotAsc) != fmt.Sprint(asc) || fmt.Sprint(gotDesc) != fmt.Sprint(desc) {
+			t.Fatalf("[%q, %q): Ascend %v, want %v; Descend %v, want %v", lo, hi, gotAsc, asc, gotDesc, desc)
+		}
+	}
+}
+
+// The median of measurements with many repeats.
+func TestMultiTree_percentile(t *testing.T) {
+	m := NewMultiTree()
+	for _, ms := range []int{30, 10, 20, 10, 10, 40, 20} {
+		m.Insert(Key(fmt.Sprintf("%04d", ms)), ms)
+	}
+	if m.Size() != 7 {
+		t.Fatalf("Size %v", m.Size())
+	}
+	key, _, _ := m.At(m.Size() / 2)
+	if string(key) != "0020" {
+		t.Fatalf("median %q", key)
+	}
+	if m.Rank(Key("0020")) != 3 || m.Count(Key("0010")) != 3 {
+		t.Fatalf("Rank %v, Count %v", m.Rank(Key("0020")), m.Count(Key("0010")))
+	}
+}
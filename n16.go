@@ -1,6 +1,9 @@
 package uart
 
-import "fmt"
+import (
+	"fmt"
+	"math/bits"
+)
 
 type node16 struct {
 	lth      int
@@ -42,7 +45,52 @@ func (n *node16) kind() kind {
 	return _Node16
 }
 
+// cmp16Go is cmp16 in Go. It returns masks of the
+// keys equal to k, and of the keys >= k.
+func cmp16Go(keys *[16]byte, k byte) (eq, ge uint32) {
+	for i, b := range keys {
+		if b == k {
+			eq |= 1 << i
+		}
+		if b >= k {
+			ge |= 1 << i
+		}
+	}
+	return
+}
+
+// used is the mask of the keys in use,
+// for the masks from cmp16.
+func (n *node16) used() uint32 {
+	return 1<<n.lth - 1
+}
+
+// firstOf and lastOf return the key and child
+// of the first and last bit set in mask m.
+func (n *node16) firstOf(m uint32) (byte, *bnode) {
+	if m == 0 {
+		return 0, nil
+	}
+	i := bits.TrailingZeros32(m)
+	return n.keys[i], n.children[i]
+}
+
+func (n *node16) lastOf(m uint32) (byte, *bnode) {
+	if m == 0 {
+		return 0, nil
+	}
+	i := 31 - bits.LeadingZeros32(m)
+	return n.keys[i], n.children[i]
+}
+
 func (n *node16) index(k byte) int {
+	if haveCmp16 {
+		_, ge := cmp16(&n.keys, k)
+		if m := ge & n.used(); m != 0 {
+			return bits.TrailingZeros32(m)
+		}
+		return int(n.lth)
+	}
 	for i, b := range n.keys {
 		if k <= b {
 			return i
@@ -63,6 +111,14 @@ func index(key *byte, nkey *[16]byte) (int, bool) {
 }
 
 func (n *node16) child(k byte) (idx int, ch *bnode) {
+	if haveCmp16 {
+		eq, _ := cmp16(&n.keys, k)
+		if m := eq & n.used(); m != 0 {
+			idx = bits.TrailingZeros32(m)
+			ch = n.children[idx]
+		}
+		return
+	}
 	var key byte
 	for idx, key = range n.keys {
 		if key == k {
@@ -77,6 +133,10 @@ func (n *node16) next(k *byte) (byte, *bnode) {
 	if k == nil {
 		return n.keys[0], n.children[0]
 	}
+	if haveCmp16 {
+		eq, ge := cmp16(&n.keys, *k)
+		return n.firstOf(ge &^ eq & n.used())
+	}
 	for i, b := range n.keys {
 		if b > *k {
 			return b, n.children[i]
@@ -95,6 +155,10 @@ func (n *node16) gte(k *byte) (byte, *bnode) {
 	if k == nil {
 		return n.keys[0], n.children[0]
 	}
+	if haveCmp16 {
+		_, ge := cmp16(&n.keys, *k)
+		return n.firstOf(ge & n.used())
+	}
 
 	for i, b := range n.keys {
 		if b >= *k {
@@ -114,6 +178,10 @@ func (n *node16) gt(k *byte) (byte, *bnode) {
 	if k == nil {
 		return n.keys[0], n.children[0]
 	}
+	if haveCmp16 {
+		eq, ge := cmp16(&n.keys, *k)
+		return n.firstOf(ge &^ eq & n.used())
+	}
 	for i, b := range n.keys {
 		if b > *k {
 			return b, n.children[i]
@@ -127,6 +195,10 @@ func (n *node16) prev(k *byte) (byte, *bnode) {
 		idx := n.lth - 1
 		return n.keys[idx], n.children[idx]
 	}
+	if haveCmp16 {
+		_, ge := cmp16(&n.keys, *k)
+		return n.lastOf(^ge & n.used())
+	}
 	// we use an int for lnt now to avoid underflow.
 	for i := n.lth - 1; i >= 0; i-- {
 		if n.keys[i] < *k {
@@ -189,10 +261,9 @@ func (n *node16) redoPren() {
 	}
 }
 
-func (n *node16) grow() inode {
-	nn := &node48{
-		lth: n.lth,
-	}
+func (n *node16) grow(a *slabs) inode {
+	nn := a.node48()
+	nn.lth = n.lth
 	copy(nn.children[:], n.children[:])
 	for i, child := range n.children {
 		if child == nil {
@@ -208,13 +279,13 @@ func (n *node16) min() bool {
 	return n.lth <= 5
 }
 
-func (n *node16) shrink() inode {
-	nn := node4{}
+func (n *node16) shrink(a *slabs) inode {
+	nn := a.node4()
 	copy(nn.keys[:], n.keys[:])
 	copy(nn.children[:], n.children[:])
 	nn.lth = n.lth
 	nn.redoPren()
-	return &nn
+	return nn
 }
 
 func (n *node16) String() string {
@@ -231,6 +302,10 @@ func (n *node16) lt(k *byte) (keyb byte, ch *bnode) {
 	if k == nil {
 		return n.keys[n.lth-1], n.children[n.lth-1]
 	}
+	if haveCmp16 {
+		_, ge := cmp16(&n.keys, *k)
+		return n.lastOf(^ge & n.used())
+	}
 	for idx := n.lth - 1; idx >= 0; idx-- {
 		b := n.keys[idx]
 		if b < *k {
@@ -250,6 +325,10 @@ func (n *node16) lte(k *byte) (byte, *bnode) {
 	if k == nil {
 		return n.keys[n.lth-1], n.children[n.lth-1]
 	}
+	if haveCmp16 {
+		eq, ge := cmp16(&n.keys, *k)
+		return n.lastOf((^ge | eq) & n.used())
+	}
 	for idx := n.lth - 1; idx >= 0; idx-- {
 		b := n.keys[idx]
 		if b <= *k {
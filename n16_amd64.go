@@ -0,0 +1,15 @@
+//go:build !purego
+
+package uart
+
+// haveCmp16 is true when cmp16 is the SSE2
+// assembly in n16_amd64.s.
+const haveCmp16 = true
+
+// cmp16 compares k with each of the 16 keys with
+// one SSE2 compare, and returns masks of them: bit i
+// of eq is set if keys[i] == k, and bit i of ge if
+// keys[i] >= k. See cmp16Go.
+//
+//go:noescape
+func cmp16(keys *[16]byte, k byte) (eq, ge uint32)
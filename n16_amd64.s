@@ -0,0 +1,23 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func cmp16(keys *[16]byte, k byte) (eq, ge uint32)
+TEXT ·cmp16(SB), NOSPLIT, $0-24
+	MOVQ	keys+0(FP), AX
+	MOVOU	(AX), X0                // the keys
+	MOVBLZX	k+8(FP), CX
+	IMULL	$0x01010101, CX         // k in each byte
+	MOVL	CX, X1
+	PSHUFD	$0, X1, X1              // and in each of the 16
+	MOVO	X0, X2
+	PCMPEQB	X1, X2
+	PMOVMSKB	X2, DX
+	MOVL	DX, eq+16(FP)
+	// keys[i] >= k just when max(keys[i], k) == keys[i].
+	MOVO	X0, X3
+	PMAXUB	X1, X3
+	PCMPEQB	X0, X3
+	PMOVMSKB	X3, DX
+	MOVL	DX, ge+20(FP)
+	RET
@@ -0,0 +1,11 @@
+//go:build !amd64 || purego
+
+package uart
+
+// haveCmp16 is false: the node16 lookups
+// scan the keys in a loop instead.
+const haveCmp16 = false
+
+func cmp16(keys *[16]byte, k byte) (eq, ge uint32) {
+	return cmp16Go(keys, k)
+}
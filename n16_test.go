@@ -0,0 +1,95 @@
+package uart
+
+import (
+	mathrand2 "math/rand/v2"
+	"sort"
+	"testing"
+)
+
+func TestCmp16_matches_Go(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(19, 19))
+	var keys [16]byte
+	for range 2000 {
+		for i := range keys {
+			keys[i] = byte(rng.IntN(256))
+		}
+		for k := range 256 {
+			eq, ge := cmp16(&keys, byte(k))
+			weq, wge := cmp16Go(&keys, byte(k))
+			if eq != weq || ge != wge {
+				t.Fatalf("cmp16(%x, %x) = %x, %x; want %x, %x", keys, k, eq, ge, weq, wge)
+			}
+		}
+	}
+}
+
+// The node16 lookups, against a scan of the
+// sorted keys, for every size and probe byte.
+func TestNode16_lookups(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(19, 20))
+	for trial := range 300 {
+		lth := 1 + trial%16
+		var keys []byte
+		for _, k := range rng.Perm(256)[:lth] {
+			keys = append(keys, byte(k))
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		n := &node16{}
+		for _, k := range keys {
+			n.addChild(k, bnodeLeaf(&Leaf{Key: Key{k}}))
+		}
+		if string(n.keys[:n.lth]) != string(keys) {
+			t.Fatalf("addChild: keys %x, want %x", n.keys[:n.lth], keys)
+		}
+
+		// want returns the key of the first (or last,
+		// if last is set) of keys for which ok is true.
+		want := func(ok func(b byte) bool, last bool) (byte, bool) {
+			var got byte
+			found := false
+			for _, b := range keys {
+				if ok(b) && (last || !found) {
+					got, found = b, true
+				}
+			}
+			return got, found
+		}
+		check := func(what string, k int, b byte, ch *bnode, wb byte, wok bool) {
+			t.Helper()
+			if (ch != nil) != wok || (wok && (b != wb || ch.leaf.Key[0] != b)) {
+				t.Fatalf("%v(%x) in %x = %x, %v; want %x, %v", what, k, keys, b, ch != nil, wb, wok)
+			}
+		}
+		for k := range 256 {
+			kb := byte(k)
+			idx, ch := n.child(kb)
+			wb, wok := want(func(b byte) bool { return b == kb }, false)
+			check("child", k, n.keys[idx], ch, wb, wok)
+
+			b, ch := n.gte(&kb)
+			wb, wok = want(func(b byte) bool { return b >= kb }, false)
+			check("gte", k, b, ch, wb, wok)
+
+			wb, wok = want(func(b byte) bool { return b > kb }, false)
+			b, ch = n.gt(&kb)
+			check("gt", k, b, ch, wb, wok)
+			b, ch = n.next(&kb)
+			check("next", k, b, ch, wb, wok)
+
+			wb, wok = want(func(b byte) bool { return b < kb }, true)
+			b, ch = n.lt(&kb)
+			check("lt", k, b, ch, wb, wok)
+			b, ch = n.prev(&kb)
+			check("prev", k, b, ch, wb, wok)
+
+			b, ch = n.lte(&kb)
+			wb, wok = want(func(b byte) bool { return b <= kb }, true)
+			check("lte", k, b, ch, wb, wok)
+
+			wi := sort.Search(len(keys), func(i int) bool { return keys[i] >= kb })
+			if i := n.index(kb); i != wi {
+				t.Fatalf("index(%x) in %x = %v, want %v", k, keys, i, wi)
+			}
+		}
+	}
+}
@@ -159,7 +159,7 @@ func (n *node256) redoPren() {
 	}
 }
 
-func (n *node256) grow() inode {
+func (n *node256) grow(a *slabs) inode {
 	return nil
 }
 
@@ -167,10 +167,9 @@ func (n *node256) min() bool {
 	return n.lth <= 49
 }
 
-func (n *node256) shrink() inode {
-	nn := &node48{
-		lth: n.lth,
-	}
+func (n *node256) shrink(a *slabs) inode {
+	nn := a.node48()
+	nn.lth = n.lth
 	var index uint16
 	for i := range n.children {
 		if n.children[i] == nil {
@@ -182,8 +182,8 @@ func (n *node4) full() bool {
 	return n.lth == 4
 }
 
-func (n *node4) grow() inode {
-	nn := &node16{}
+func (n *node4) grow(a *slabs) inode {
+	nn := a.node16()
 	nn.lth = n.lth
 	copy(nn.keys[:], n.keys[:])
 	copy(nn.children[:], n.children[:])
@@ -195,7 +195,7 @@ func (n *node4) min() bool {
 	return n.lth <= 2
 }
 
-func (n *node4) shrink() inode {
+func (n *node4) shrink(a *slabs) inode {
 	panic("can't shrink node4")
 }
 
@@ -18,7 +18,7 @@ func (n *node48) last() (byte, *bnode) {
 	for i := 255; i >= 0; i-- {
 		k := n.keys[i]
 		if k != 0 {
-			return byte(k - 1), n.children[k-1]
+			return byte(i), n.children[k-1]
 		}
 	}
 	//panic("unreachable since node48 must have >= 17 children")
@@ -26,9 +26,9 @@ func (n *node48) last() (byte, *bnode) {
 }
 
 func (n *node48) first() (byte, *bnode) {
-	for _, k := range n.keys {
+	for i, k := range n.keys {
 		if k != 0 {
-			return byte(k - 1), n.children[k-1]
+			return byte(i), n.children[k-1]
 		}
 	}
 	//panic("unreachable since node48 must have >= 17 children")
@@ -166,10 +166,9 @@ func (n *node48) redoPren() {
 	}
 }
 
-func (n *node48) grow() inode {
-	nn := &node256{
-		lth: n.lth,
-	}
+func (n *node48) grow(a *slabs) inode {
+	nn := a.node256()
+	nn.lth = n.lth
 	for b, i := range n.keys {
 		if i == 0 {
 			continue
@@ -205,10 +204,9 @@ func (n *node48) min() bool {
 	return n.lth <= 17
 }
 
-func (n *node48) shrink() inode {
-	nn := &node16{
-		lth: n.lth,
-	}
+func (n *node48) shrink(a *slabs) inode {
+	nn := a.node16()
+	nn.lth = n.lth
 	nni := 0
 	for i, idx := range n.keys {
 		if idx == 0 {
@@ -66,18 +66,18 @@ func Test_n4_clone_grow_shrink(t *testing.T) {
 		}
 	}
 	check(n4)
-	n16 := n4.grow().(*node16)
+	n16 := n4.grow(nil).(*node16)
 	check(n16)
-	n48 := n16.grow().(*node48)
+	n48 := n16.grow(nil).(*node48)
 	check(n48)
-	n256 := n48.grow().(*node256)
+	n256 := n48.grow(nil).(*node256)
 	check(n256)
 
-	nn48 := n256.shrink().(*node48)
+	nn48 := n256.shrink(nil).(*node48)
 	check(nn48)
-	nn16 := nn48.shrink().(*node16)
+	nn16 := nn48.shrink(nil).(*node16)
 	check(nn16)
-	nn4 := nn16.shrink().(*node4)
+	nn4 := nn16.shrink(nil).(*node4)
 	check(nn4)
 
 }
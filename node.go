@@ -2,27 +2,9 @@ package uart
 
 import (
 	"fmt"
-	"sync"
-	//"sync/atomic"
+	"iter"
 )
 
-var _ = sync.RWMutex{}
-
-// turn off n4/n16/... fine grained locking,
-// depending only on the inner and Leaf RWMutex.
-type artlock = nolock
-
-// adds ~ 120 nsec to each operation (800 nsec -> 920 nsec)
-// so about 15% longer (under 100% write contention).
-//type artlock = sync.RWMutex // turn on n4... locking
-
-type nolock struct{}
-
-func (n *nolock) Lock()    {}
-func (n *nolock) Unlock()  {}
-func (n *nolock) RLock()   {}
-func (n *nolock) RUnlock() {}
-
 // type Key []byte
 type kind uint8
 
@@ -32,6 +14,7 @@ const (
 	_Node16
 	_Node48
 	_Node256
+	_Bucket
 )
 
 func bnodeLeaf(lf *Leaf) *bnode {
@@ -114,6 +97,9 @@ func (a *bnode) at(i int) (r *Leaf, ok bool) {
 		// i too large, out of bounds
 		return nil, false
 	}
+	if bk, ok := n.asBucket(); ok {
+		return bk.leaves[i], true
+	}
 	tot := 0
 	pre := 0
 	subn := 0
@@ -147,23 +133,23 @@ func (a *bnode) String() string {
 
 func (a *bnode) get(key Key, depth int, selfb *bnode, calldepth int, tree *Tree) (value *bnode, found bool, dir direc, id int) {
 	if a.isLeaf {
-		return a.leaf.get(key, depth, a)
+		return a.leaf.get(key, 0, a)
 	}
 	return a.inner.get(key, depth, a, calldepth, tree)
 }
 
-func (a *bnode) del(key Key, depth int, selfb *bnode, parentUpdate func(*bnode)) (deleted bool, deletedNode *bnode) {
+func (a *bnode) del(key Key, depth int, selfb *bnode, tree *Tree, parentUpdate func(*bnode)) (deleted bool, deletedNode *bnode) {
 	if a.isLeaf {
-		return a.leaf.del(key, depth, selfb, parentUpdate)
+		return a.leaf.del(key, depth, selfb, tree, parentUpdate)
 	}
-	return a.inner.del(key, depth, selfb, parentUpdate)
+	return a.inner.del(key, depth, selfb, tree, parentUpdate)
 }
 
-func (a *bnode) insert(lf *Leaf, depth int, selfb *bnode, tree *Tree, par *inner) (*bnode, bool) {
+func (a *bnode) insert(lf *Leaf, depth int, selfb *bnode, tree *Tree, par *inner, u *upsert) (*bnode, bool) {
 	if a.isLeaf {
-		return a.leaf.insert(lf, depth, selfb, tree, par)
+		return a.leaf.insert(lf, depth, selfb, tree, par, u)
 	}
-	return a.inner.insert(lf, depth, selfb, tree, par)
+	return a.inner.insert(lf, depth, selfb, tree, par, u)
 }
 
 func (a *bnode) FlatString(depth int, recurse int, selfb *bnode) (s string) {
@@ -192,6 +178,8 @@ func (k kind) String() string {
 		return "node48"
 	case _Node256:
 		return "node256"
+	case _Bucket:
+		return "bucket"
 	}
 	//panic(fmt.Sprintf("unknown kind '%v'", int(k)))
 	return ""
@@ -211,6 +199,13 @@ func (key Key) At(pos int) byte {
 // node4/node16/node48/node256 inside.
 type inner struct {
 
+	// version is odd while a writer is changing
+	// this node, and is bumped again when it is
+	// done; see WithOptimisticReads. It is read
+	// and written atomically. It is not an
+	// atomic.Uint64 since cowClone copies the node.
+	version uint64
+
 	// compressed implements path compression.
 	compressed []byte
 
@@ -241,6 +236,13 @@ type inner struct {
 	// keybyte gives the byte that leads
 	// to us in the parent index.
 	keybyte byte
+
+	// gen is the Tree.gen of the tree that created
+	// (or copied) this node. Once a tree is in
+	// copy-on-write mode (see Tree.Snapshot), it
+	// only modifies inner nodes of its own gen,
+	// copying any others first.
+	gen uint64
 }
 
 func (n *inner) gte(k *byte) (byte, *bnode) {
@@ -309,14 +311,15 @@ type inode interface {
 	// full is true if node reached max size
 	full() bool
 	// grow the node to next size
-	// node256 can't grow and will return nil
-	grow() inode
+	// node256 can't grow and will return nil.
+	// The new node comes from a, which may be nil.
+	grow(a *slabs) inode
 
 	// min is true if node reached min size
 	min() bool
 	// shrink is the opposite to grow
 	// if node is of the smallest type (node4) nil will be returned
-	shrink() inode
+	shrink(a *slabs) inode
 
 	String() string
 }
@@ -385,14 +388,14 @@ func (a *bnode) next(k *byte) (byte, *bnode) {
 
 func (a *bnode) getGTE(key Key, depth int, smod SearchModifier, selfb *bnode, tree *Tree, calldepth int, smallestWillDo bool, keyCmpPath int) (value *bnode, found bool, dir direc, id int) {
 	if a.isLeaf {
-		return a.leaf.get(key, depth, a)
+		return a.leaf.get(key, keyCmpPath, a)
 	}
 	return a.inner.getGTE(key, depth, smod, a, tree, calldepth, smallestWillDo, keyCmpPath)
 }
 
 func (a *bnode) getLTE(key Key, depth int, smod SearchModifier, selfb *bnode, tree *Tree, calldepth int, largestWillDo bool, keyCmpPath int) (value *bnode, found bool, dir direc, id int) {
 	if a.isLeaf {
-		return a.leaf.get(key, depth, a)
+		return a.leaf.get(key, keyCmpPath, a)
 	}
 	return a.inner.getLTE(key, depth, smod, a, tree, calldepth, largestWillDo, keyCmpPath)
 }
@@ -465,6 +468,8 @@ func (b *bnode) subTreeRedoPren() (leafcount int) {
 				pren += subn
 			}
 		}
+	case *bucket:
+		leafcount = len(n.leaves)
 	}
 
 	// todo remove this, once sanity check ensured.
@@ -478,3 +483,113 @@ func (b *bnode) subTreeRedoPren() (leafcount int) {
 
 	return leafcount
 }
+
+// kids iterates over the children of n in key order,
+// yielding each child's keybyte and bnode.
+func (n *inner) kids() iter.Seq2[byte, *bnode] {
+	return func(yield func(byte, *bnode) bool) {
+		switch x := n.Node.(type) {
+		case *node4:
+			for i := 0; i < x.lth; i++ {
+				if !yield(x.keys[i], x.children[i]) {
+					return
+				}
+			}
+		case *node16:
+			for i := 0; i < x.lth; i++ {
+				if !yield(x.keys[i], x.children[i]) {
+					return
+				}
+			}
+		case *node48:
+			for i, k := range x.keys {
+				if k == 0 {
+					continue
+				}
+				if !yield(byte(i), x.children[k-1]) {
+					return
+				}
+			}
+		case *node256:
+			for i, ch := range x.children {
+				if ch == nil {
+					continue
+				}
+				if !yield(byte(i), ch) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// newInode returns the smallest of node4/node16/node48/node256
+// that can hold len(children) children, filled in the given
+// order. keys must be sorted and parallel to children.
+// The caller is responsible for SubN and pren.
+func newInode(keys []byte, children []*bnode) inode {
+	n := len(children)
+	switch {
+	case n <= 4:
+		nn := &node4{lth: n}
+		copy(nn.keys[:], keys)
+		copy(nn.children[:], children)
+		return nn
+	case n <= 16:
+		nn := &node16{lth: n}
+		copy(nn.keys[:], keys)
+		copy(nn.children[:], children)
+		return nn
+	case n <= 48:
+		nn := &node48{lth: n}
+		for i, k := range keys {
+			nn.keys[k] = uint16(i + 1)
+			nn.children[i] = children[i]
+		}
+		return nn
+	}
+	nn := &node256{lth: n}
+	for i, k := range keys {
+		nn.children[k] = children[i]
+	}
+	return nn
+}
+
+// innerFrom returns a bnode holding a new inner node with
+// the given compressed prefix and (sorted) children.
+// SubN and every child's pren are computed
+// here, so the result has prenOK true.
+func innerFrom(compressed []byte, keybyte byte, keys []byte, children []*bnode) *bnode {
+	n := &inner{
+		compressed: compressed,
+		keybyte:    keybyte,
+		Node:       newInode(keys, children),
+		prenOK:     true,
+	}
+	for _, ch := range children {
+		ch.pren = n.SubN
+		n.SubN += ch.subn()
+	}
+	return bnodeInner(n)
+}
+
+// innerCopying is like innerFrom, but for children that
+// may be shared with other nodes, as in copy-on-write
+// mode: each child bnode is copied first, since innerFrom
+// sets its pren. The new inner belongs to generation gen,
+// and has prenOK only if all of its inner children do.
+func innerCopying(compressed []byte, keybyte byte, keys []byte, children []*bnode, gen uint64) *bnode {
+	slab := make([]bnode, len(children))
+	prenOK := true
+	for i, ch := range children {
+		slab[i] = *ch
+		children[i] = &slab[i]
+		if !ch.isLeaf && !ch.inner.prenOK {
+			prenOK = false
+		}
+	}
+	b := innerFrom(compressed, keybyte, keys, children)
+	b.inner.gen = gen
+	b.inner.prenOK = prenOK
+	return b
+}
@@ -0,0 +1,480 @@
+package uart
+
+import (
+	"bytes"
+	"runtime"
+	"sync/atomic"
+)
+
+// WithOptimisticReads returns a TreeOption that lets
+// Find (and FindExact, FindGTE and the like), At,
+// Atfar, Atv, Size, and IsEmpty read the tree
+// without taking the read lock.
+//
+// Each inner node carries a version number, which a
+// writer makes odd while it changes the node, and
+// even again once the write is done. A reader notes
+// the version of each node as it gets there, copies
+// out what it needs, and checks that the version is
+// unchanged before it uses what it copied; on its
+// way to a child, it checks the parent's version once
+// it has the child's. If a check fails, the read
+// starts over, and after a few tries it takes the
+// read lock after all.
+//
+// Writers still exclude each other with the write
+// lock, and Insert, InsertLeaf, Remove, Update,
+// CompareAndSwap, and LoadOrStore latch (make odd)
+// only the inner nodes they change. But every one
+// of those writes changes the counts that At and the
+// indexes from Find come from (each node's SubN,
+// and the pren of the child taken) on the whole path
+// from the root to its key, so it latches the root
+// too. A reader that starts during a write thus
+// starts over much as it would have waited for the
+// lock; what the readers gain is that they write no
+// shared lock word, so readers on many cores do not
+// pass its cache line back and forth, and a reader
+// already below the root is held up only by writes
+// under the same nodes. The other writes, such as
+// Apply and DeleteRange, latch the whole tree at
+// once. The other reads, such as the iterators,
+// take the read lock as before.
+//
+// A writer brings every stale pren up to date
+// before its latches are dropped, since the readers
+// must not write to the tree. Nodes are never
+// recycled (see WithSlabAllocator), since a reader
+// may still be looking at a node a write freed.
+//
+// The version checks rely on amd64 keeping loads
+// in order. Elsewhere, and under the race detector,
+// which would report the readers' unlocked loads,
+// the option makes readers take the read lock as
+// usual. A tree cannot have both optimistic reads
+// and buckets (see WithBuckets), whose leaves are
+// moved around in place.
+func WithOptimisticReads() TreeOption {
+	return func(t *Tree) {
+		t.olc = &olcState{}
+	}
+}
+
+// olcTries is how many times an optimistic read
+// starts over before it takes the read lock.
+const olcTries = 8
+
+// olcState holds the tree-wide versions of
+// WithOptimisticReads, which are only read and
+// changed atomically, and the latches of the write
+// under way, which are the writer's alone.
+type olcState struct {
+	// bulk is odd while a write that latches
+	// no nodes of its own is under way; see lock.
+	// root is odd during any other write, and
+	// guards Tree.root, Tree.size, and the root
+	// bnode itself.
+	bulk uint64
+	root uint64
+
+	// path is set during a write that latches
+	// the nodes it changes; latched holds them.
+	path    bool
+	latched []*inner
+}
+
+// optimistic reports whether reads of t are
+// made without the read lock.
+func (t *Tree) optimistic() bool {
+	return optimisticOK && t.olc != nil && !t.SkipLocking
+}
+
+// latch makes the version of n odd, before the
+// write under way changes n, unless it has already.
+// The caller holds the write lock.
+func (t *Tree) latch(n *inner) {
+	o := t.olc
+	if !optimisticOK || o == nil || !o.path || n.version&1 != 0 {
+		return
+	}
+	atomic.AddUint64(&n.version, 1)
+	o.latched = append(o.latched, n)
+}
+
+// release ends the write under way: it brings pren
+// up to date, and then drops the write's latches.
+// The caller holds the write lock.
+func (o *olcState) release(t *Tree) {
+	if t.root != nil {
+		t.root.subTreeRedoPren()
+	}
+	for i, n := range o.latched {
+		atomic.AddUint64(&n.version, 1)
+		o.latched[i] = nil
+	}
+	o.latched = o.latched[:0]
+	if o.path {
+		o.path = false
+		atomic.AddUint64(&o.root, 1)
+		return
+	}
+	atomic.AddUint64(&o.bulk, 1)
+}
+
+// olcReader is one try of an optimistic read. ver
+// is the version that guards the fields it is now
+// reading, and v the value it had when they were
+// reached; bulk is the tree's bulk version then.
+type olcReader struct {
+	o    *olcState
+	bulk uint64
+	ver  *uint64
+	v    uint64
+}
+
+// start begins a try at the root of the tree.
+// It reports false if a write is under way.
+func (r *olcReader) start(o *olcState) bool {
+	r.o = o
+	r.bulk = atomic.LoadUint64(&o.bulk)
+	r.ver = &o.root
+	r.v = atomic.LoadUint64(r.ver)
+	return (r.bulk|r.v)&1 == 0
+}
+
+// valid reports whether no write has
+// changed the fields r is reading.
+func (r *olcReader) valid() bool {
+	return atomic.LoadUint64(r.ver) == r.v &&
+		atomic.LoadUint64(&r.o.bulk) == r.bulk
+}
+
+// enter moves r down to n, read under its current
+// version. Checking that version again once n's is
+// in hand makes sure n was still the node to go to.
+func (r *olcReader) enter(n *inner) bool {
+	if !r.valid() {
+		return false
+	}
+	v := atomic.LoadUint64(&n.version)
+	if v&1 != 0 || !r.valid() {
+		return false
+	}
+	r.ver, r.v = &n.version, v
+	return true
+}
+
+// retry is called before each try but the first,
+// to give a writer on this P a chance to finish.
+func retry(try int) {
+	if try > 0 {
+		runtime.Gosched()
+	}
+}
+
+// findOptimistic is Find with WithOptimisticReads.
+func (t *Tree) findOptimistic(smod SearchModifier, key Key) (lf *Leaf, idx int, found bool) {
+	var r olcReader
+	for try := range olcTries {
+		retry(try)
+		if !r.start(t.olc) {
+			continue
+		}
+		var ok bool
+		if lf, idx, found, ok = r.find(t, smod, key); ok {
+			return
+		}
+	}
+	rl := t.rlock()
+	defer rl.RUnlock()
+	return t.find_unlocked(smod, key)
+}
+
+// atOptimistic is Atfar with WithOptimisticReads.
+func (t *Tree) atOptimistic(i int) (lf *Leaf, found bool) {
+	var r olcReader
+	for try := range olcTries {
+		retry(try)
+		if !r.start(t.olc) {
+			continue
+		}
+		var ok bool
+		if lf, found, ok = r.at(t, i); ok {
+			return
+		}
+	}
+	rl := t.rlock()
+	defer rl.RUnlock()
+	if t.root == nil {
+		return
+	}
+	return t.rootAt(i)
+}
+
+// sizeOptimistic returns t.size, if it can read
+// it without a write under way.
+func (t *Tree) sizeOptimistic() (sz int, ok bool) {
+	var r olcReader
+	for try := range olcTries {
+		retry(try)
+		if !r.start(t.olc) {
+			continue
+		}
+		sz = int(t.size)
+		if r.valid() {
+			return sz, true
+		}
+	}
+	return 0, false
+}
+
+// root copies out the tree's root bnode and size.
+// ok is false if they may be torn.
+func (r *olcReader) root(t *Tree) (b bnode, size int, empty, ok bool) {
+	root := t.root
+	size = int(t.size)
+	if root != nil {
+		b = *root
+	}
+	return b, size, root == nil, r.valid()
+}
+
+// find is findByRank for an optimistic reader,
+// for a tree with or without compact leaves.
+// ok is false if a write got in the way.
+func (r *olcReader) find(t *Tree, smod SearchModifier, key Key) (lf *Leaf, idx int, found, ok bool) {
+	b, size, empty, ok := r.root(t)
+	if !ok || empty {
+		return nil, 0, false, ok
+	}
+	if len(key) == 0 && (smod != Exact || size == 1) {
+		// see findByRank.
+		last := smod == LTE || smod == LT
+		if last {
+			idx = size - 1
+		}
+		lf, ok = r.edgeLeaf(b, nil, last)
+		return lf, idx, ok, ok
+	}
+	before, eq, lf, ok := r.locate(b, key, smod)
+	if !ok {
+		return nil, 0, false, false
+	}
+	lf, idx, found = rankResult(smod, before, eq, lf)
+	return lf, idx, found, true
+}
+
+// olcFrame is a rankFrame of an optimistic
+// reader, with the node's version and its
+// node4/16/48/256 as they were read.
+type olcFrame struct {
+	n    *inner
+	v    uint64
+	node inode
+	kb   byte
+	pos  int
+}
+
+// locate is bnode.locate for an optimistic reader,
+// from b, the root bnode as it was read. Each field
+// of an inner node is copied out before its version
+// is checked, and is used only after; ok is false if
+// a check failed. A leaf is never changed once it is
+// in the tree, so a leaf reached is read as is.
+func (r *olcReader) locate(b bnode, key Key, smod SearchModifier) (before int, eq bool, lf *Leaf, ok bool) {
+	below := smod == LTE || smod == LT
+	above := smod == GTE || smod == GT
+	takeEq := smod == Exact || smod == GTE || smod == LTE
+
+	var stack [32]olcFrame
+	frames := stack[:0]
+	depth := 0
+descent:
+	for {
+		if b.isLeaf {
+			switch c := b.leaf.compare(key, 0); {
+			case c > 0:
+				before++
+				if below {
+					return before, false, b.leaf.full(key), true
+				}
+			case c < 0:
+				if above {
+					return before, false, b.leaf.full(key), true
+				}
+			default:
+				eq = true
+				if takeEq {
+					return before, true, b.leaf.full(key), true
+				}
+			}
+			break descent
+		}
+		n := b.inner
+		if !r.enter(n) {
+			return
+		}
+		c, subN, node, prenOK := n.compressed, n.SubN, n.Node, n.prenOK
+		if !r.valid() || !prenOK {
+			return
+		}
+		part := key[min(depth, len(key)):min(depth+len(c), len(key))]
+		if cmp := bytes.Compare(part, c); cmp != 0 {
+			if cmp > 0 {
+				before += subN
+				if below {
+					lf, ok = r.edgeLeaf(b, keyPath(key, depth), true)
+					return before, false, lf, ok
+				}
+			} else if above {
+				lf, ok = r.edgeLeaf(b, keyPath(key, depth), false)
+				return before, false, lf, ok
+			}
+			break descent
+		}
+		pos := depth + len(c)
+		kb := key.At(pos)
+		if below || above {
+			frames = append(frames, olcFrame{n: n, v: r.v, node: node, kb: kb, pos: pos})
+		}
+		if _, ch := node.child(kb); ch != nil {
+			next := *ch
+			if !r.valid() {
+				return
+			}
+			before += next.pren
+			b = next
+			depth = pos + 1
+			continue
+		}
+		k := kb
+		pren := subN
+		if _, ch := node.gte(&k); ch != nil {
+			pren = ch.pren
+		}
+		if !r.valid() {
+			return
+		}
+		before += pren
+		break
+	}
+	if len(frames) == 0 {
+		return before, eq, nil, true
+	}
+	// as in locate, but each frame's node is checked
+	// to be as it was when the descent went through it.
+	// If it is, so is everything under it that the
+	// descent read, since a write that changed that
+	// would have latched it too.
+	p := keyPath(key, frames[len(frames)-1].pos+1)
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		r.ver, r.v = &f.n.version, f.v
+		p = p[:f.pos+1]
+		p[f.pos] = f.kb
+		var ch *bnode
+		if below {
+			p[f.pos], ch = f.node.lt(&p[f.pos])
+		} else {
+			p[f.pos], ch = f.node.gt(&p[f.pos])
+		}
+		var next bnode
+		if ch != nil {
+			next = *ch
+		}
+		if !r.valid() {
+			return
+		}
+		if ch != nil {
+			lf, ok = r.edgeLeaf(next, p, below)
+			return before, eq, lf, ok
+		}
+	}
+	return before, eq, nil, true
+}
+
+// edgeLeaf is bnode.edgeLeaf for an optimistic
+// reader, from b, read under r's current version.
+func (r *olcReader) edgeLeaf(b bnode, p Key, last bool) (*Leaf, bool) {
+	for !b.isLeaf {
+		n := b.inner
+		if !r.enter(n) {
+			return nil, false
+		}
+		c, node := n.compressed, n.Node
+		if !r.valid() {
+			return nil, false
+		}
+		var kb byte
+		var ch *bnode
+		if last {
+			kb, ch = node.last()
+		} else {
+			kb, ch = node.first()
+		}
+		if ch == nil {
+			// only a node being changed has no children.
+			return nil, false
+		}
+		b = *ch
+		if !r.valid() {
+			return nil, false
+		}
+		p = append(p, c...)
+		p = append(p, kb)
+	}
+	lf := b.leaf
+	if lf.base == 0 {
+		return lf, true
+	}
+	return &Leaf{Key: append(p[:lf.base], lf.Key...), Value: lf.Value}, true
+}
+
+// at is rootAt for an optimistic reader. It goes
+// down by the pren of the children, which, unlike
+// their SubN, are guarded by the version of the
+// node they are read from.
+func (r *olcReader) at(t *Tree, i int) (lf *Leaf, found, ok bool) {
+	b, size, empty, ok := r.root(t)
+	if !ok || empty || i < 0 || i >= size {
+		return nil, false, ok
+	}
+	var path Key
+	for !b.isLeaf {
+		n := b.inner
+		if !r.enter(n) {
+			return nil, false, false
+		}
+		c, node, prenOK := n.compressed, n.Node, n.prenOK
+		if !r.valid() || !prenOK {
+			return nil, false, false
+		}
+		// the child holding i is the
+		// last one with pren <= i.
+		var pick bnode
+		var pickKey byte
+		picked := false
+		key, ch := node.next(nil)
+		for j := 0; ch != nil && j < 256; j++ {
+			next := *ch
+			if next.pren > i {
+				break
+			}
+			pick, pickKey, picked = next, key, true
+			key, ch = node.next(&key)
+		}
+		if !r.valid() || !picked {
+			return nil, false, false
+		}
+		if t.compact {
+			path = append(path, c...)
+			path = append(path, pickKey)
+		}
+		i -= pick.pren
+		b = pick
+	}
+	if i != 0 {
+		return nil, false, false
+	}
+	return b.leaf.full(path), true, true
+}
@@ -0,0 +1,10 @@
+//go:build !race
+
+package uart
+
+// optimisticOK is set where WithOptimisticReads can
+// work. The readers' version checks rely on amd64
+// keeping loads in order. Under the race detector,
+// which would report their unlocked loads, readers
+// take the read lock instead.
+const optimisticOK = true
@@ -0,0 +1,6 @@
+//go:build !amd64 || race
+
+package uart
+
+// optimisticOK is not set here; see olc_amd64.go.
+const optimisticOK = false
@@ -0,0 +1,164 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// checkVersions panics unless every inner node under
+// b is unlatched, and has its pren up to date, as
+// the readers of WithOptimisticReads need. Where
+// they take the read lock instead, it checks nothing.
+func checkVersions(b *bnode) {
+	if !optimisticOK || b == nil {
+		return
+	}
+	for b := range dfs(b) {
+		if b.isLeaf {
+			continue
+		}
+		if v := atomic.LoadUint64(&b.inner.version); v&1 != 0 {
+			panic(fmt.Sprintf("inner node left latched, version %v", v))
+		}
+		if !b.inner.prenOK {
+			panic("inner node left with a stale pren")
+		}
+	}
+}
+
+func TestOptimisticReads_match_ordinary_tree(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(5, 5))
+	keys := randKeys(rng, 400, 8, "abc/")
+	probes := append(randKeys(rng, 50, 8, "abc/"), Key{}, nil)
+
+	for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}, {WithSlabAllocator()}} {
+		o := NewArtTree(append(opts, WithOptimisticReads())...)
+		plain := NewArtTree()
+		var snap *Tree
+		for i := range 4000 {
+			k := keys[rng.IntN(len(keys))]
+			switch rng.IntN(12) {
+			case 0, 1:
+				o.Remove(k)
+				plain.Remove(k)
+			case 2:
+				end := keys[rng.IntN(len(keys))]
+				o.DeleteRange(k, end)
+				plain.DeleteRange(k, end)
+			case 3:
+				o.LoadOrStore(k, i)
+				plain.LoadOrStore(k, i)
+			case 4:
+				fn := func(old any, exists bool) (any, bool) {
+					return i, !exists
+				}
+				o.Update(k, fn)
+				plain.Update(k, fn)
+			case 5:
+				if snap != nil {
+					snap.Release()
+				}
+				snap = o.Snapshot()
+			default:
+				o.Insert(k, i)
+				plain.Insert(k, i)
+			}
+			if i%500 == 0 {
+				what := fmt.Sprintf("op %v", i)
+				checkVersions(o.root)
+				checkTree(t, what, o, plain, probes)
+			}
+		}
+		checkTree(t, "after ops", o, plain, probes)
+		checkTree(t, "clone", o.Clone(), plain, probes)
+		if !o.Clone().optimistic() && optimisticOK {
+			t.Fatalf("the clone of a tree with optimistic reads has none")
+		}
+	}
+}
+
+// run with and without -race; with it, the
+// readers take the read lock.
+func TestOptimisticReads_concurrent(t *testing.T) {
+	n := 2000
+	key := func(i int) Key {
+		return Key(fmt.Sprintf("%06d", i))
+	}
+	for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}} {
+		// the even keys stay; the writer adds
+		// and removes the odd ones.
+		tree := NewArtTree(append(opts, WithOptimisticReads())...)
+		for i := 0; i < 2*n; i += 2 {
+			tree.Insert(key(i), i)
+		}
+		var readers atomic.Int32
+		var wg sync.WaitGroup
+		for r := range 4 {
+			readers.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer readers.Add(-1)
+				rng := mathrand2.New(mathrand2.NewPCG(uint64(r), 0))
+				for range 5000 {
+					j := rng.IntN(n)
+					v, idx, ok := tree.FindExact(key(2 * j))
+					if !ok || v.(int) != 2*j || idx < j || idx > 2*j {
+						panic(fmt.Sprintf("reader %v: FindExact(%v) -> %v %v %v", r, 2*j, v, idx, ok))
+					}
+					lf, _, ok := tree.Find(GTE, key(2*j+1))
+					if j < n-1 && (!ok || bytes.Compare(lf.Key, key(2*j+2)) > 0 || bytes.Compare(lf.Key, key(2*j+1)) < 0) {
+						panic(fmt.Sprintf("reader %v: Find(GTE, %v) -> %v %v", r, 2*j+1, lf, ok))
+					}
+					sz := tree.Size()
+					if sz < n || sz > 2*n {
+						panic(fmt.Sprintf("reader %v: Size %v", r, sz))
+					}
+					lf, ok = tree.Atfar(j)
+					if !ok || lf.Value.(int) > 2*j {
+						panic(fmt.Sprintf("reader %v: Atfar(%v) -> %v %v", r, j, lf, ok))
+					}
+				}
+			}()
+		}
+		rng := mathrand2.New(mathrand2.NewPCG(9, 9))
+		// the writer keeps going as long as the readers do.
+		for op := 0; readers.Load() > 0; op++ {
+			k := 2*rng.IntN(n) + 1
+			switch rng.IntN(8) {
+			case 0:
+				tree.Remove(key(k))
+			case 1:
+				tree.CompareAndSwap(key(k), k, -k)
+			case 2:
+				// a bulk write.
+				var b WriteBatch
+				b.Put(key(k), k)
+				b.Delete(key(2*rng.IntN(n) + 1))
+				tree.Apply(&b)
+			case 3:
+				if op%100 == 3 {
+					tree.Snapshot().Release()
+				}
+			default:
+				tree.Insert(key(k), k)
+			}
+		}
+		wg.Wait()
+		checkVersions(tree.root)
+		verifySubN(tree.root)
+	}
+}
+
+func TestOptimisticReads_not_with_buckets(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("NewArtTree allowed both optimistic reads and buckets")
+		}
+	}()
+	NewArtTree(WithOptimisticReads(), WithBuckets(8))
+}
@@ -0,0 +1,241 @@
+package uart
+
+import (
+	"bytes"
+	"iter"
+)
+
+// prefixEnd returns the smallest key that is greater
+// than every key starting with prefix, for use as the
+// exclusive end of a range. Trailing 0xff bytes cannot
+// be incremented, so they are dropped first: the end
+// for "a\xff" is "b". If prefix is empty or all 0xff,
+// there is no such key and prefixEnd returns nil,
+// meaning no bound.
+func prefixEnd(prefix Key) Key {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append(Key{}, prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// AscendPrefix iterates in ascending order over
+// the keys that start with prefix. Like Ascend,
+// it yields the *Leaf as the value.
+func AscendPrefix(t *Tree, prefix Key) iter.Seq2[Key, any] {
+	return Ascend(t, append(Key{}, prefix...), prefixEnd(prefix))
+}
+
+// DescendPrefix iterates in descending order over
+// the keys that start with prefix. Like Descend,
+// it yields the *Leaf as the value.
+func DescendPrefix(t *Tree, prefix Key) iter.Seq2[Key, any] {
+	return func(yield func(key Key, value any) bool) {
+		// RevIter's start is inclusive, so begin
+		// at the last key that has the prefix.
+		var start Key
+		if end := prefixEnd(prefix); end != nil {
+			lf, _, found := t.Find(LT, end)
+			if !found {
+				return
+			}
+			start = lf.Key
+		}
+		it := t.RevIter(nil, start)
+		for it.Next() {
+			if !bytes.HasPrefix(it.Key(), prefix) {
+				return
+			}
+			if !yield(it.Key(), it.Leaf()) {
+				return
+			}
+		}
+	}
+}
+
+// CountPrefix returns the number of keys that
+// start with prefix. It takes O(len(prefix)) time:
+// it walks down to the inner node whose path covers
+// the prefix and returns that node's SubN. If the
+// walk ends in a bucket (see WithBuckets), the keys
+// there with the prefix are found by binary search.
+func (t *Tree) CountPrefix(prefix Key) int {
+	if !t.SkipLocking {
+		rl := t.rlock()
+		defer rl.RUnlock()
+	}
+	b := t.root
+	depth := 0
+	for b != nil {
+		if b.isLeaf {
+			// prefix has matched the path to b,
+			// which a compact leaf leaves out.
+			lf := b.leaf
+			if bytes.HasPrefix(lf.Key, prefix[min(int(lf.base), len(prefix)):]) {
+				return 1
+			}
+			return 0
+		}
+		n := b.inner
+		if bk, ok := n.asBucket(); ok {
+			lo, _ := bk.search(prefix)
+			hi := len(bk.leaves)
+			if end := prefixEnd(prefix); end != nil {
+				hi, _ = bk.search(end)
+			}
+			return hi - lo
+		}
+		rest := prefix[depth:]
+		if len(rest) <= len(n.compressed) {
+			if bytes.HasPrefix(n.compressed, rest) {
+				return n.SubN
+			}
+			return 0
+		}
+		if !bytes.HasPrefix(rest, n.compressed) {
+			return 0
+		}
+		pos := depth + len(n.compressed)
+		_, b = n.Node.child(prefix[pos])
+		depth = pos + 1
+	}
+	return 0
+}
+
+// DeletePrefix removes every key that starts
+// with prefix, returning how many were removed.
+// The subtree holding those keys is unlinked in a
+// single step; see DeleteRange.
+func (t *Tree) DeletePrefix(prefix Key) int {
+	return t.DeleteRange(prefix, prefixEnd(prefix))
+}
+
+// LongestPrefixOf returns the leaf whose key is the
+// longest stored prefix of key, as a routing table
+// wants it. That differs from FindLTE(key), which
+// gives "ab" rather than "a" for the key "b" in a
+// tree of "a" and "ab". In a tree with compact leaves,
+// the leaf is a copy holding the whole key.
+//
+// LongestPrefixOf goes down the tree once, along
+// the path of key, looking at each inner node for
+// a stored key that ends there.
+func (t *Tree) LongestPrefixOf(key Key) (lf *Leaf, found bool) {
+	if !t.SkipLocking {
+		rl := t.rlock()
+		defer rl.RUnlock()
+	}
+	t.prefixesOf(key, func(p *Leaf) {
+		lf = p
+	})
+	if lf == nil {
+		return nil, false
+	}
+	return lf.full(key), true
+}
+
+// PrefixesOf iterates, shortest first, over the
+// stored keys that are prefixes of key, key itself
+// included. Like Ascend, it yields the *Leaf as
+// the value.
+//
+// The keys are gathered in one descent of the tree,
+// as for LongestPrefixOf, before the first is yielded,
+// so the loop may write to the tree.
+func (t *Tree) PrefixesOf(key Key) iter.Seq2[Key, any] {
+	return func(yield func(Key, any) bool) {
+		for _, lf := range t.prefixLeaves(key) {
+			if !yield(lf.Key, lf) {
+				return
+			}
+		}
+	}
+}
+
+// prefixLeaves returns the leaves for PrefixesOf,
+// with their whole keys.
+func (t *Tree) prefixLeaves(key Key) (leaves []*Leaf) {
+	if !t.SkipLocking {
+		rl := t.rlock()
+		defer rl.RUnlock()
+	}
+	t.prefixesOf(key, func(lf *Leaf) {
+		leaves = append(leaves, lf.full(key))
+	})
+	return
+}
+
+// prefixesOf calls fn, shortest first, with each
+// leaf, as stored, whose key is a prefix of key.
+// Since the path to such a leaf is the start of
+// key, lf.full(key) gives its whole key.
+func (t *Tree) prefixesOf(key Key, fn func(*Leaf)) {
+	b := t.root
+	// the keys under b start with key[:start],
+	// and its prefix, if an inner node, at depth.
+	start, depth := 0, 0
+	for b != nil {
+		if b.isLeaf {
+			prefixLeaf(b, key, start, fn)
+			return
+		}
+		n := b.inner
+		if _, ok := n.asBucket(); ok {
+			prefixLeaf(b, key, start, fn)
+			return
+		}
+		if !bytes.HasPrefix(key[depth:], n.compressed) {
+			return
+		}
+		pos := depth + len(n.compressed)
+		// a key that ends at pos hangs under keybyte 0,
+		// as Key.At has it. If key goes on with a 0, the
+		// descent itself takes that child next.
+		if pos == len(key) || key[pos] != 0 {
+			if _, ch := n.Node.child(0); ch != nil {
+				prefixLeaf(ch, key, pos, fn)
+			}
+		}
+		if pos == len(key) {
+			return
+		}
+		_, b = n.Node.child(key[pos])
+		start, depth = pos, pos+1
+	}
+}
+
+// prefixLeaf calls fn with the leaves of b, a leaf
+// or a bucket, whose keys are prefixes of key. The
+// keys under b all start with key[:depth].
+func prefixLeaf(b *bnode, key Key, depth int, fn func(*Leaf)) {
+	if b.isLeaf {
+		// a compact leaf leaves out the path,
+		// which key has matched.
+		lf := b.leaf
+		if int(lf.base) <= len(key) && bytes.HasPrefix(key[lf.base:], lf.Key) {
+			fn(lf)
+		}
+		return
+	}
+	bk, ok := b.inner.asBucket()
+	if !ok {
+		// an inner node holds only keys
+		// that go on past its keybyte.
+		return
+	}
+	// the prefixes of key that start with
+	// key[:depth] sort between it and key.
+	i, _ := bk.search(key[:depth])
+	for _, lf := range bk.leaves[i:] {
+		if bytes.Compare(lf.Key, key) > 0 {
+			return
+		}
+		if bytes.HasPrefix(key, lf.Key) {
+			fn(lf)
+		}
+	}
+}
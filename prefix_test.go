@@ -0,0 +1,273 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	for _, c := range []struct{ prefix, end string }{
+		{"", ""},
+		{"a", "b"},
+		{"ab", "ac"},
+		{"a\xff", "b"},
+		{"a\xff\xff", "b"},
+		{"\xff", ""},
+		{"\xff\xff", ""},
+		{"a\x00", "a\x01"},
+	} {
+		end := prefixEnd(Key(c.prefix))
+		if string(end) != c.end || (c.end == "" && end != nil) {
+			t.Fatalf("prefixEnd(%q) = %q, want %q", c.prefix, end, c.end)
+		}
+	}
+}
+
+func TestPrefix_ops_match_brute_force(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(8, 8))
+	keys := mixedKeys(rng)
+	// keys with 0xff bytes, where the end of
+	// the prefix range is easy to get wrong.
+	for _, k := range []string{"a\xff", "a\xff\x01", "a\xff\xff", "a\xff\xffz", "b", "\xff", "\xff\xff\xff"} {
+		keys = append(keys, Key(k))
+	}
+
+	tree := NewArtTree()
+	for i, k := range keys {
+		tree.Insert(k, i)
+	}
+	prefixes := []Key{nil, Key(""), Key("a"), Key("a\xff"), Key("a\xff\xff"), Key("\xff"), Key("zz"), Key("ab"), Key("abac")}
+	for range 200 {
+		k := keys[rng.IntN(len(keys))]
+		prefixes = append(prefixes, k[:rng.IntN(len(k)+1)])
+	}
+	for _, p := range prefixes {
+		var want []Key
+		for key := range Ascend(tree, nil, nil) {
+			if bytes.HasPrefix(key, p) {
+				want = append(want, key)
+			}
+		}
+		if n := tree.CountPrefix(p); n != len(want) {
+			t.Fatalf("CountPrefix(%q) = %v, want %v", p, n, len(want))
+		}
+		var got []Key
+		for key := range AscendPrefix(tree, p) {
+			got = append(got, key)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("AscendPrefix(%q) = %q, want %q", p, got, want)
+		}
+		got = got[:0]
+		for key := range DescendPrefix(tree, p) {
+			got = append(got, key)
+		}
+		for i, j := 0, len(got)-1; i < j; i, j = i+1, j-1 {
+			got[i], got[j] = got[j], got[i]
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("DescendPrefix(%q) = %q, want reverse of %q", p, got, want)
+		}
+	}
+
+	size := tree.Size()
+	for _, p := range []Key{Key("a\xff"), Key("ab"), Key("\xff")} {
+		want := tree.CountPrefix(p)
+		if n := tree.DeletePrefix(p); n != want {
+			t.Fatalf("DeletePrefix(%q) = %v, want %v", p, n, want)
+		}
+		size -= want
+		if tree.CountPrefix(p) != 0 || tree.Size() != size {
+			t.Fatalf("DeletePrefix(%q) left keys behind", p)
+		}
+	}
+	verifySubN(tree.root)
+	verifyLeafIndexAt(tree)
+}
+
+// Random ranges checked against a sorted slice, now
+// that iteration seeks to its start and stops at its end.
+func TestIter_seek_random_ranges(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(9, 9))
+	keys := mixedKeys(rng)
+	tree := NewArtTree()
+	for i, k := range keys {
+		tree.Insert(k, i)
+	}
+	pick := func() Key {
+		if rng.IntN(10) == 0 {
+			return nil
+		}
+		k := keys[rng.IntN(len(keys))]
+		switch rng.IntN(3) {
+		case 0:
+			return k[:rng.IntN(len(k)+1)]
+		case 1:
+			return append(append(Key{}, k...), byte(rng.IntN(256)))
+		}
+		return k
+	}
+	for range 300 {
+		a, b := pick(), pick()
+		var want []Key
+		for _, k := range keys {
+			if (len(a) == 0 || bytes.Compare(k, a) >= 0) && (len(b) == 0 || bytes.Compare(k, b) < 0) {
+				want = append(want, k)
+			}
+		}
+		var got []Key
+		for key := range Ascend(tree, a, b) {
+			got = append(got, key)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("Ascend(%q, %q) = %v keys, want %v", a, b, len(got), len(want))
+		}
+
+		// Descend covers (a, b].
+		want = want[:0]
+		for j := len(keys) - 1; j >= 0; j-- {
+			k := keys[j]
+			if (len(b) == 0 || bytes.Compare(k, b) <= 0) && (len(a) == 0 || bytes.Compare(k, a) > 0) {
+				want = append(want, k)
+			}
+		}
+		got = got[:0]
+		for key := range Descend(tree, a, b) {
+			got = append(got, key)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("Descend(%q, %q) = %v keys, want %v", a, b, len(got), len(want))
+		}
+	}
+}
+
+func TestLongestPrefixOf(t *testing.T) {
+	routes := []string{"", "/", "/api", "/api/v1", "/api/v1/users", "/apiary", "/static/"}
+	for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}, {WithBuckets(4)}} {
+		tree := NewArtTree(opts...)
+		for _, r := range routes[1:] {
+			tree.Insert(Key(r), r)
+		}
+		for _, c := range []struct{ key, want, all string }{
+			{"/api/v1/users/42", "/api/v1/users", "[/ /api /api/v1 /api/v1/users]"},
+			{"/api/v2", "/api", "[/ /api]"},
+			{"/apia", "/api", "[/ /api]"},
+			{"/apiary", "/apiary", "[/ /api /apiary]"},
+			{"/api\x00", "/api", "[/ /api]"},
+			{"/b", "/", "[/]"},
+			{"/static", "/", "[/]"},
+			{"b", "", "[]"},
+			{"", "", "[]"},
+		} {
+			lf, found := tree.LongestPrefixOf(Key(c.key))
+			if found != (c.want != "") || found && (string(lf.Key) != c.want || lf.Value != c.want) {
+				t.Fatalf("%v: LongestPrefixOf(%q) = %v, %v; want %q", opts, c.key, lf, found, c.want)
+			}
+			var all []string
+			for key, lf := range tree.PrefixesOf(Key(c.key)) {
+				if lf.(*Leaf).Value != string(key) {
+					t.Fatalf("%q has value %v", key, lf.(*Leaf).Value)
+				}
+				all = append(all, string(key))
+			}
+			if fmt.Sprint(all) != c.all {
+				t.Fatalf("%v: PrefixesOf(%q) = %q, want %v", opts, c.key, all, c.all)
+			}
+		}
+
+		// FindLTE stops at a sibling that sorts
+		// between the prefix and the key.
+		if v, _, _ := tree.FindLTE(Key("/api/v2")); v != "/api/v1/users" {
+			t.Fatalf("FindLTE = %v", v)
+		}
+
+		// the empty key is a prefix of every key.
+		tree.Insert(Key(""), "")
+		if lf, found := tree.LongestPrefixOf(Key("b")); !found || len(lf.Key) != 0 {
+			t.Fatalf("%v: LongestPrefixOf(b) = %v, %v", opts, lf, found)
+		}
+		var all []string
+		for key := range tree.PrefixesOf(Key("/api/v1")) {
+			all = append(all, string(key))
+			// the keys are found before the first
+			// is yielded, so removing them is safe.
+			tree.Remove(key)
+		}
+		if fmt.Sprint(all) != "[ / /api /api/v1]" || tree.Size() != 3 {
+			t.Fatalf("%v: PrefixesOf with removes = %q, size %v", opts, all, tree.Size())
+		}
+	}
+	if _, found := NewArtTree().LongestPrefixOf(Key("a")); found {
+		t.Fatalf("found a prefix in the empty tree")
+	}
+}
+
+func TestPrefixesOf_match_brute_force(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(25, 25))
+	keys := sortedKeys(loadTestFile("assets/words.txt"))
+	var queries []Key
+	for range 2000 {
+		k := keys[rng.IntN(len(keys))]
+		switch rng.IntN(4) {
+		case 0:
+			k = k[:rng.IntN(len(k)+1)]
+		case 1:
+			k = append(append(Key{}, k...), byte(rng.IntN(256)))
+		case 2:
+			k = append(append(Key{}, k...), "ness"...)
+		}
+		queries = append(queries, k)
+	}
+	for _, opts := range [][]TreeOption{nil, {WithCompactLeaves()}, {WithBuckets(16)}} {
+		tree := NewArtTree(opts...)
+		for _, k := range keys {
+			tree.Insert(k, nil)
+		}
+		for _, q := range queries {
+			var want []Key
+			for i := range len(q) + 1 {
+				if _, _, found := tree.FindExact(q[:i]); found {
+					want = append(want, q[:i])
+				}
+			}
+			var got []Key
+			for key := range tree.PrefixesOf(q) {
+				got = append(got, key)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("%v: PrefixesOf(%q) = %q, want %q", opts, q, got, want)
+			}
+			lf, found := tree.LongestPrefixOf(q)
+			if found != (len(want) > 0) || found && !bytes.Equal(lf.Key, want[len(want)-1]) {
+				t.Fatalf("%v: LongestPrefixOf(%q) = %v, %v; want %q", opts, q, lf, found, want)
+			}
+		}
+	}
+}
+
+// LongestPrefixOf, against trying each
+// prefix of the key with FindExact.
+func BenchmarkLongestPrefixOf(b *testing.B) {
+	words := loadTestFile("assets/words.txt")
+	tree := NewArtTree()
+	for _, w := range words {
+		tree.Insert(w, nil)
+	}
+	q := append(append(Key{}, words[len(words)/3]...), "nesses"...)
+	b.Run("LongestPrefixOf", func(b *testing.B) {
+		for range b.N {
+			tree.LongestPrefixOf(q)
+		}
+	})
+	b.Run("FindExact", func(b *testing.B) {
+		for range b.N {
+			for i := len(q); i >= 0; i-- {
+				if _, _, found := tree.FindExact(q[:i]); found {
+					break
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package uart
+
+import (
+	"bytes"
+)
+
+// CountRange returns the number of keys k with
+// start <= k < end, the same keys that Iter(start, end)
+// would visit. A nil start or end leaves that side
+// unbounded. It takes O(log N) time, using two
+// index lookups rather than visiting the keys.
+func (t *Tree) CountRange(start, end Key) int {
+	if !t.SkipLocking {
+		rl := t.rlock()
+		defer rl.RUnlock()
+	}
+	if t.root == nil {
+		return 0
+	}
+	lo, hi := 0, int(t.size)
+	if start != nil {
+		lo = t.rank(start)
+	}
+	if end != nil {
+		hi = t.rank(end)
+	}
+	if hi < lo {
+		return 0
+	}
+	return hi - lo
+}
+
+// rank returns the number of keys < key.
+func (t *Tree) rank(key Key) int {
+	_, idx, found := t.find_unlocked(GTE, key)
+	if !found {
+		return int(t.size)
+	}
+	return idx
+}
+
+// DeleteRange removes the keys k with start <= k < end,
+// the same keys that Iter(start, end) would visit,
+// and returns how many were removed. A nil start or
+// end leaves that side unbounded, so DeleteRange(nil, nil)
+// empties the tree.
+//
+// Subtrees that lie entirely inside the range are
+// unlinked whole, without visiting their leaves.
+// Only the inner nodes on the paths to the two ends
+// of the range are rebuilt, each once, with its
+// SubN and the pren of its children computed once.
+// The tree version is bumped once, so iterators and
+// the At cache see a single change.
+func (t *Tree) DeleteRange(start, end Key) (n int) {
+	t.wlock()
+	defer t.wunlock()
+
+	n = t.deleteRange_unlocked(start, end)
+	if n > 0 {
+		t.treeVersion++
+	}
+	return n
+}
+
+// deleteRange_unlocked is DeleteRange without the locking
+// and the treeVersion bump; see insert_unlocked.
+func (t *Tree) deleteRange_unlocked(start, end Key) (n int) {
+	if t.root == nil {
+		return 0
+	}
+	if start != nil && end != nil && bytes.Compare(start, end) >= 0 {
+		return 0
+	}
+	var root *bnode
+	root, n = rangeDel(t.root, 0, nil, start, end, t.gen)
+	if n == 0 {
+		return 0
+	}
+	if t.watched() {
+		t.noteRange(t.root, t.size, start, end, KeyRemoved)
+	}
+	t.root = root
+	t.size -= int64(n)
+	return n
+}
+
+// inRange reports whether start <= key < end,
+// where a nil start or end is unbounded.
+func inRange(key, start, end Key) bool {
+	return (start == nil || bytes.Compare(key, start) >= 0) &&
+		(end == nil || bytes.Compare(key, end) < 0)
+}
+
+// rangeDel removes the keys in [start, end) from the
+// subtree b, whose keys all share their first depth bytes,
+// which path holds if b has compact leaves. It returns the bnode to put in place of b, which is
+// b itself if nothing was removed and nil if nothing
+// is left, along with the number of keys removed.
+//
+// Existing nodes are never modified, since in
+// copy-on-write mode a snapshot may share them: the
+// inner nodes that lose some of their keys are
+// replaced by new ones, of generation gen.
+func rangeDel(b *bnode, depth int, path, start, end Key, gen uint64) (*bnode, int) {
+	if b.isLeaf {
+		if inRange(b.leaf.fullKey(path), start, end) {
+			return nil, 1
+		}
+		return b, 0
+	}
+	n := b.inner
+	if bk, ok := n.asBucket(); ok {
+		return bucketDel(b, bk, start, end, gen)
+	}
+	first := n.edgeKey(path, false)
+	last := n.edgeKey(path, true)
+	if (start != nil && bytes.Compare(last, start) < 0) ||
+		(end != nil && bytes.Compare(first, end) >= 0) {
+		// no overlap.
+		return b, 0
+	}
+	if inRange(first, start, end) && inRange(last, start, end) {
+		// the whole subtree goes.
+		return nil, n.SubN
+	}
+
+	pos := depth + len(n.compressed)
+	// the children's paths all share this
+	// buffer, which none of them keeps.
+	path = append(path, n.compressed...)
+	var keys []byte
+	var kids []*bnode
+	removed := 0
+	for kb, ch := range n.kids() {
+		nc, k := rangeDel(ch, pos+1, append(path, kb), start, end, gen)
+		removed += k
+		if nc != nil {
+			keys = append(keys, kb)
+			kids = append(kids, nc)
+		}
+	}
+	if removed == 0 {
+		return b, 0
+	}
+	r := rebuilt(n, keys, kids, gen)
+	if r != nil && r.isLeaf && r.leaf.base > 0 {
+		// a compact leaf moves up into n's place.
+		lf := r.leaf
+		r.leaf = lf.rebased(lf.fullKey(append(path, keys[0])), max(depth-1, 0))
+	}
+	return r, removed
+}
+
+// bucketDel is rangeDel for b, whose inner node holds
+// bk. The leaves that stay go into a new bucket, of
+// generation gen, or a lone leaf.
+func bucketDel(b *bnode, bk *bucket, start, end Key, gen uint64) (*bnode, int) {
+	lo, hi := 0, len(bk.leaves)
+	if start != nil {
+		lo, _ = bk.search(start)
+	}
+	if end != nil {
+		hi, _ = bk.search(end)
+	}
+	if hi <= lo {
+		return b, 0
+	}
+	kept := make([]*Leaf, 0, len(bk.leaves)-(hi-lo))
+	kept = append(kept, bk.leaves[:lo]...)
+	kept = append(kept, bk.leaves[hi:]...)
+	switch len(kept) {
+	case 0:
+		return nil, hi - lo
+	case 1:
+		return bnodeLeaf(kept[0]), hi - lo
+	}
+	return bnodeInner(newBucket(nil, kept, b.inner.keybyte, gen)), hi - lo
+}
+
+// rebuilt returns the bnode to put in place of n once
+// n's children have become kids, with keys their keybytes:
+// nil if there are none, and n's only child, with n's
+// prefix prepended, if there is just one. Otherwise
+// it is a new inner node of generation gen.
+func rebuilt(n *inner, keys []byte, kids []*bnode, gen uint64) *bnode {
+	switch len(kids) {
+	case 0:
+		return nil
+	case 1:
+		// like del, collapse n into its only child,
+		// which takes over n's prefix.
+		ch := kids[0]
+		if ch.isLeaf {
+			return bnodeLeaf(ch.leaf)
+		}
+		// copy ch's inner rather than change it; the
+		// copy keeps ch's gen, because it still shares
+		// ch's Node, which the next write must copy
+		// if anyone else can see it.
+		c := *ch.inner
+		c.addPrefixBefore(n, keys[0])
+		c.keybyte = n.keybyte
+		return bnodeInner(&c)
+	}
+	return innerCopying(n.compressed, n.keybyte, keys, kids, gen)
+}
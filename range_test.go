@@ -0,0 +1,156 @@
+package uart
+
+import (
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"testing"
+)
+
+func TestDeleteRange_matches_Remove(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(7, 7))
+	keys := mixedKeys(rng)
+	pick := func() Key {
+		switch rng.IntN(8) {
+		case 0:
+			return nil
+		case 1:
+			// a key not in the tree
+			return append(append(Key{}, keys[rng.IntN(len(keys))]...), 0x7f)
+		}
+		return keys[rng.IntN(len(keys))]
+	}
+
+	for trial := range 200 {
+		tree := NewArtTree()
+		ref := make(map[string]int)
+		for i, k := range keys {
+			if rng.IntN(3) > 0 {
+				tree.Insert(k, i)
+				ref[string(k)] = i
+			}
+		}
+		var snap *Tree
+		if trial%2 == 1 {
+			snap = tree.Snapshot()
+		}
+		for op := range 3 {
+			start, end := pick(), pick()
+			want := 0
+			for k := range ref {
+				if inRange(Key(k), start, end) {
+					want++
+				}
+			}
+			if got := tree.CountRange(start, end); got != want {
+				t.Fatalf("trial %v: CountRange(%q, %q) = %v, want %v", trial, start, end, got, want)
+			}
+			got := tree.DeleteRange(start, end)
+			if got != want {
+				t.Fatalf("trial %v op %v: DeleteRange(%q, %q) = %v, want %v", trial, op, start, end, got, want)
+			}
+			for k := range ref {
+				if inRange(Key(k), start, end) {
+					delete(ref, k)
+				}
+			}
+			if tree.Size() != len(ref) {
+				t.Fatalf("Size %v, want %v", tree.Size(), len(ref))
+			}
+			if tree.root != nil {
+				verifySubN(tree.root)
+			}
+			verifyLeafIndexAt(tree)
+			for key, lf := range Ascend(tree, nil, nil) {
+				if v, ok := ref[string(key)]; !ok || v != lf.(*Leaf).Value {
+					t.Fatalf("unexpected key %q left", key)
+				}
+			}
+			// and writes still work afterwards.
+			for k, i := range ref {
+				if rng.IntN(10) == 0 {
+					tree.Remove(Key(k))
+					tree.Insert(Key(k), i)
+				}
+			}
+		}
+		if snap != nil {
+			n := 0
+			for range Ascend(snap, nil, nil) {
+				n++
+			}
+			if n != snap.Size() {
+				t.Fatalf("snapshot changed: iterated %v of %v", n, snap.Size())
+			}
+		}
+	}
+}
+
+func TestDeleteRange_whole_and_empty(t *testing.T) {
+	tree := NewArtTree()
+	if tree.DeleteRange(nil, nil) != 0 || tree.CountRange(nil, nil) != 0 {
+		t.Fatalf("empty tree")
+	}
+	for i := range 1000 {
+		tree.Insert(Key(fmt.Sprintf("k%04d", i)), i)
+	}
+	if n := tree.DeleteRange(Key("k0500"), Key("k0100")); n != 0 {
+		t.Fatalf("start > end removed %v", n)
+	}
+	if n := tree.CountRange(Key("k0100"), Key("k0200")); n != 100 {
+		t.Fatalf("CountRange = %v, want 100", n)
+	}
+	v0 := tree.treeVersion
+	if n := tree.DeleteRange(Key("k0100"), Key("k0200")); n != 100 {
+		t.Fatalf("DeleteRange = %v, want 100", n)
+	}
+	if tree.treeVersion != v0+1 {
+		t.Fatalf("treeVersion bumped %v times", tree.treeVersion-v0)
+	}
+	if n := tree.DeleteRange(nil, nil); n != 900 || !tree.IsEmpty() {
+		t.Fatalf("DeleteRange(nil, nil) = %v; size now %v", n, tree.Size())
+	}
+}
+
+func BenchmarkDeleteRange(b *testing.B) {
+	full := NewArtTree()
+	for i := range 100_000 {
+		full.Insert(Key(fmt.Sprintf("tenant%02d/%06d", i%10, i)), i)
+	}
+	for _, structural := range []bool{false, true} {
+		b.Run(fmt.Sprintf("structural_%v", structural), func(b *testing.B) {
+			for range b.N {
+				b.StopTimer()
+				tree := full.Clone()
+				b.StartTimer()
+				if structural {
+					tree.DeleteRange(Key("tenant03/"), Key("tenant07/"))
+					continue
+				}
+				var doomed []Key
+				for key := range Ascend(tree, Key("tenant03/"), Key("tenant07/")) {
+					doomed = append(doomed, key)
+				}
+				for _, key := range doomed {
+					tree.Remove(key)
+				}
+			}
+		})
+	}
+}
+
+// The root has the compressed prefix "ab", and
+// the query key differs from it in the first byte.
+func TestFindGTE_before_compressed_root(t *testing.T) {
+	tree := NewArtTree()
+	tree.Insert(Key("abc"), 1)
+	tree.Insert(Key("abd"), 2)
+	for _, smod := range []SearchModifier{GTE, GT} {
+		lf, idx, found := tree.Find(smod, Key("\x01"))
+		if !found || idx != 0 || string(lf.Key) != "abc" {
+			t.Fatalf("%v: got %v, %v, %v", smod, lf, idx, found)
+		}
+	}
+	if n := tree.CountRange(Key("\x01"), Key("abd")); n != 1 {
+		t.Fatalf("CountRange = %v, want 1", n)
+	}
+}
@@ -0,0 +1,559 @@
+package uart
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// The snapshot format written by Tree.WriteTo and
+// read by Tree.ReadFrom is:
+//
+//	magic   [8]byte  "uartsnap"
+//	version uint32   big-endian; currently 1.
+//	frames  ...
+//
+// Each frame is
+//
+//	length  uint32   big-endian payload length
+//	crc     uint32   big-endian CRC-32C (Castagnoli) of the payload
+//	payload [length]byte
+//
+// The first frame holds the uvarint number of keys in
+// the tree. The following frames carry the nodes of the
+// tree in pre-order (a parent before its children, children
+// in key order); a node may straddle a frame boundary.
+// A frame of length zero ends the snapshot.
+//
+// A node is encoded as its kind byte (0 for a leaf,
+// 1-4 for node4/node16/node48/node256), its keybyte, and then
+//
+//	leaf:  uvarint key length, key, uvarint value length, value
+//	inner: uvarint compressed length, compressed, uvarint child count
+//
+// with an inner's children following it. Because
+// the compressed prefixes are stored as-is, loading
+// rebuilds the tree shape directly; SubN and pren
+// are recomputed on the way back up. Values are
+// encoded by the Tree.ValueCodec. A leaf always
+// carries its whole key, so a tree with compact
+// leaves (see WithCompactLeaves) writes the same
+// snapshot as an ordinary one, and either kind
+// of tree can read it. Likewise, a bucket (see
+// WithBuckets) is written as the subtree of
+// ordinary nodes that it stands for.
+
+const snapMagic = "uartsnap"
+const snapVersion = 1
+
+// frames are flushed once they reach this size.
+const snapFrameSize = 64 << 10
+
+// keys, prefixes, and values larger than this
+// are rejected as corrupt when reading.
+const snapMaxBytes = 1 << 30
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrBadSnapshot is returned (possibly wrapped) by
+// ReadFrom when the input is not a valid snapshot.
+var ErrBadSnapshot = errors.New("uart: bad snapshot")
+
+// ValueCodec converts Leaf.Value to and from bytes
+// for Tree.WriteTo and Tree.ReadFrom.
+type ValueCodec interface {
+	// AppendValue appends the encoding of v to dst
+	// and returns the extended slice.
+	AppendValue(dst []byte, v any) ([]byte, error)
+
+	// DecodeValue decodes a value encoded by AppendValue.
+	// It must not retain b.
+	DecodeValue(b []byte) (any, error)
+}
+
+// BytesCodec is the ValueCodec used when Tree.ValueCodec
+// is nil. It handles nil, []byte, and string values.
+type BytesCodec struct{}
+
+const (
+	bytesCodecNil    = 0
+	bytesCodecBytes  = 1
+	bytesCodecString = 2
+)
+
+func (BytesCodec) AppendValue(dst []byte, v any) ([]byte, error) {
+	switch x := v.(type) {
+	case nil:
+		return append(dst, bytesCodecNil), nil
+	case []byte:
+		dst = append(dst, bytesCodecBytes)
+		return append(dst, x...), nil
+	case string:
+		dst = append(dst, bytesCodecString)
+		return append(dst, x...), nil
+	}
+	return dst, fmt.Errorf("uart.BytesCodec cannot encode value of type %T; set Tree.ValueCodec", v)
+}
+
+func (BytesCodec) DecodeValue(b []byte) (any, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w: empty value", ErrBadSnapshot)
+	}
+	switch b[0] {
+	case bytesCodecNil:
+		return nil, nil
+	case bytesCodecBytes:
+		return append([]byte{}, b[1:]...), nil
+	case bytesCodecString:
+		return string(b[1:]), nil
+	}
+	return nil, fmt.Errorf("%w: unknown BytesCodec tag %v", ErrBadSnapshot, b[0])
+}
+
+func (t *Tree) codec() ValueCodec {
+	if t.ValueCodec == nil {
+		return BytesCodec{}
+	}
+	return t.ValueCodec
+}
+
+// snapWriter accumulates node encodings into
+// checksummed frames.
+type snapWriter struct {
+	w     io.Writer
+	n     int64
+	frame []byte
+	val   []byte // scratch space for encoding values
+	hdr   [8]byte
+	err   error
+}
+
+func (s *snapWriter) flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	binary.BigEndian.PutUint32(s.hdr[:4], uint32(len(s.frame)))
+	binary.BigEndian.PutUint32(s.hdr[4:], crc32.Checksum(s.frame, crc32c))
+	var k int
+	k, s.err = s.w.Write(s.hdr[:])
+	s.n += int64(k)
+	if s.err == nil && len(s.frame) > 0 {
+		k, s.err = s.w.Write(s.frame)
+		s.n += int64(k)
+	}
+	s.frame = s.frame[:0]
+	return s.err
+}
+
+// node writes the subtree b. path holds the key
+// bytes before b, which a compact leaf leaves out.
+func (s *snapWriter) node(b *bnode, keybyte byte, path []byte, codec ValueCodec) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !b.isLeaf {
+		if bk, ok := b.inner.asBucket(); ok {
+			return s.bucket(bk, keybyte, path, codec)
+		}
+	}
+	if b.isLeaf {
+		lf := b.leaf
+		s.frame = append(s.frame, byte(_Leafy), keybyte)
+		s.frame = binary.AppendUvarint(s.frame, uint64(int(lf.base)+len(lf.Key)))
+		s.frame = append(s.frame, path[:lf.base]...)
+		s.frame = append(s.frame, lf.Key...)
+
+		var err error
+		s.val, err = codec.AppendValue(s.val[:0], lf.Value)
+		if err != nil {
+			s.err = err
+			return err
+		}
+		s.frame = binary.AppendUvarint(s.frame, uint64(len(s.val)))
+		s.frame = append(s.frame, s.val...)
+	} else {
+		n := b.inner
+		s.frame = append(s.frame, byte(n.kind()), keybyte)
+		s.frame = binary.AppendUvarint(s.frame, uint64(len(n.compressed)))
+		s.frame = append(s.frame, n.compressed...)
+		s.frame = binary.AppendUvarint(s.frame, uint64(n.Node.nchild()))
+	}
+	if len(s.frame) >= snapFrameSize {
+		if s.flush() != nil {
+			return s.err
+		}
+	}
+	if !b.isLeaf {
+		// the children's paths share one buffer.
+		path = append(path, b.inner.compressed...)
+		for k, ch := range b.inner.kids() {
+			if s.node(ch, k, append(path, k), codec) != nil {
+				return s.err
+			}
+		}
+	}
+	return s.err
+}
+
+// bucket writes bk, under path, as the subtree of
+// ordinary nodes that would hold its keys. It is
+// built from copies of the leaves, whose keybytes
+// buildSorted sets.
+func (s *snapWriter) bucket(bk *bucket, keybyte byte, path []byte, codec ValueCodec) error {
+	leaves := make([]*Leaf, len(bk.leaves))
+	for i, lf := range bk.leaves {
+		leaves[i] = &Leaf{Key: lf.Key, Value: lf.Value}
+	}
+	sub, ok := buildSorted(leaves, len(path), keybyte)
+	if !ok {
+		s.err = errSnapClash
+		return s.err
+	}
+	return s.node(sub, keybyte, path, codec)
+}
+
+// errSnapClash is returned by WriteTo for a bucket
+// holding a key that ends where another goes on with
+// a 0 byte, which ordinary nodes cannot hold.
+var errSnapClash = errors.New("uart: WriteTo: a key ends where another goes on with a 0 byte")
+
+// WriteTo writes a snapshot of the tree to w,
+// in the format described at the top of serial.go.
+// Values are encoded with t.ValueCodec, or
+// BytesCodec if that is nil.
+//
+// WriteTo holds the read lock for the duration
+// of the write (unless SkipLocking is set).
+// It implements io.WriterTo.
+func (t *Tree) WriteTo(w io.Writer) (n int64, err error) {
+	codec := t.codec()
+	if !t.SkipLocking {
+		rl := t.rlock()
+		defer rl.RUnlock()
+	}
+	s := &snapWriter{w: w}
+
+	var hdr [12]byte
+	copy(hdr[:8], snapMagic)
+	binary.BigEndian.PutUint32(hdr[8:], snapVersion)
+	k, err := w.Write(hdr[:])
+	s.n += int64(k)
+	if err != nil {
+		return s.n, err
+	}
+
+	s.frame = binary.AppendUvarint(s.frame, uint64(t.size))
+	if s.flush() != nil {
+		return s.n, s.err
+	}
+	if t.root != nil {
+		if s.node(t.root, 0, nil, codec) != nil {
+			return s.n, s.err
+		}
+		if len(s.frame) > 0 && s.flush() != nil {
+			return s.n, s.err
+		}
+	}
+	// the empty end frame.
+	s.flush()
+	return s.n, s.err
+}
+
+// snapReader hands out the payload bytes of
+// successive frames, verifying each checksum.
+type snapReader struct {
+	r     io.Reader
+	n     int64
+	frame []byte
+	pos   int
+	done  bool // saw the end frame
+	hdr   [8]byte
+
+	codec ValueCodec
+
+	// for checking that leaves arrive in order.
+	prevKey Key
+	leaves  int
+}
+
+func (s *snapReader) nextFrame() error {
+	if s.done {
+		return fmt.Errorf("%w: data past the end frame", ErrBadSnapshot)
+	}
+	k, err := io.ReadFull(s.r, s.hdr[:])
+	s.n += int64(k)
+	if err != nil {
+		return snapTruncated(err)
+	}
+	length := binary.BigEndian.Uint32(s.hdr[:4])
+	sum := binary.BigEndian.Uint32(s.hdr[4:])
+	if length > 2*snapFrameSize+snapMaxBytes {
+		return fmt.Errorf("%w: frame length %v too large", ErrBadSnapshot, length)
+	}
+	if cap(s.frame) < int(length) {
+		s.frame = make([]byte, length)
+	}
+	s.frame = s.frame[:length]
+	k, err = io.ReadFull(s.r, s.frame)
+	s.n += int64(k)
+	if err != nil {
+		return snapTruncated(err)
+	}
+	if crc32.Checksum(s.frame, crc32c) != sum {
+		return fmt.Errorf("%w: frame checksum mismatch at offset %v", ErrBadSnapshot, s.n-int64(length))
+	}
+	s.pos = 0
+	if length == 0 {
+		s.done = true
+	}
+	return nil
+}
+
+func snapTruncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: truncated", ErrBadSnapshot)
+	}
+	return err
+}
+
+func (s *snapReader) ReadByte() (byte, error) {
+	for s.pos >= len(s.frame) {
+		if s.done {
+			return 0, fmt.Errorf("%w: unexpected end frame", ErrBadSnapshot)
+		}
+		if err := s.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	c := s.frame[s.pos]
+	s.pos++
+	return c, nil
+}
+
+func (s *snapReader) uvarint() (int, error) {
+	u, err := binary.ReadUvarint(s)
+	if err != nil {
+		if errors.Is(err, ErrBadSnapshot) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	if u > snapMaxBytes {
+		return 0, fmt.Errorf("%w: length %v too large", ErrBadSnapshot, u)
+	}
+	return int(u), nil
+}
+
+// bytes reads a uvarint length and then that many
+// bytes, returned in a freshly allocated slice.
+func (s *snapReader) bytes() ([]byte, error) {
+	n, err := s.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	for i := 0; i < n; {
+		if s.pos >= len(s.frame) {
+			if s.done {
+				return nil, fmt.Errorf("%w: unexpected end frame", ErrBadSnapshot)
+			}
+			if err := s.nextFrame(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		k := copy(b[i:], s.frame[s.pos:])
+		s.pos += k
+		i += k
+	}
+	return b, nil
+}
+
+var snapMaxChildren = [...]int{_Node4: 4, _Node16: 16, _Node48: 48, _Node256: 256}
+
+// snapMaxDepth bounds how deeply inner nodes may
+// nest in a snapshot, so that a corrupt one cannot
+// make node recurse without limit. Each inner has
+// at least two children, so only a tree of more
+// than 64K keys, sharing prefixes at least that
+// long, is too deep to read back.
+const snapMaxDepth = 1 << 16
+
+// node reads a subtree. Unless it is the root,
+// at depth 0, its keys all begin with path and
+// then the keybyte read here; a corrupt snapshot
+// could say otherwise. depth counts the inner
+// nodes above the subtree.
+func (s *snapReader) node(path []byte, depth int) (b *bnode, keybyte byte, err error) {
+	kb, err := s.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	keybyte, err = s.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	k := kind(kb)
+	if k == _Leafy {
+		key, err := s.bytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		if depth > 0 && !(bytes.HasPrefix(key, path) && Key(key).At(len(path)) == keybyte) {
+			return nil, 0, fmt.Errorf("%w: key %q is not under its path %q and keybyte %v", ErrBadSnapshot, key, path, keybyte)
+		}
+		if s.leaves > 0 && bytes.Compare(s.prevKey, key) >= 0 {
+			return nil, 0, fmt.Errorf("%w: key %q out of order after %q", ErrBadSnapshot, key, s.prevKey)
+		}
+		s.prevKey = key
+		s.leaves++
+
+		enc, err := s.bytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		val, err := s.codec.DecodeValue(enc)
+		if err != nil {
+			return nil, 0, err
+		}
+		lf := NewLeaf(Key(key), val, nil)
+		lf.keybyte = keybyte
+		return bnodeLeaf(lf), keybyte, nil
+	}
+	if k > _Node256 {
+		return nil, 0, fmt.Errorf("%w: unknown node kind %v", ErrBadSnapshot, kb)
+	}
+	if depth >= snapMaxDepth {
+		return nil, 0, fmt.Errorf("%w: inner nodes nested more than %v deep", ErrBadSnapshot, snapMaxDepth)
+	}
+	compressed, err := s.bytes()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(compressed) == 0 {
+		compressed = nil
+	}
+	nchild, err := s.uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	if nchild < 2 || nchild > snapMaxChildren[k] {
+		return nil, 0, fmt.Errorf("%w: %v with %v children", ErrBadSnapshot, k, nchild)
+	}
+	keys := make([]byte, nchild)
+	children := make([]*bnode, nchild)
+	// the children's paths share one buffer,
+	// as in snapWriter.node; none is kept.
+	if depth > 0 {
+		path = append(path, keybyte)
+	}
+	path = append(path, compressed...)
+	for i := range nchild {
+		children[i], keys[i], err = s.node(path, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		if i > 0 && k != _Node4 && k != _Node16 && keys[i] <= keys[i-1] {
+			return nil, 0, fmt.Errorf("%w: %v child keys out of order", ErrBadSnapshot, k)
+		}
+	}
+	b = innerFrom(compressed, keybyte, keys, children)
+	if b.inner.kind() != k {
+		// keep the kind that was written, to
+		// reproduce the tree exactly.
+		b.inner.Node = growTo(b.inner.Node, k)
+	}
+	return b, keybyte, nil
+}
+
+// growTo grows n until it is of kind k.
+func growTo(n inode, k kind) inode {
+	for n.kind() < k {
+		n = n.grow(nil)
+	}
+	return n
+}
+
+// ReadFrom replaces the contents of the tree with a
+// snapshot previously written by WriteTo. The tree
+// shape, including the compressed prefixes, is
+// rebuilt directly from the snapshot rather than
+// by inserting each key. Values are decoded with
+// t.ValueCodec, or BytesCodec if that is nil.
+//
+// The tree is only modified if the whole snapshot
+// was read and verified successfully. Besides
+// the checksums, every key is checked against
+// its place in the tree, so a snapshot that was
+// crafted, or corrupted before it was written,
+// cannot build a tree that misfiles keys. Errors
+// caused by invalid input wrap ErrBadSnapshot.
+//
+// ReadFrom implements io.ReaderFrom.
+func (t *Tree) ReadFrom(r io.Reader) (n int64, err error) {
+	t.panicIfReadOnly()
+	s := &snapReader{r: r, codec: t.codec()}
+
+	var hdr [12]byte
+	k, err := io.ReadFull(r, hdr[:])
+	s.n += int64(k)
+	if err != nil {
+		return s.n, snapTruncated(err)
+	}
+	if string(hdr[:8]) != snapMagic {
+		return s.n, fmt.Errorf("%w: bad magic", ErrBadSnapshot)
+	}
+	if v := binary.BigEndian.Uint32(hdr[8:]); v != snapVersion {
+		return s.n, fmt.Errorf("%w: unsupported version %v", ErrBadSnapshot, v)
+	}
+	if err = s.nextFrame(); err != nil {
+		return s.n, err
+	}
+	size, err := s.uvarint()
+	if err != nil {
+		return s.n, err
+	}
+
+	var root *bnode
+	if size > 0 {
+		root, _, err = s.node(nil, 0)
+		if err != nil {
+			return s.n, err
+		}
+	}
+	if s.leaves != size {
+		return s.n, fmt.Errorf("%w: header says %v keys, found %v", ErrBadSnapshot, size, s.leaves)
+	}
+	if s.pos != len(s.frame) {
+		return s.n, fmt.Errorf("%w: trailing bytes after the root", ErrBadSnapshot)
+	}
+	for !s.done {
+		if err = s.nextFrame(); err != nil {
+			return s.n, err
+		}
+		if !s.done {
+			return s.n, fmt.Errorf("%w: trailing frame after the root", ErrBadSnapshot)
+		}
+	}
+
+	t.wlock()
+	defer t.wunlock()
+	if t.watched() {
+		t.noteRange(t.root, t.size, nil, nil, KeyRemoved)
+		t.noteRange(root, int64(size), nil, nil, KeyInserted)
+	}
+	if t.compact {
+		compactLeaves(root, 0)
+	}
+	if t.buckets > 0 {
+		bucketLeaves(root, t.buckets)
+	}
+	t.root = root
+	t.size = int64(size)
+	t.atCache = nil
+	t.treeVersion++
+	return s.n, nil
+}
@@ -0,0 +1,236 @@
+package uart
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+func TestWriteToReadFrom_words(t *testing.T) {
+	tree := NewArtTree()
+	words := loadTestFile("assets/words.txt")
+	for _, w := range words {
+		tree.Insert(w, string(w))
+	}
+
+	var buf bytes.Buffer
+	nw, err := tree.WriteTo(&buf)
+	panicOn(err)
+	if nw != int64(buf.Len()) {
+		t.Fatalf("WriteTo reported %v bytes, wrote %v", nw, buf.Len())
+	}
+
+	tree2 := NewArtTree()
+	nr, err := tree2.ReadFrom(bytes.NewReader(buf.Bytes()))
+	panicOn(err)
+	if nr != nw {
+		t.Fatalf("ReadFrom read %v bytes, want %v", nr, nw)
+	}
+	if tree2.Size() != tree.Size() {
+		t.Fatalf("size %v, want %v", tree2.Size(), tree.Size())
+	}
+	cs, saved := tree.CompressedStats()
+	cs2, saved2 := tree2.CompressedStats()
+	if saved != saved2 || fmt.Sprint(cs) != fmt.Sprint(cs2) {
+		t.Fatalf("compressed prefixes differ after reload")
+	}
+	for i := 0; i < tree.Size(); i += 97 {
+		lf, ok := tree2.Atfar(i)
+		lf0, _ := tree.Atfar(i)
+		if !ok || !bytes.Equal(lf.Key, lf0.Key) || lf.Value.(string) != string(lf0.Key) {
+			t.Fatalf("Atfar(%v) mismatch after reload: %v vs %v", i, lf, lf0)
+		}
+		_, idx, found := tree2.FindExact(lf0.Key)
+		if !found || idx != i {
+			t.Fatalf("FindExact(%q) idx %v found %v, want %v", lf0.Key, idx, found, i)
+		}
+	}
+
+	// the loaded tree must behave normally under writes.
+	tree2.Insert(Key("zzzzzz-new"), "new")
+	tree2.Remove(words[0])
+	if tree2.Size() != tree.Size() {
+		t.Fatalf("size after insert+remove %v, want %v", tree2.Size(), tree.Size())
+	}
+}
+
+func TestWriteToReadFrom_empty_and_single(t *testing.T) {
+	for _, n := range []int{0, 1, 2} {
+		tree := NewArtTree()
+		for i := range n {
+			tree.Insert(Key(fmt.Sprintf("k%v", i)), []byte("v"))
+		}
+		var buf bytes.Buffer
+		_, err := tree.WriteTo(&buf)
+		panicOn(err)
+		tree2 := NewArtTree()
+		tree2.Insert(Key("to-be-replaced"), nil)
+		_, err = tree2.ReadFrom(&buf)
+		panicOn(err)
+		if tree2.Size() != n {
+			t.Fatalf("n=%v: got size %v", n, tree2.Size())
+		}
+		if n > 0 {
+			v, _, ok := tree2.FindExact(Key("k0"))
+			if !ok || string(v.([]byte)) != "v" {
+				t.Fatalf("n=%v: k0 -> %v, %v", n, v, ok)
+			}
+		}
+	}
+}
+
+type uint64Codec struct{}
+
+func (uint64Codec) AppendValue(dst []byte, v any) ([]byte, error) {
+	return binary.AppendUvarint(dst, v.(uint64)), nil
+}
+
+func (uint64Codec) DecodeValue(b []byte) (any, error) {
+	u, k := binary.Uvarint(b)
+	if k <= 0 {
+		return nil, fmt.Errorf("bad uvarint")
+	}
+	return u, nil
+}
+
+func TestWriteToReadFrom_codec_and_corruption(t *testing.T) {
+	tree := NewArtTree()
+	tree.ValueCodec = uint64Codec{}
+	for i := range 5000 {
+		tree.Insert(Key(fmt.Sprintf("/user/%05d/profile", i)), uint64(i))
+	}
+	var buf bytes.Buffer
+	_, err := tree.WriteTo(&buf)
+	panicOn(err)
+	snap := buf.Bytes()
+
+	// the default codec refuses values it does not know.
+	tree.ValueCodec = nil
+	_, err = tree.WriteTo(&bytes.Buffer{})
+	if err == nil {
+		t.Fatalf("expected BytesCodec to reject uint64 values")
+	}
+
+	tree2 := NewArtTree()
+	tree2.ValueCodec = uint64Codec{}
+	_, err = tree2.ReadFrom(bytes.NewReader(snap))
+	panicOn(err)
+	v, _, ok := tree2.FindExact(Key("/user/04321/profile"))
+	if !ok || v.(uint64) != 4321 {
+		t.Fatalf("got %v, %v", v, ok)
+	}
+
+	// flip a byte in the middle: the checksum must catch it,
+	// and the tree must be left alone.
+	bad := append([]byte{}, snap...)
+	bad[len(bad)/2] ^= 0x40
+	_, err = tree2.ReadFrom(bytes.NewReader(bad))
+	if !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expected ErrBadSnapshot, got %v", err)
+	}
+	if tree2.Size() != 5000 {
+		t.Fatalf("failed ReadFrom modified the tree")
+	}
+
+	// truncation
+	_, err = tree2.ReadFrom(bytes.NewReader(snap[:len(snap)-3]))
+	if !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expected ErrBadSnapshot on truncation, got %v", err)
+	}
+
+	// unknown version
+	bad = append([]byte{}, snap...)
+	bad[11] = 99
+	_, err = tree2.ReadFrom(bytes.NewReader(bad))
+	if !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expected ErrBadSnapshot on bad version, got %v", err)
+	}
+}
+
+// craftSnapshot returns a snapshot, with valid
+// checksums, of size keys whose nodes are encoded
+// in payload.
+func craftSnapshot(size int, payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(snapMagic)
+	buf.Write(binary.BigEndian.AppendUint32(nil, snapVersion))
+	frame := func(p []byte) {
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(p))))
+		buf.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(p, crc32c)))
+		buf.Write(p)
+	}
+	frame(binary.AppendUvarint(nil, uint64(size)))
+	frame(payload)
+	frame(nil)
+	return buf.Bytes()
+}
+
+func craftLeaf(dst []byte, keybyte byte, key string) []byte {
+	dst = append(dst, byte(_Leafy), keybyte)
+	dst = binary.AppendUvarint(dst, uint64(len(key)))
+	dst = append(dst, key...)
+	return append(dst, 1, bytesCodecNil)
+}
+
+func craftInner(dst []byte, keybyte byte, compressed string, nchild int) []byte {
+	dst = append(dst, byte(_Node4), keybyte)
+	dst = binary.AppendUvarint(dst, uint64(len(compressed)))
+	dst = append(dst, compressed...)
+	return binary.AppendUvarint(dst, uint64(nchild))
+}
+
+func TestReadFrom_rejects_crafted_shapes(t *testing.T) {
+	// a well formed tree: "apple" and "apply" under "appl".
+	var p []byte
+	p = craftInner(p, 0, "appl", 2)
+	p = craftLeaf(p, 'e', "apple")
+	p = craftLeaf(p, 'y', "apply")
+	tree := NewArtTree()
+	_, err := tree.ReadFrom(bytes.NewReader(craftSnapshot(2, p)))
+	panicOn(err)
+	if _, _, ok := tree.FindExact(Key("apply")); !ok {
+		t.Fatalf("apply not found")
+	}
+
+	// the same keys, in order, but not where their path says.
+	p = craftInner(nil, 0, "appl", 2)
+	p = craftLeaf(p, 'e', "apple")
+	p = craftLeaf(p, 'y', "banana")
+	_, err = NewArtTree().ReadFrom(bytes.NewReader(craftSnapshot(2, p)))
+	if !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expected ErrBadSnapshot for a key off its path, got %v", err)
+	}
+	p = craftInner(nil, 0, "appl", 2)
+	p = craftLeaf(p, 'e', "apple")
+	p = craftLeaf(p, 'z', "apply")
+	_, err = NewArtTree().ReadFrom(bytes.NewReader(craftSnapshot(2, p)))
+	if !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expected ErrBadSnapshot for a key under the wrong keybyte, got %v", err)
+	}
+
+	// a long chain of single-child inner nodes.
+	p = nil
+	for range snapMaxDepth {
+		p = craftInner(p, 'a', "", 1)
+	}
+	p = craftLeaf(p, 'a', "a")
+	_, err = NewArtTree().ReadFrom(bytes.NewReader(craftSnapshot(1, p)))
+	if !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expected ErrBadSnapshot for a single-child inner node, got %v", err)
+	}
+
+	// inner nodes nested too deep to have been
+	// written by WriteTo, each going on first to
+	// the next, so that no leaf is checked on the way.
+	p = nil
+	for range 2 * snapMaxDepth {
+		p = craftInner(p, 'a', "", 2)
+	}
+	_, err = NewArtTree().ReadFrom(bytes.NewReader(craftSnapshot(1, p)))
+	if !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expected ErrBadSnapshot for deep nesting, got %v", err)
+	}
+}
@@ -0,0 +1,328 @@
+package uart
+
+import (
+	"bytes"
+)
+
+// Union returns a new tree holding every key that is
+// in a, in b, or in both. For a key in both, the value
+// is resolve(key, va, vb), where va and vb are the values
+// in a and b. A nil resolve keeps the value from a.
+//
+// The keys and values of a and b are not changed, but
+// since the new tree shares their nodes, both are left
+// in copy-on-write mode for good, as SplitAt leaves t:
+// their next writes copy the nodes they share, and
+// WithSlabAllocator stops recycling their freed nodes.
+//
+// The two tries are walked in
+// lockstep, and only the nodes where both of them have
+// keys are rebuilt. Every subtree that only one of
+// them has is shared with the new tree rather than
+// copied, copy-on-write as for Snapshot, so the
+// cost depends on how much a and b overlap, and not
+// on their size. The Leaf of a key in only one of
+// a or b is shared too.
+//
+// The new tree has the same locking mode
+// and ValueCodec as a.
+func Union(a, b *Tree, resolve func(key Key, va, vb any) any) *Tree {
+	ra, na := a.frozenRoot()
+	rb, nb := b.frozenRoot()
+	r := newCowTree()
+	m := &merger{
+		gen: r.gen,
+		both: func(x, y *Leaf) (*Leaf, error) {
+			return resolved(x, y, resolve), nil
+		},
+	}
+	var err error
+	switch {
+	case a.leafwise() || b.leafwise():
+		// see Join.
+		err = errMergeClash
+	case ra == nil:
+		r.root = rb
+	case rb == nil:
+		r.root = ra
+	default:
+		r.root, err = m.merge(ra, rb, 0, 0)
+	}
+	if err == errMergeClash {
+		// see Join.
+		r, _ = mergeLeaves(ra, na, rb, nb, m.both, true, true)
+	} else if r.root != nil {
+		r.size = int64(r.root.subn())
+	}
+	r.finishFrom(a)
+	return r
+}
+
+// Intersect returns a new tree holding the keys that
+// are in both a and b. Their values are given by resolve,
+// as for Union; a nil resolve keeps the values from a.
+//
+// As for Union, a and b keep their keys but are left
+// in copy-on-write mode, and the tries
+// are walked in lockstep: a subtree that only one of
+// them has is skipped without being visited, and a
+// subtree of a that is kept whole is shared with
+// the new tree rather than copied.
+//
+// The new tree has the same locking mode
+// and ValueCodec as a.
+func Intersect(a, b *Tree, resolve func(key Key, va, vb any) any) *Tree {
+	ra, na := a.frozenRoot()
+	rb, nb := b.frozenRoot()
+	if a.leafwise() || b.leafwise() {
+		// see Join.
+		r, _ := mergeLeaves(ra, na, rb, nb, func(x, y *Leaf) (*Leaf, error) {
+			return resolved(x, y, resolve), nil
+		}, false, false)
+		return r.finishFrom(a)
+	}
+	r := newCowTree()
+	if ra != nil && rb != nil {
+		s := &setop{gen: r.gen, resolve: resolve}
+		r.root = s.intersect(ra, rb, 0)
+		r.ownRoot()
+	}
+	r.adoptOptions(a)
+	return r
+}
+
+// Difference returns a new tree holding the keys
+// of a that are not in b, with their values from a.
+//
+// As for Union, a and b keep their keys but are left
+// in copy-on-write mode, and the tries
+// are walked in lockstep: a subtree of a that b
+// has no keys in is shared with the new tree
+// without being visited.
+//
+// The new tree has the same locking mode
+// and ValueCodec as a.
+func Difference(a, b *Tree) *Tree {
+	ra, na := a.frozenRoot()
+	rb, nb := b.frozenRoot()
+	if a.leafwise() || b.leafwise() {
+		// see Join.
+		r, _ := mergeLeaves(ra, na, rb, nb, func(x, y *Leaf) (*Leaf, error) {
+			return nil, nil
+		}, true, false)
+		return r.finishFrom(a)
+	}
+	r := newCowTree()
+	r.root = ra
+	if ra != nil && rb != nil {
+		s := &setop{gen: r.gen}
+		r.root, _ = s.diff(ra, rb, 0)
+	}
+	r.ownRoot()
+	r.adoptOptions(a)
+	return r
+}
+
+// ownRoot gives r its own copy of its root bnode,
+// which may be shared with another tree, and sets
+// r's size to match.
+func (r *Tree) ownRoot() {
+	if r.root == nil {
+		r.size = 0
+		return
+	}
+	b := *r.root
+	r.root = &b
+	r.size = int64(b.subn())
+}
+
+// resolved returns the leaf to keep for a key that
+// has leaf x in one tree and leaf y in the other.
+func resolved(x, y *Leaf, resolve func(key Key, va, vb any) any) *Leaf {
+	if resolve == nil {
+		return x
+	}
+	return &Leaf{Key: x.Key, Value: resolve(x.Key, x.Value, y.Value)}
+}
+
+// lookup returns the leaf holding key in the
+// subtree b, whose keys all share their first
+// depth bytes, or nil if there is none.
+func lookup(b *bnode, key Key, depth int) *Leaf {
+	for !b.isLeaf {
+		n := b.inner
+		if depth > len(key) || !bytes.HasPrefix(key[depth:], n.compressed) {
+			return nil
+		}
+		pos := depth + len(n.compressed)
+		_, b = n.Node.child(key.At(pos))
+		if b == nil {
+			return nil
+		}
+		depth = pos + 1
+	}
+	if bytes.Equal(b.leaf.Key, key) {
+		return b.leaf
+	}
+	return nil
+}
+
+// setop holds the state of an Intersect or
+// a Difference. The nodes it builds belong
+// to generation gen.
+type setop struct {
+	gen     uint64
+	resolve func(key Key, va, vb any) any
+}
+
+// matching returns a function giving, for each child
+// keybyte of x, the subtree of y that holds the keys
+// that could be under that child, or nil if none can.
+// x and y hold keys that share their first depth bytes.
+// If y's compressed prefix is longer than x's, y is
+// replaced first by its one child that can share keys
+// with x, as many times as needed; if that leaves a
+// leaf, it is returned as leaf, and kid is nil.
+// ok is false if no key can be in both.
+func matching(x *inner, y *bnode) (kid func(kb byte) *bnode, leaf *bnode, ok bool) {
+	cx := x.compressed
+	for !y.isLeaf {
+		ny := y.inner
+		cy := ny.compressed
+		common := comparePrefix(cx, cy, 0)
+		switch {
+		case common < len(cx) && common < len(cy):
+			return nil, nil, false
+
+		case common < len(cx):
+			// only y's child at cx[common] can hold
+			// keys of x; it stands in for y, taking
+			// over y's prefix.
+			_, ch := ny.Node.child(cx[common])
+			if ch == nil {
+				return nil, nil, false
+			}
+			if !ch.isLeaf {
+				c := *ch.inner
+				c.addPrefixBefore(ny, cx[common])
+				ch = bnodeInner(&c)
+			}
+			y = ch
+			continue
+
+		case common < len(cy):
+			// all of y is under x's child at cy[common].
+			c := *ny
+			c.compressed = cy[common+1:]
+			sub := bnodeInner(&c)
+			want := cy[common]
+			return func(kb byte) *bnode {
+				if kb == want {
+					return sub
+				}
+				return nil
+			}, nil, true
+		}
+		return func(kb byte) *bnode {
+			_, ch := ny.Node.child(kb)
+			return ch
+		}, nil, true
+	}
+	return nil, y, true
+}
+
+// intersect returns the bnode holding the keys of
+// x that are also in y, or nil if there are none.
+// The keys of x and y share their first depth bytes.
+func (s *setop) intersect(x, y *bnode, depth int) *bnode {
+	switch {
+	case x.isLeaf:
+		ly := lookup(y, x.leaf.Key, depth)
+		if ly == nil {
+			return nil
+		}
+		if s.resolve == nil {
+			return x
+		}
+		return bnodeLeaf(resolved(x.leaf, ly, s.resolve))
+	case y.isLeaf:
+		lx := lookup(x, y.leaf.Key, depth)
+		if lx == nil {
+			return nil
+		}
+		return bnodeLeaf(resolved(lx, y.leaf, s.resolve))
+	}
+	nx := x.inner
+	kid, leaf, ok := matching(nx, y)
+	if !ok {
+		return nil
+	}
+	if leaf != nil {
+		return s.intersect(x, leaf, depth)
+	}
+	pos := depth + len(nx.compressed)
+	var keys []byte
+	var kids []*bnode
+	kept := 0
+	for kb, ch := range nx.kids() {
+		yc := kid(kb)
+		if yc == nil {
+			continue
+		}
+		if r := s.intersect(ch, yc, pos+1); r != nil {
+			keys = append(keys, kb)
+			kids = append(kids, r)
+			kept += r.subn()
+		}
+	}
+	if kept == nx.SubN && s.resolve == nil {
+		return x
+	}
+	return rebuilt(nx, keys, kids, s.gen)
+}
+
+// diff returns the bnode holding the keys of x that
+// are not in y, which is x itself if there are no
+// such keys, along with the number of keys removed.
+// The keys of x and y share their first depth bytes.
+func (s *setop) diff(x, y *bnode, depth int) (*bnode, int) {
+	switch {
+	case x.isLeaf:
+		if lookup(y, x.leaf.Key, depth) != nil {
+			return nil, 1
+		}
+		return x, 0
+	case y.isLeaf:
+		// remove just y's key: no key sorts between
+		// a key and the key with a 0 byte appended.
+		k := y.leaf.Key
+		return rangeDel(x, depth, nil, k, append(k[:len(k):len(k)], 0), s.gen)
+	}
+	nx := x.inner
+	kid, leaf, ok := matching(nx, y)
+	if !ok {
+		return x, 0
+	}
+	if leaf != nil {
+		return s.diff(x, leaf, depth)
+	}
+	pos := depth + len(nx.compressed)
+	var keys []byte
+	var kids []*bnode
+	removed := 0
+	for kb, ch := range nx.kids() {
+		if yc := kid(kb); yc != nil {
+			var k int
+			ch, k = s.diff(ch, yc, pos+1)
+			removed += k
+		}
+		if ch != nil {
+			keys = append(keys, kb)
+			kids = append(kids, ch)
+		}
+	}
+	if removed == 0 {
+		return x, 0
+	}
+	return rebuilt(nx, keys, kids, s.gen), removed
+}
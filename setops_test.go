@@ -0,0 +1,133 @@
+package uart
+
+import (
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"testing"
+)
+
+func TestSetOps_match_maps(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(10, 10))
+	words := mixedKeys(rng)
+	sum := func(key Key, va, vb any) any {
+		return va.(int) + vb.(int)
+	}
+
+	for trial := range 300 {
+		// small alphabets make for deep prefix
+		// sharing between the two trees.
+		var keys []Key
+		if trial%3 == 0 {
+			keys = words
+		} else {
+			for range 200 {
+				k := make(Key, rng.IntN(6))
+				for j := range k {
+					k[j] = byte(1 + rng.IntN(1+trial%4))
+				}
+				keys = append(keys, k)
+			}
+		}
+		a, b := NewArtTree(), NewArtTree()
+		aref := make(map[string]int)
+		bref := make(map[string]int)
+		for i, k := range keys {
+			switch rng.IntN(4) {
+			case 0:
+				a.Insert(k, i)
+				aref[string(k)] = i
+			case 1:
+				b.Insert(k, i*1000)
+				bref[string(k)] = i * 1000
+			case 2:
+				a.Insert(k, i)
+				aref[string(k)] = i
+				b.Insert(k, i*1000)
+				bref[string(k)] = i * 1000
+			}
+		}
+
+		union := make(map[string]int)
+		inter := make(map[string]int)
+		interA := make(map[string]int)
+		diff := make(map[string]int)
+		for k, v := range aref {
+			union[k] = v
+			if vb, ok := bref[k]; ok {
+				inter[k] = v + vb
+				interA[k] = v
+			} else {
+				diff[k] = v
+			}
+		}
+		for k, v := range bref {
+			if va, ok := aref[k]; ok {
+				union[k] = va + v
+			} else {
+				union[k] = v
+			}
+		}
+
+		checkTree(t, "Union", Union(a, b, sum), treeOf(union), nil)
+		checkTree(t, "Intersect", Intersect(a, b, sum), treeOf(inter), nil)
+		checkTree(t, "Intersect with nil resolve", Intersect(a, b, nil), treeOf(interA), nil)
+		d := Difference(a, b)
+		checkTree(t, "Difference", d, treeOf(diff), nil)
+
+		// the inputs are unchanged, even after
+		// writes to the results, which share nodes
+		// with them.
+		for k := range aref {
+			if rng.IntN(2) == 0 {
+				d.Remove(Key(k))
+			}
+		}
+		checkTree(t, "a", a, treeOf(aref), nil)
+		checkTree(t, "b", b, treeOf(bref), nil)
+	}
+}
+
+func TestSetOps_empty(t *testing.T) {
+	a, empty := NewArtTree(), NewArtTree()
+	ref := make(map[string]int)
+	for i := range 100 {
+		a.Insert(Key(fmt.Sprint(i)), i)
+		ref[fmt.Sprint(i)] = i
+	}
+	checkTree(t, "Union(a, empty)", Union(a, empty, nil), treeOf(ref), nil)
+	checkTree(t, "Union(empty, a)", Union(empty, a, nil), treeOf(ref), nil)
+	checkTree(t, "Intersect(a, empty)", Intersect(a, empty, nil), NewArtTree(), nil)
+	checkTree(t, "Difference(a, empty)", Difference(a, empty), treeOf(ref), nil)
+	checkTree(t, "Difference(empty, a)", Difference(empty, a), NewArtTree(), nil)
+	checkTree(t, "Difference(a, a)", Difference(a, a), NewArtTree(), nil)
+	checkTree(t, "Intersect(a, a)", Intersect(a, a, nil), treeOf(ref), nil)
+}
+
+// Two trees holding the two halves of words.txt,
+// interleaved: every other word goes to each.
+func BenchmarkUnion(b *testing.B) {
+	words := loadTestFile("assets/words.txt")
+	x, y := NewArtTree(), NewArtTree()
+	for i, w := range words {
+		if i%2 == 0 {
+			x.Insert(w, i)
+		} else {
+			y.Insert(w, i)
+		}
+	}
+	b.Run("Union", func(b *testing.B) {
+		for range b.N {
+			if Union(x, y, nil).Size() != len(words) {
+				b.Fatal("lost keys")
+			}
+		}
+	})
+	b.Run("Insert", func(b *testing.B) {
+		for range b.N {
+			u := x.Clone()
+			for key, lf := range Ascend(y, nil, nil) {
+				u.Insert(key, lf.(*Leaf).Value)
+			}
+		}
+	})
+}
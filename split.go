@@ -0,0 +1,448 @@
+package uart
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// SplitAt returns two new trees: left holds the keys
+// of t that are < key, and right holds those >= key.
+// A nil key is the same as an empty one, so then
+// left is empty and right has every key.
+//
+// The keys of t are not changed, and the split
+// does not copy t. Subtrees that lie wholly on one
+// side of key are shared between t and the new trees,
+// just as t shares them with a Snapshot: only the
+// inner nodes on the path from the root to key are
+// rebuilt. From then on, t, left, and right are in
+// copy-on-write mode, so that a write to any one of
+// them copies the shared nodes it touches rather
+// than changing the others. The leaves are shared
+// too; see Snapshot.
+//
+// This mode costs t something. t stays in it for
+// good. The first write to each node t shares
+// copies that node. With WithSlabAllocator, t no
+// longer recycles the nodes that its writes free.
+// To leave t as it was, split a Clone of t,
+// at the price of copying it.
+//
+// The new trees have the same locking mode
+// and ValueCodec as t.
+func (t *Tree) SplitAt(key Key) (left, right *Tree) {
+	if key == nil {
+		key = Key{}
+	}
+	root, size := t.frozenRoot()
+	return t.splitRoot(root, size, key)
+}
+
+// SplitIndex is like SplitAt, but splits t by
+// position: left gets the first i keys of t,
+// in sorted order, and right gets the rest.
+// An i <= 0 leaves left empty, and an
+// i >= t.Size() leaves right empty.
+func (t *Tree) SplitIndex(i int) (left, right *Tree) {
+	root, size := t.frozenRoot()
+	var key Key
+	switch {
+	case i <= 0:
+		key = Key{}
+	case i < int(size):
+		lf, _ := root.atFull(i)
+		key = lf.Key
+	}
+	// otherwise key stays nil: everything goes left.
+	return t.splitRoot(root, size, key)
+}
+
+// frozenRoot returns a copy of t's root bnode, and
+// t's size, after freezing t so that its nodes can
+// be shared. See freeze. t is pinned, and never
+// thawed by Release, since the nodes may be shared
+// for as long as the trees made from them live.
+func (t *Tree) frozenRoot() (root *bnode, size int64) {
+	if t.readOnly {
+		// a snapshot is frozen already, but
+		// its nodes are its source's too.
+		if src := t.src; src != nil {
+			if !src.SkipLocking {
+				src.lock()
+				defer src.unlock()
+			}
+			src.pinned = true
+		}
+	} else {
+		if !t.SkipLocking {
+			t.lock()
+			defer t.unlock()
+		}
+		if t.root != nil {
+			t.root.subTreeRedoPren()
+		}
+		t.freeze()
+		t.pinned = true
+	}
+	if t.root == nil {
+		return nil, 0
+	}
+	r := *t.root
+	return &r, t.size
+}
+
+// splitRoot does the work of SplitAt, on the frozen
+// root of t. A nil key puts every key on the left.
+func (t *Tree) splitRoot(root *bnode, size int64, key Key) (left, right *Tree) {
+	left = newCowTree()
+	right = newCowTree()
+	switch {
+	case root == nil:
+	case key == nil:
+		left.root, left.size = root, size
+	default:
+		var n int
+		left.root, n = rangeDel(root, 0, nil, key, nil, left.gen)
+		left.size = size - int64(n)
+		right.root, n = rangeDel(root, 0, nil, nil, key, right.gen)
+		right.size = size - int64(n)
+	}
+	for _, r := range []*Tree{left, right} {
+		if r.root != nil {
+			// never share a root bnode between trees.
+			b := *r.root
+			r.root = &b
+		}
+		r.adoptOptions(t)
+	}
+	return
+}
+
+// newCowTree returns an empty tree in copy-on-write
+// mode, ready to be given nodes shared with other trees.
+func newCowTree() *Tree {
+	r := NewArtTree()
+	r.cow = true
+	r.pinned = true
+	r.gen = cowGen.Add(1)
+	return r
+}
+
+// Join returns a new tree holding all the keys of
+// both a and b, which must have no key in common.
+// If they do, Join returns a nil tree and an error
+// naming the shared key.
+//
+// The keys of a and b are not changed. As with SplitAt,
+// the new tree shares nodes with a and b rather than
+// copying them, so a and b are left in copy-on-write
+// mode, with the costs that SplitAt describes for t.
+// The two tries are merged along the paths where
+// both have keys, rebuilding just the node4/16/48/256
+// nodes there, and every subtree that only one of
+// them has is linked in whole. So when the keys of
+// a all sort before those of b, as after a SplitAt,
+// only the nodes along the boundary between them are
+// rebuilt, and Join takes time proportional to the
+// depth of the trees rather than to their size.
+//
+// The new tree has the same locking mode
+// and ValueCodec as a.
+func Join(a, b *Tree) (*Tree, error) {
+	ra, na := a.frozenRoot()
+	rb, nb := b.frozenRoot()
+	r := newCowTree()
+	m := &merger{
+		gen: r.gen,
+		both: func(x, y *Leaf) (*Leaf, error) {
+			return nil, fmt.Errorf("uart: Join: key %q is in both trees", x.Key)
+		},
+	}
+	var err error
+	switch {
+	case a.leafwise() || b.leafwise():
+		err = errMergeClash
+	case ra == nil:
+		r.root = rb
+	case rb == nil:
+		r.root = ra
+	default:
+		r.root, err = m.merge(ra, rb, 0, 0)
+	}
+	r.size = na + nb
+	if err == errMergeClash {
+		// fall back to building the tree anew,
+		// which does whatever Insert would.
+		r, err = mergeLeaves(ra, na, rb, nb, m.both, true, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.finishFrom(a)
+	return r, nil
+}
+
+// leafwise reports whether merges of t are done by
+// mergeLeaves, rather than node by node. A compact leaf
+// holds only the end of its key, for the place it is
+// in, so the leaves cannot be moved about by merging;
+// and merger does not look into buckets.
+func (t *Tree) leafwise() bool {
+	return t.compact || t.buckets > 0
+}
+
+// mergeLeaves does what merger.merge does, by
+// merging the sorted leaves of a and b into a new
+// tree, with both called for the keys they share.
+// A nil leaf from both drops the key. A key in
+// only a is kept if keepA is set, and a key in
+// only b if keepB is. The leaves of the new tree
+// hold their whole keys.
+func mergeLeaves(a *bnode, na int64, b *bnode, nb int64, both func(x, y *Leaf) (*Leaf, error), keepA, keepB bool) (*Tree, error) {
+	leaves := make([]*Leaf, 0, na+nb)
+	next := func(b *bnode, n int64) func() (*Leaf, bool) {
+		// the leaves may be compact, or in buckets;
+		// findByRank and the iterator cope with both.
+		it := (&Tree{root: b, size: n, SkipLocking: true, compact: true}).Iter(nil, nil)
+		return func() (*Leaf, bool) {
+			if !it.Next() {
+				return nil, false
+			}
+			return it.Leaf(), true
+		}
+	}
+	nexta, nextb := next(a, na), next(b, nb)
+	x, xok := nexta()
+	y, yok := nextb()
+	for xok || yok {
+		var lf *Leaf
+		if xok && yok && bytes.Equal(x.Key, y.Key) {
+			var err error
+			lf, err = both(x, y)
+			if err != nil {
+				return nil, err
+			}
+			x, xok = nexta()
+			y, yok = nextb()
+		} else if !yok || (xok && bytes.Compare(x.Key, y.Key) < 0) {
+			if keepA {
+				lf = x
+			}
+			x, xok = nexta()
+		} else {
+			if keepB {
+				lf = y
+			}
+			y, yok = nextb()
+		}
+		if lf == nil {
+			continue
+		}
+		// buildFromLeaves sets keybyte, so the
+		// leaves of a and b cannot be shared.
+		leaves = append(leaves, &Leaf{Key: lf.Key, Value: lf.Value})
+	}
+	r := buildFromLeaves(leaves)
+	r.cow = true
+	r.pinned = true
+	r.gen = cowGen.Add(1)
+	return r, nil
+}
+
+// errMergeClash is returned by merger.merge when a key
+// that ends at some depth meets a key that goes on
+// with a 0 byte there. Both would need the 0 keybyte
+// of the same node, so the tries cannot be merged
+// node by node.
+var errMergeClash = errors.New("uart: merge clash on a 0 keybyte")
+
+// merger merges two tries without changing either.
+// The nodes it builds belong to generation gen.
+// both is called for a key that is in both tries,
+// with the leaf from each, and returns the leaf to keep.
+type merger struct {
+	gen  uint64
+	both func(x, y *Leaf) (*Leaf, error)
+}
+
+// merge returns a bnode holding the keys of both x
+// and y, whose keys all share their first depth bytes.
+// keybyte is the byte that leads to the result
+// from its parent. x and y are never modified;
+// subtrees that only one of them has are shared.
+func (m *merger) merge(x, y *bnode, depth int, keybyte byte) (*bnode, error) {
+	switch {
+	case x.isLeaf && y.isLeaf:
+		return m.leaves(x.leaf, y.leaf, depth, keybyte)
+	case x.isLeaf:
+		return m.under(y.inner, depth, keybyte, x, nil, true)
+	case y.isLeaf:
+		return m.under(x.inner, depth, keybyte, y, nil, false)
+	}
+	return m.inners(x.inner, y.inner, depth, keybyte)
+}
+
+// leaves merges two leaves.
+func (m *merger) leaves(x, y *Leaf, depth int, keybyte byte) (*bnode, error) {
+	if bytes.Equal(x.Key, y.Key) {
+		lf, err := m.both(x, y)
+		if err != nil {
+			return nil, err
+		}
+		return bnodeLeaf(lf), nil
+	}
+	if len(x.Key) < depth || len(y.Key) < depth {
+		return nil, errMergeClash
+	}
+	common := comparePrefix(x.Key, y.Key, depth)
+	pos := depth + common
+	kx, ky := x.Key.At(pos), y.Key.At(pos)
+	if kx == ky {
+		return nil, errMergeClash
+	}
+	keys := []byte{kx, ky}
+	kids := []*bnode{bnodeLeaf(x), bnodeLeaf(y)}
+	if ky < kx {
+		keys[0], keys[1] = ky, kx
+		kids[0], kids[1] = kids[1], kids[0]
+	}
+	var compressed []byte
+	if common > 0 {
+		compressed = append([]byte{}, x.Key[depth:pos]...)
+	}
+	return innerCopying(compressed, keybyte, keys, kids, m.gen), nil
+}
+
+// inners merges two inner nodes.
+func (m *merger) inners(x, y *inner, depth int, keybyte byte) (*bnode, error) {
+	cx, cy := x.compressed, y.compressed
+	common := comparePrefix(cx, cy, 0)
+	switch {
+	case common < len(cx) && common < len(cy):
+		// the paths part inside the compressed
+		// prefixes: a new node4 takes both.
+		a, b := *x, *y
+		a.compressed, a.keybyte = cx[common+1:], cx[common]
+		b.compressed, b.keybyte = cy[common+1:], cy[common]
+		keys := []byte{a.keybyte, b.keybyte}
+		kids := []*bnode{bnodeInner(&a), bnodeInner(&b)}
+		if b.keybyte < a.keybyte {
+			keys[0], keys[1] = keys[1], keys[0]
+			kids[0], kids[1] = kids[1], kids[0]
+		}
+		compressed := append([]byte{}, cx[:common]...)
+		return innerCopying(compressed, keybyte, keys, kids, m.gen), nil
+
+	case common < len(cy):
+		// y goes below x.
+		b := *y
+		b.compressed, b.keybyte = cy[common+1:], cy[common]
+		return m.under(x, depth, keybyte, bnodeInner(&b), cy, false)
+
+	case common < len(cx):
+		// x goes below y.
+		a := *x
+		a.compressed, a.keybyte = cx[common+1:], cx[common]
+		return m.under(y, depth, keybyte, bnodeInner(&a), cx, true)
+	}
+
+	// same prefix: merge the children.
+	pos := depth + len(cx)
+	var keys []byte
+	var kids []*bnode
+	var ky []byte
+	var ny []*bnode
+	for kb, ch := range y.kids() {
+		ky = append(ky, kb)
+		ny = append(ny, ch)
+	}
+	j := 0
+	for kb, ch := range x.kids() {
+		for j < len(ky) && ky[j] < kb {
+			keys = append(keys, ky[j])
+			kids = append(kids, ny[j])
+			j++
+		}
+		if j < len(ky) && ky[j] == kb {
+			mc, err := m.merge(ch, ny[j], pos+1, kb)
+			if err != nil {
+				return nil, err
+			}
+			ch = mc
+			j++
+		}
+		keys = append(keys, kb)
+		kids = append(kids, ch)
+	}
+	keys = append(keys, ky[j:]...)
+	kids = append(kids, ny[j:]...)
+	return innerCopying(cx, keybyte, keys, kids, m.gen), nil
+}
+
+// under merges the subtree c into the inner node n.
+// The keys in c continue with path from depth, where
+// n's keys continue with n.compressed; for a leaf c,
+// path is taken from its key. cFirst is true
+// if c comes from the first trie given to merge, and
+// n from the second.
+func (m *merger) under(n *inner, depth int, keybyte byte, c *bnode, path []byte, cFirst bool) (*bnode, error) {
+	if c.isLeaf && len(c.leaf.Key) < depth {
+		return nil, errMergeClash
+	}
+	if c.isLeaf {
+		path = c.leaf.Key[depth:]
+	}
+	mis := comparePrefix(n.compressed, path, 0)
+	if mis < len(n.compressed) {
+		// a leaf that leaves n's compressed
+		// prefix: split it off like insert does.
+		ckb := Key(path).At(mis)
+		nkb := n.compressed[mis]
+		if ckb == nkb {
+			return nil, errMergeClash
+		}
+		a := *n
+		a.compressed, a.keybyte = n.compressed[mis+1:], nkb
+		keys := []byte{nkb, ckb}
+		kids := []*bnode{bnodeInner(&a), c}
+		if ckb < nkb {
+			keys[0], keys[1] = keys[1], keys[0]
+			kids[0], kids[1] = kids[1], kids[0]
+		}
+		compressed := append([]byte{}, n.compressed[:mis]...)
+		return innerCopying(compressed, keybyte, keys, kids, m.gen), nil
+	}
+
+	pos := depth + len(n.compressed)
+	ckb := Key(path).At(len(n.compressed))
+	var keys []byte
+	var kids []*bnode
+	added := false
+	for kb, ch := range n.kids() {
+		if !added && ckb <= kb {
+			added = true
+			if ckb == kb {
+				var err error
+				if cFirst {
+					ch, err = m.merge(c, ch, pos+1, kb)
+				} else {
+					ch, err = m.merge(ch, c, pos+1, kb)
+				}
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				keys = append(keys, ckb)
+				kids = append(kids, c)
+			}
+		}
+		keys = append(keys, kb)
+		kids = append(kids, ch)
+	}
+	if !added {
+		keys = append(keys, ckb)
+		kids = append(kids, c)
+	}
+	return innerCopying(n.compressed, keybyte, keys, kids, m.gen), nil
+}
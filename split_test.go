@@ -0,0 +1,242 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"testing"
+)
+
+func TestSplitAt_and_Join(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(9, 1))
+	keys := mixedKeys(rng)
+
+	for trial := range 100 {
+		tree := NewArtTree()
+		ref := make(map[string]int)
+		for i, k := range keys {
+			if rng.IntN(3) > 0 {
+				tree.Insert(k, i)
+				ref[string(k)] = i
+			}
+		}
+		var key Key
+		switch trial % 10 {
+		case 0:
+			// nil: everything goes right.
+		case 1:
+			key = append(append(Key{}, keys[rng.IntN(len(keys))]...), 0x7f)
+		default:
+			key = keys[rng.IntN(len(keys))]
+		}
+		left, right := tree.SplitAt(key)
+
+		lref := make(map[string]int)
+		rref := make(map[string]int)
+		for k, v := range ref {
+			if bytes.Compare(Key(k), key) < 0 {
+				lref[k] = v
+			} else {
+				rref[k] = v
+			}
+		}
+		checkTree(t, "left", left, treeOf(lref), nil)
+		checkTree(t, "right", right, treeOf(rref), nil)
+		checkTree(t, "original", tree, treeOf(ref), nil)
+
+		joined, err := Join(left, right)
+		if err != nil {
+			t.Fatalf("trial %v: Join: %v", trial, err)
+		}
+		checkTree(t, "joined", joined, treeOf(ref), nil)
+
+		// the trees share nodes, but writes to
+		// one must not show up in the others.
+		for k := range ref {
+			switch rng.IntN(8) {
+			case 0:
+				tree.Remove(Key(k))
+			case 1:
+				left.Remove(Key(k))
+				delete(lref, k)
+			case 2:
+				if _, ok := rref[k]; ok {
+					right.Insert(Key(k), -1)
+					rref[k] = -1
+				}
+			case 3:
+				joined.Remove(Key(k))
+			}
+		}
+		checkTree(t, "left after writes", left, treeOf(lref), nil)
+		checkTree(t, "right after writes", right, treeOf(rref), nil)
+
+		// Join the other way around.
+		joined, err = Join(right, left)
+		if err != nil {
+			t.Fatalf("trial %v: Join: %v", trial, err)
+		}
+		for k, v := range rref {
+			lref[k] = v
+		}
+		checkTree(t, "rejoined", joined, treeOf(lref), nil)
+	}
+}
+
+func TestSplitIndex(t *testing.T) {
+	tree := NewArtTree()
+	n := 1000
+	for i := range n {
+		tree.Insert(Key(fmt.Sprintf("%x", i*7919)), i)
+	}
+	for _, i := range []int{-1, 0, 1, 2, 17, 500, 998, 999, 1000, 5000} {
+		left, right := tree.SplitIndex(i)
+		want := max(0, min(i, n))
+		if left.Size() != want || right.Size() != n-want {
+			t.Fatalf("SplitIndex(%v): sizes %v and %v", i, left.Size(), right.Size())
+		}
+		if want > 0 {
+			lf, _ := left.At(want - 1)
+			lf2, _ := tree.At(want - 1)
+			if !bytes.Equal(lf.Key, lf2.Key) {
+				t.Fatalf("SplitIndex(%v): left ends at %q, want %q", i, lf.Key, lf2.Key)
+			}
+		}
+		if want < n {
+			lf, _ := right.At(0)
+			lf2, _ := tree.At(want)
+			if !bytes.Equal(lf.Key, lf2.Key) {
+				t.Fatalf("SplitIndex(%v): right starts at %q, want %q", i, lf.Key, lf2.Key)
+			}
+		}
+	}
+}
+
+func TestSplitAt_snapshot_and_options(t *testing.T) {
+	for _, tree := range []*Tree{NewArtTree(), NewArtTree(WithSlabAllocator())} {
+		ref := make(map[string]int)
+		for i := range 500 {
+			k := fmt.Sprintf("%03d", i)
+			tree.Insert(Key(k), i)
+			ref[k] = i
+		}
+		snap := tree.Snapshot()
+		for _, src := range []*Tree{tree, snap} {
+			left, right := src.SplitAt(Key("250"))
+			if left.Size() != 250 || right.Size() != 250 {
+				t.Fatalf("sizes %v and %v", left.Size(), right.Size())
+			}
+			if (left.slab != nil) != (src.slab != nil) || left.SkipLocking {
+				t.Fatalf("options not kept")
+			}
+			left.Insert(Key("left"), 0)
+			right.Remove(Key("250"))
+			joined, err := Join(left, right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if joined.Size() != 500 {
+				t.Fatalf("joined size %v", joined.Size())
+			}
+		}
+		checkTree(t, "after splits", tree, treeOf(ref), nil)
+		checkTree(t, "snapshot", snap, treeOf(ref), nil)
+	}
+}
+
+// Join of trees whose keys interleave, and are
+// often prefixes of one another.
+func TestJoin_interleaved(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(3, 4))
+	for trial := range 200 {
+		a, b := NewArtTree(), NewArtTree()
+		ref := make(map[string]int)
+		for i := range 300 {
+			k := make(Key, rng.IntN(5))
+			for j := range k {
+				k[j] = byte(1 + rng.IntN(3))
+			}
+			if _, ok := ref[string(k)]; ok {
+				continue
+			}
+			ref[string(k)] = i
+			if rng.IntN(2) == 0 {
+				a.Insert(k, i)
+			} else {
+				b.Insert(k, i)
+			}
+		}
+		j, err := Join(a, b)
+		if err != nil {
+			t.Fatalf("trial %v: %v", trial, err)
+		}
+		checkTree(t, "joined", j, treeOf(ref), nil)
+
+		// a key in both is an error.
+		if a.Size() > 0 {
+			lf, _ := a.At(rng.IntN(a.Size()))
+			b.Insert(lf.Key, 0)
+			if _, err := Join(a, b); err == nil {
+				t.Fatalf("trial %v: Join with %q in both did not fail", trial, lf.Key)
+			}
+		}
+	}
+}
+
+// A key that ends where another goes on with a 0
+// byte cannot be merged node by node, so Join falls
+// back to building a new tree. (Insert cannot hold
+// both such keys either, so there is no right
+// answer to check against.)
+func TestJoin_zero_byte_clash(t *testing.T) {
+	a, b := NewArtTree(), NewArtTree()
+	for _, k := range []string{"a", "b", "c\x00"} {
+		a.Insert(Key(k), k)
+	}
+	for _, k := range []string{"a\x00b", "a\x00c", "c"} {
+		b.Insert(Key(k), k)
+	}
+	j, err := Join(a, b)
+	if err != nil || j.Size() == 0 {
+		t.Fatalf("Join: %v, %v", j, err)
+	}
+}
+
+// Moving the upper half of a tree to a new tree:
+// SplitAt versus Clone and Remove.
+func BenchmarkSplitAt(b *testing.B) {
+	words := loadTestFile("assets/words.txt")
+	full := NewArtTree()
+	for _, w := range words {
+		full.Insert(w, nil)
+	}
+	mid, _ := full.At(full.Size() / 2)
+
+	b.Run("SplitAt", func(b *testing.B) {
+		for range b.N {
+			left, right := full.SplitAt(mid.Key)
+			if left.Size()+right.Size() != full.Size() {
+				b.Fatal("lost keys")
+			}
+		}
+	})
+	b.Run("Join", func(b *testing.B) {
+		left, right := full.SplitAt(mid.Key)
+		for range b.N {
+			j, _ := Join(left, right)
+			if j.Size() != full.Size() {
+				b.Fatal("lost keys")
+			}
+		}
+	})
+	b.Run("Clone_and_Remove", func(b *testing.B) {
+		for range b.N {
+			right := full.Clone()
+			for _, w := range words {
+				if bytes.Compare(w, mid.Key) < 0 {
+					right.Remove(w)
+				}
+			}
+		}
+	})
+}
@@ -3,7 +3,9 @@ package uart
 import (
 	"fmt"
 	"sync"
-	//"github.com/glycerine/uart/drwmutex"
+	"sync/atomic"
+
+	"github.com/glycerine/uart/drwmutex"
 )
 
 // Tree is a trie that implements
@@ -42,6 +44,7 @@ import (
 //
 // ART supports just a single value for each
 // key -- it is not a "multi-map" in the C++ sense.
+// MultiTree is the multi-map built on it.
 //
 // Concurrency: this ART implementation is
 // goroutine safe, as it uses a the Tree.RWmut
@@ -57,7 +60,13 @@ import (
 // be set to omit all locking if goroutine
 // coordination is provided by other means,
 // or unneeded (in the case of single goroutine
-// only access).
+// only access). Snapshot provides a frozen,
+// point-in-time view of the tree that can be
+// read without any locking. On machines with
+// many cores, WithDRWMutex spreads the read lock
+// over one RWMutex per CPU, and WithOptimisticReads
+// lets Find, At, and Size check version numbers
+// on the nodes they read instead of taking it.
 //
 // [1] "The Adaptive Radix Tree: ARTful
 // Indexing for Main-Memory Databases"
@@ -78,8 +87,10 @@ import (
 // https://www.chiark.greenend.org.uk/~sgtatham/algorithms/cbtree.html
 type Tree struct {
 	RWmut sync.RWMutex `msg:"-"`
-	//DRWmut *drwmutex.DRWMutex `msg:"-"`
-	//RWmut  *sync.RWMutex      `msg:"-"`
+
+	// DRWmut, if set by WithDRWMutex, is
+	// used instead of RWmut.
+	DRWmut *drwmutex.DRWMutex `msg:"-"`
 
 	root *bnode
 	size int64
@@ -132,17 +143,174 @@ type Tree struct {
 	// employed if SkipLocking is allowed to
 	// default to false.
 	SkipLocking bool `msg:"-"`
+
+	// cow is set once Snapshot has been called;
+	// writers must then copy any inner node
+	// whose gen differs from our gen before
+	// modifying it.
+	cow bool
+	gen uint64
+
+	// pins counts the snapshots of t not yet
+	// released, and pinned is set once t's nodes
+	// are shared for good, by SplitAt, Join and
+	// the set operations. Release leaves cow
+	// mode when neither holds.
+	pins   int
+	pinned bool
+
+	// readOnly is set on the trees returned by Snapshot.
+	readOnly bool
+
+	// src is the tree a snapshot was taken
+	// from, until the snapshot is released.
+	src *Tree
+
+	// compact is set by WithCompactLeaves.
+	compact bool
+
+	// buckets, if set by WithBuckets, is the
+	// most keys a bucket holds before it bursts.
+	buckets int
+
+	// slab, if set by WithSlabAllocator,
+	// allocates and recycles the nodes.
+	slab *slabs
+
+	// olc, if set by WithOptimisticReads, holds the
+	// versions that its readers check.
+	olc *olcState
+
+	// ValueCodec encodes and decodes Leaf.Value
+	// for WriteTo and ReadFrom. If nil,
+	// BytesCodec is used.
+	ValueCodec ValueCodec `msg:"-"`
+
+	// watchers holds the open Watchers, if any;
+	// watchMu guards changes to it, and keeps
+	// deliveries in write order. pending collects
+	// the events of the write in progress.
+	watchers atomic.Pointer[[]*Watcher]
+	watchMu  sync.Mutex
+	pending  []Event
 }
 
+// TreeOption configures a Tree in NewArtTree.
+type TreeOption func(t *Tree)
+
 // NewArtTree creates and returns a new ART Tree,
 // ready for use.
-func NewArtTree() *Tree {
-	//dmut := drwmutex.NewDRWMutex()
-	// gets the sync.RWLock, not locked:
-	//locker := dmut.RLocker()
-	return &Tree{
-		//DRWmut: dmut,
-		//RWmut:  locker,
+func NewArtTree(opts ...TreeOption) *Tree {
+	t := &Tree{}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.compact && t.buckets > 0 {
+		panic("uart: a tree cannot have both compact leaves and buckets")
+	}
+	if t.olc != nil && t.buckets > 0 {
+		panic("uart: a tree cannot have both optimistic reads and buckets")
+	}
+	return t
+}
+
+// WithDRWMutex returns a TreeOption that makes the
+// tree lock with a drwmutex.DRWMutex rather than
+// RWmut. The DRWMutex has one RWMutex per CPU,
+// and a reader takes only the one for the CPU it
+// is running on, so readers on different cores
+// do not all contend on the cache line of a
+// single RWMutex. A writer has to take every
+// CPU's lock, so this suits read-heavy
+// workloads on machines with many cores.
+func WithDRWMutex() TreeOption {
+	return func(t *Tree) {
+		t.DRWmut = drwmutex.NewDRWMutex()
+	}
+}
+
+// rlock takes a read lock, and returns
+// the lock to call RUnlock on.
+func (t *Tree) rlock() *sync.RWMutex {
+	if t.DRWmut != nil {
+		return t.DRWmut.RLock()
+	}
+	t.RWmut.RLock()
+	return &t.RWmut
+}
+
+// lock takes the write lock. With WithOptimisticReads,
+// it also latches the whole tree, so the write may
+// change any part of it; see lockPath.
+func (t *Tree) lock() {
+	if t.DRWmut != nil {
+		t.DRWmut.Lock()
+	} else {
+		t.RWmut.Lock()
+	}
+	if optimisticOK && t.olc != nil {
+		atomic.AddUint64(&t.olc.bulk, 1)
+	}
+}
+
+// lockPath takes the write lock for a write that
+// changes only the path to one key, by way of
+// upsert_unlocked or remove_unlocked. With
+// WithOptimisticReads, it latches just the root,
+// and the write latches each inner node it
+// changes as it gets there; see Tree.latch.
+func (t *Tree) lockPath() {
+	if t.DRWmut != nil {
+		t.DRWmut.Lock()
+	} else {
+		t.RWmut.Lock()
+	}
+	if o := t.olc; optimisticOK && o != nil {
+		atomic.AddUint64(&o.root, 1)
+		o.path = true
+	}
+}
+
+// unlock releases the write lock, and
+// the latches of WithOptimisticReads.
+func (t *Tree) unlock() {
+	if optimisticOK && t.olc != nil {
+		t.olc.release(t)
+	}
+	if t.DRWmut != nil {
+		t.DRWmut.Unlock()
+		return
+	}
+	t.RWmut.Unlock()
+}
+
+// wlock starts a write. All the
+// writers call it, and then defer wunlock.
+func (t *Tree) wlock() {
+	t.panicIfReadOnly()
+	if !t.SkipLocking {
+		t.lock()
+	}
+}
+
+// wlockPath is wlock for the writers
+// that lockPath is for.
+func (t *Tree) wlockPath() {
+	t.panicIfReadOnly()
+	if !t.SkipLocking {
+		t.lockPath()
+	}
+}
+
+// wunlock ends a write. Watchers
+// are told of the write here.
+func (t *Tree) wunlock() {
+	if len(t.pending) > 0 {
+		t.deliver()
+		return
+	}
+	if !t.SkipLocking {
+		t.unlock()
 	}
 }
 
@@ -155,9 +323,14 @@ func (t *Tree) Size() (sz int) {
 	if t.SkipLocking {
 		return int(t.size)
 	}
-	t.RWmut.RLock()
+	if t.optimistic() {
+		if sz, ok := t.sizeOptimistic(); ok {
+			return sz
+		}
+	}
+	rl := t.rlock()
 	sz = int(t.size)
-	t.RWmut.RUnlock()
+	rl.RUnlock()
 	return
 }
 
@@ -208,6 +381,8 @@ func (t *Tree) InsertX(key Key, value any, x []byte) (updated bool) {
 // and the leaf.Value now holds the value
 // from this Insert call.
 func (t *Tree) Insert(key Key, value any) (updated bool) {
+	t.wlockPath()
+	defer t.wunlock()
 
 	// make a copy of key that we own, so
 	// caller can alter/reuse without messing us up.
@@ -215,10 +390,11 @@ func (t *Tree) Insert(key Key, value any) (updated bool) {
 	// it is important. The benchmarks will crash
 	// without it, for instance, since they
 	// re-use key []byte memory alot.
-	key2 := Key(append([]byte{}, key...))
-	lf := NewLeaf(key2, value, nil)
-
-	return t.InsertLeaf(lf)
+	// The copy is made under the lock, since
+	// it may come from the tree's slabs.
+	updated = t.insert_unlocked(t.newLeaf(key, value))
+	t.treeVersion++
+	return
 }
 
 // InsertLeaf: the *Leaf lf *must* own the lf.Key it holds.
@@ -226,31 +402,135 @@ func (t *Tree) Insert(key Key, value any) (updated bool) {
 // copying the slice if necessary before
 // submitting the Leaf.
 func (t *Tree) InsertLeaf(lf *Leaf) (updated bool) {
+	t.wlockPath()
+	defer t.wunlock()
 
-	if !t.SkipLocking {
-		t.RWmut.Lock()
-		defer t.RWmut.Unlock()
+	if t.compact {
+		// the tree stores a leaf of its own;
+		// see WithCompactLeaves.
+		lf = t.newLeaf(lf.Key, lf.Value)
 	}
+	updated = t.insert_unlocked(lf)
+	t.treeVersion++
+	return
+}
 
-	var replacement *bnode
+// insert_unlocked is InsertLeaf without the locking
+// and without the treeVersion bump, which are left
+// to the caller, so that Apply can make many writes
+// under one lock and one version.
+func (t *Tree) insert_unlocked(lf *Leaf) (updated bool) {
+	updated, _ = t.upsert_unlocked(lf, nil)
+	return
+}
+
+// upsert steers the descent of upsert_unlocked.
+type upsert struct {
+	// decide is called once, where the descent for the
+	// key ends, before anything there is changed. old is
+	// the leaf holding the key, or nil if there is none.
+	// decide returns the leaf to store, which must hold
+	// the key, or nil to store nothing; or, with del
+	// set, has old removed.
+	decide func(old *Leaf) (lf *Leaf, del bool)
+
+	// stored is the leaf decide chose to store, if any.
+	// removed is the bnode of old, once the descent
+	// has removed it, for the nodes above it.
+	stored  *Leaf
+	removed *bnode
+}
+
+// at calls u.decide, and records what it chose.
+// With no u, lf is stored.
+func (u *upsert) at(old, lf *Leaf) (store *Leaf, del bool) {
+	if u == nil {
+		return lf, false
+	}
+	store, del = u.decide(old)
+	if old == nil || store != nil {
+		del = false
+	}
+	u.stored = store
+	return store, del
+}
 
+// upsert_unlocked is insert_unlocked, except that,
+// with u, lf need only hold the key: the descent
+// for it asks u what to do where it ends, which
+// may be to store a leaf, to leave the tree as it
+// was, or to remove the key. See upsert. A removed
+// leaf is returned, with its whole key.
+func (t *Tree) upsert_unlocked(lf *Leaf, u *upsert) (updated bool, deleted *Leaf) {
 	if t.root == nil {
+		if lf, _ = u.at(nil, lf); lf == nil {
+			return
+		}
 		// first leaf in the tree
+		if t.compact {
+			lf.rebase(0, t.slab)
+		}
 		t.size++
-		t.root = bnodeLeaf(lf)
-		t.treeVersion++
-		return false
+		t.root = t.slab.bnodeLeaf(lf)
+		if t.watched() {
+			t.note(KeyInserted, lf)
+		}
+		return
 	}
+	key := lf.Key
 
-	//vv("t.size = %v", t.size)
-	replacement, updated = t.root.insert(lf, 0, t.root, t, nil)
+	var replacement *bnode
+	if u != nil && t.root.isLeaf && t.root.leaf.compare(key, 0) == 0 {
+		// a leaf at the root has no parent to
+		// remove it from, so it is decided for here.
+		store, del := u.at(t.root.leaf, lf)
+		switch {
+		case del:
+			u.removed = t.root
+		case store == nil:
+			return
+		default:
+			lf, u = store, nil
+		}
+	}
+	if u == nil || u.removed == nil {
+		//vv("t.size = %v", t.size)
+		replacement, updated = t.root.insert(lf, 0, t.root, t, nil, u)
+	}
+	if u != nil && u.removed != nil {
+		t.root = replacement
+		t.size--
+		deleted = u.removed.leaf.full(key)
+		t.recycler().freeBnode(u.removed)
+		u.removed = nil
+		if t.watched() {
+			t.note(KeyRemoved, deleted)
+		}
+		return false, deleted
+	}
+	if u != nil {
+		if u.stored == nil {
+			// the descent stopped without a change,
+			// reporting updated so that no SubN changed.
+			return false, nil
+		}
+		lf = u.stored
+	}
 	if replacement != nil {
 		t.root = replacement
 	}
 	if !updated {
 		t.size++
 	}
-	t.treeVersion++
+	if t.watched() {
+		// a compact lf was rebased as it was stored.
+		lf = lf.full(key)
+		if updated {
+			t.note(KeyUpdated, lf)
+		} else {
+			t.note(KeyInserted, lf)
+		}
+	}
 	return
 }
 
@@ -356,16 +636,29 @@ func (t *Tree) LastLeaf() (lf *Leaf, idx int, found bool) {
 //
 // By default, Find obtains a read-lock on the
 // Tree.RWmut. This can be omitted by setting the
-// Tree.SkipLocking option to true.
+// Tree.SkipLocking option to true, or avoided
+// with WithOptimisticReads.
 func (t *Tree) Find(smod SearchModifier, key Key) (lf *Leaf, idx int, found bool) {
+	if t.optimistic() {
+		return t.findOptimistic(smod, key)
+	}
 	if !t.SkipLocking {
-		t.RWmut.RLock()
-		defer t.RWmut.RUnlock()
+		rl := t.rlock()
+		defer rl.RUnlock()
 	}
 	return t.find_unlocked(smod, key)
 }
 
 func (t *Tree) find_unlocked(smod SearchModifier, key Key) (lf *Leaf, idx int, found bool) {
+	if t.compact || t.buckets > 0 {
+		return t.findByRank(smod, key)
+	}
+	return t.findLeaf(smod, key)
+}
+
+// findLeaf does the search for find_unlocked,
+// returning the leaf as it is stored in the tree.
+func (t *Tree) findLeaf(smod SearchModifier, key Key) (lf *Leaf, idx int, found bool) {
 
 	//vv("Find, smod='%v; key='%v'; t.size='%v'", smod, string(key), t.size)
 	if t.root == nil {
@@ -462,24 +755,40 @@ func (smod SearchModifier) String() string {
 // its associated Leaf from which value, in
 // the deletedLeaf.Value field, can be obtained.
 func (t *Tree) Remove(key Key) (deleted bool, deletedLeaf *Leaf) {
+	t.wlockPath()
+	defer t.wunlock()
 
-	if !t.SkipLocking {
-		t.RWmut.Lock()
-		defer t.RWmut.Unlock()
+	deleted, deletedLeaf = t.remove_unlocked(key)
+	if deleted {
+		t.treeVersion++
 	}
+	return
+}
 
+// remove_unlocked is Remove without the locking
+// and the treeVersion bump; see insert_unlocked.
+func (t *Tree) remove_unlocked(key Key) (deleted bool, deletedLeaf *Leaf) {
 	var deletedNode *bnode
 	if t.root == nil {
 		return
 	}
+	if t.cow {
+		// avoid copying the path to a missing key.
+		if _, _, found := t.find_unlocked(Exact, key); !found {
+			return
+		}
+	}
 
-	deleted, deletedNode = t.root.del(key, 0, t.root, func(rn *bnode) {
+	deleted, deletedNode = t.root.del(key, 0, t.root, t, func(rn *bnode) {
 		t.root = rn
 	})
 	if deleted {
-		deletedLeaf = deletedNode.leaf
+		deletedLeaf = deletedNode.leaf.full(key)
+		t.recycler().freeBnode(deletedNode)
 		t.size--
-		t.treeVersion++
+		if t.watched() {
+			t.note(KeyRemoved, deletedLeaf)
+		}
 	}
 	return
 }
@@ -489,9 +798,14 @@ func (t *Tree) IsEmpty() (empty bool) {
 	if t.SkipLocking {
 		return t.root == nil
 	}
-	t.RWmut.RLock()
+	if t.optimistic() {
+		if sz, ok := t.sizeOptimistic(); ok {
+			return sz == 0
+		}
+	}
+	rl := t.rlock()
 	empty = t.root == nil
-	t.RWmut.RUnlock()
+	rl.RUnlock()
 	return
 }
 
@@ -534,14 +848,20 @@ func (t *Tree) IsEmpty() (empty bool) {
 //
 // [1] https://www.chiark.greenend.org.uk/~sgtatham/algorithms/cbtree.html
 //
+// With WithOptimisticReads, At takes no read lock,
+// and so keeps no cache; it is then the same as Atfar.
+//
 // [2] https://en.wikipedia.org/wiki/Order_statistic_tree
 func (t *Tree) At(i int) (lf *Leaf, ok bool) {
 	if t.SkipLocking {
 		return t.at_unlocked(i)
 	}
-	t.RWmut.RLock()
+	if t.optimistic() {
+		return t.atOptimistic(i)
+	}
+	rl := t.rlock()
 	lf, ok = t.at_unlocked(i)
-	t.RWmut.RUnlock()
+	rl.RUnlock()
 	return
 }
 
@@ -561,31 +881,47 @@ func (t *Tree) At(i int) (lf *Leaf, ok bool) {
 // The name tries to suggest that this access is "far" away
 // from any others.
 func (t *Tree) Atfar(i int) (lf *Leaf, ok bool) {
-	if t.SkipLocking {
-		if t == nil || t.root == nil {
-			return
-		}
-		return t.root.at(i)
+	if t == nil {
+		return
 	}
-	t.RWmut.RLock()
-	if t == nil || t.root == nil {
+	if t.optimistic() {
+		return t.atOptimistic(i)
+	}
+	if !t.SkipLocking {
+		rl := t.rlock()
+		defer rl.RUnlock()
+	}
+	if t.root == nil {
 		return
 	}
-	lf, ok = t.root.at(i)
-	t.RWmut.RUnlock()
-	return
+	return t.rootAt(i)
+}
+
+// rootAt returns the i-th leaf, as At does, with
+// its whole key if t has compact leaves. t.root
+// must not be nil.
+func (t *Tree) rootAt(i int) (lf *Leaf, ok bool) {
+	if t.compact {
+		return t.root.atFull(i)
+	}
+	return t.root.at(i)
 }
 
 func (t *Tree) at_unlocked(i int) (lf *Leaf, ok bool) {
 	if t == nil || t.root == nil {
 		return
 	}
+	if t.readOnly {
+		// many readers may share a snapshot,
+		// so it cannot keep an atCache.
+		return t.rootAt(i)
+	}
 	if t.atCache != nil {
 		if t.atCache.treeVersion == t.treeVersion {
 			if i == t.atCache.curIdx+1 {
 				ok = t.atCache.Next()
 				if ok {
-					lf = t.atCache.leaf
+					lf = t.atCache.Leaf()
 					return
 				}
 			}
@@ -594,7 +930,7 @@ func (t *Tree) at_unlocked(i int) (lf *Leaf, ok bool) {
 	}
 	// INVAR: t.atCache == nil
 
-	lf, ok = t.root.at(i)
+	lf, ok = t.rootAt(i)
 
 	// try to cache At() iteration. Gives 6x speedup
 	// for common case of going forward.
@@ -618,12 +954,19 @@ func (t *Tree) Atv(i int) (val any, ok bool) {
 		}
 		return
 	}
-	t.RWmut.RLock()
+	if t.optimistic() {
+		lf, ok = t.atOptimistic(i)
+		if ok {
+			val = lf.Value
+		}
+		return
+	}
+	rl := t.rlock()
 	lf, ok = t.at_unlocked(i)
 	if ok {
 		val = lf.Value
 	}
-	t.RWmut.RUnlock()
+	rl.RUnlock()
 	return
 }
 
@@ -636,9 +979,9 @@ func (t *Tree) Atv(i int) (val any, ok bool) {
 // efficiently. The time complexity
 // is O(log N).
 func (t *Tree) LeafIndex(leaf *Leaf) (idx int, ok bool) {
-	t.RWmut.RLock()
+	rl := t.rlock()
 	_, idx, ok = t.find_unlocked(Exact, leaf.Key)
-	t.RWmut.RUnlock()
+	rl.RUnlock()
 	return
 }
 
@@ -661,21 +1004,54 @@ func (t *Tree) CompressedStats() (cs map[int]int, bytesSaved int) {
 	return
 }
 
+// Clone returns a copy of the tree. The keys
+// are copied; the values are shared. The copy
+// is built bottom-up, as in BuildFromSorted,
+// rather than by re-inserting every key.
 func (t *Tree) Clone() (r *Tree) {
 	if t == nil {
 		return
 	}
 	if !t.SkipLocking {
-		t.RWmut.RLock()
-		defer t.RWmut.RUnlock()
+		rl := t.rlock()
+		defer rl.RUnlock()
 	}
-	r = &Tree{
-		SkipLocking: true,
+	leaves := make([]*Leaf, 0, t.size)
+	it := t.Iter(nil, nil)
+	for it.Next() {
+		lf := it.Leaf()
+		leaves = append(leaves, &Leaf{
+			Key:   append([]byte{}, lf.Key...),
+			Value: lf.Value,
+		})
 	}
-	for key, valAny := range Ascend(t, nil, nil) {
-		r.Insert(key, valAny)
-	}
-	r.SkipLocking = t.SkipLocking
+	r = buildFromLeaves(leaves)
+	r.finishFrom(t)
 	return
+}
 
+// adoptOptions gives r, a new tree built from the
+// contents of t, the same locking mode, allocator,
+// and ValueCodec as t. A snapshot's copies are ordinary,
+// locked trees. If t has compact leaves, so must r's
+// root; see compactLeaves. r keeps t's bucket size,
+// whether or not its root has buckets.
+func (r *Tree) adoptOptions(t *Tree) {
+	r.SkipLocking = t.SkipLocking && !t.readOnly
+	r.ValueCodec = t.ValueCodec
+	r.compact = t.compact
+	r.buckets = t.buckets
+	if t.slab != nil {
+		WithSlabAllocator()(r)
+	}
+	if t.DRWmut != nil {
+		WithDRWMutex()(r)
+	}
+	if t.olc != nil {
+		// readers of r will not fix up a stale pren.
+		WithOptimisticReads()(r)
+		if r.root != nil {
+			r.root.subTreeRedoPren()
+		}
+	}
 }
@@ -753,6 +753,18 @@ func loadTestFile(path string) [][]byte {
 	return words
 }
 
+// loadPaths returns the lines of assets/paths.txt:
+// the paths of the files and directories of a Go
+// distribution, relative to its root, and sorted.
+// Path-like keys share long prefixes, unlike the
+// words, so several tests and benchmarks use both.
+func loadPaths() (paths []string) {
+	for _, p := range loadTestFile("assets/paths.txt") {
+		paths = append(paths, string(p))
+	}
+	return
+}
+
 type KV struct {
 	Key            []byte
 	ByteSliceValue []byte
@@ -1616,6 +1628,114 @@ func Test510_SubN_maintained_for_At_indexing_(t *testing.T) {
 	}
 }
 
+// checkTree fails unless tree answers each read just
+// as want, a tree holding the keys and values that tree
+// should, does: Size, Ascend, Descend, At and Atfar,
+// and for each probe, Find with every SearchModifier,
+// CountPrefix and Iter. It also verifies tree's SubN,
+// pren and leaf index bookkeeping.
+func checkTree(t *testing.T, what string, tree, want *Tree, probes []Key) {
+	t.Helper()
+	if tree.Size() != want.Size() {
+		t.Fatalf("%v: Size %v, want %v", what, tree.Size(), want.Size())
+	}
+	var wantKV, got []string
+	for key, lf := range Ascend(want, nil, nil) {
+		wantKV = append(wantKV, fmt.Sprintf("%q=%v", key, lf.(*Leaf).Value))
+	}
+	for key, lf := range Ascend(tree, nil, nil) {
+		got = append(got, fmt.Sprintf("%q=%v", key, lf.(*Leaf).Value))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(wantKV) {
+		t.Fatalf("%v: Ascend %v, want %v", what, got, wantKV)
+	}
+	i := len(got)
+	for key, lf := range Descend(tree, nil, nil) {
+		i--
+		if s := fmt.Sprintf("%q=%v", key, lf.(*Leaf).Value); s != wantKV[i] {
+			t.Fatalf("%v: Descend %v is %v, want %v", what, i, s, wantKV[i])
+		}
+	}
+	for i := range want.Size() {
+		a, _ := want.At(i)
+		b, ok := tree.At(i)
+		b2, ok2 := tree.Atfar(i)
+		if !ok || !ok2 || !bytes.Equal(b.Key, a.Key) || !bytes.Equal(b2.Key, a.Key) || b.Value != a.Value {
+			t.Fatalf("%v: At(%v) = %q, Atfar %q; want %q", what, i, b.Key, b2.Key, a.Key)
+		}
+	}
+	for _, k := range probes {
+		for _, smod := range []SearchModifier{Exact, GTE, GT, LTE, LT} {
+			a, ai, aok := want.Find(smod, k)
+			b, bi, bok := tree.Find(smod, k)
+			if aok != bok || (aok && (ai != bi || !bytes.Equal(a.Key, b.Key) || a.Value != b.Value)) {
+				t.Fatalf("%v: Find(%v, %q) = %v, %v, %v; want %v, %v, %v", what, smod, k, b, bi, bok, a, ai, aok)
+			}
+		}
+		if tree.CountPrefix(k) != want.CountPrefix(k) {
+			t.Fatalf("%v: CountPrefix(%q) = %v, want %v", what, k, tree.CountPrefix(k), want.CountPrefix(k))
+		}
+		it, wit := tree.Iter(k, nil), want.Iter(k, nil)
+		for range 3 {
+			ok, wok := it.Next(), wit.Next()
+			if ok != wok || (ok && !bytes.Equal(it.Key(), wit.Key())) {
+				t.Fatalf("%v: Iter(%q) gives %q, want %q", what, k, it.Key(), wit.Key())
+			}
+		}
+	}
+	if tree.root != nil {
+		verifySubN(tree.root)
+	}
+	verifyLeafIndexAt(tree)
+}
+
+// treeOf returns an ordinary tree holding the
+// keys and values of ref, to checkTree against.
+func treeOf(ref map[string]int) *Tree {
+	tree := NewArtTree()
+	for k, v := range ref {
+		tree.Insert(Key(k), v)
+	}
+	return tree
+}
+
+// randKeys returns n random keys of 1 to maxLen bytes.
+// The bytes are drawn from alphabet, or from 1 to 255
+// if it is empty: never 0, which the tree cannot tell
+// from the end of a shorter key. A small alphabet makes
+// many keys share long prefixes, or be prefixes of others.
+func randKeys(rng *mathrand2.Rand, n, maxLen int, alphabet string) (keys []Key) {
+	for range n {
+		k := make(Key, 1+rng.IntN(maxLen))
+		for j := range k {
+			if alphabet == "" {
+				k[j] = byte(1 + rng.IntN(255))
+			} else {
+				k[j] = alphabet[rng.IntN(len(alphabet))]
+			}
+		}
+		keys = append(keys, k)
+	}
+	return
+}
+
+// mixedKeys returns a sorted, duplicate free
+// mix of words and short binary keys.
+func mixedKeys(rng *mathrand2.Rand) (keys []Key) {
+	seen := make(map[string]bool)
+	for _, w := range loadTestFile("assets/words.txt")[:3000] {
+		seen[string(w)] = true
+	}
+	for _, k := range randKeys(rng, 1000, 4, "") {
+		seen[string(k)] = true
+	}
+	for k := range seen {
+		keys = append(keys, Key(k))
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return
+}
+
 // verifySubN:
 // walk through the subtree at root, counting children.
 // At each inner node, verify that SubN
@@ -1659,6 +1779,8 @@ func verifySubN(root *bnode) (leafcount int) {
 					leafcount += verifySubN(child)
 				}
 			}
+		case *bucket:
+			leafcount = len(n.leaves)
 		}
 
 		if root.inner.SubN != leafcount {
@@ -2203,3 +2325,30 @@ func Test620_unlocked_read_comparison(t *testing.T) {
 	*/
 
 }
+
+func TestWithDRWMutex_builtin_locking(t *testing.T) {
+	tree := NewArtTree(WithDRWMutex())
+	words := loadTestFile("assets/words.txt")[:10000]
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i, w := range words {
+			tree.Insert(w, i)
+		}
+	}()
+	for i := 0; i < 5000; i++ {
+		w := words[i%len(words)]
+		if v, _, ok := tree.FindExact(w); ok && v.(int) != i%len(words) {
+			t.Fatalf("FindExact(%q) = %v", w, v)
+		}
+		tree.Size()
+	}
+	<-done
+	if tree.Size() != len(words) {
+		t.Fatalf("Size %v, want %v", tree.Size(), len(words))
+	}
+	c := tree.Clone()
+	if c.DRWmut == nil || c.DRWmut == tree.DRWmut || c.Size() != len(words) {
+		t.Fatalf("Clone did not get its own DRWMutex")
+	}
+}
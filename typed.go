@@ -0,0 +1,243 @@
+package uart
+
+import (
+	"iter"
+	"unsafe"
+)
+
+// TypedTree is an ART Tree whose values all have
+// the single type V. It is built on exactly the same
+// inner/bnode machinery as Tree, but the Find,
+// At, Remove, and iteration methods return a V
+// rather than an `any`, so callers need no type
+// assertions on their hot paths.
+//
+// Each leaf stores its V inline: the leaf and its
+// value are a single allocation, so small values
+// (ints, small structs) are not boxed into an
+// interface on every Insert as they would be
+// with Tree.Insert.
+//
+// The Leaf.Value field of the leaves inside a
+// TypedTree is always nil; the values are
+// only reachable through the TypedTree methods.
+// For this reason the underlying Tree is not
+// exposed: every leaf in it must have been
+// inserted by the TypedTree itself.
+//
+// Concurrency is the same as for Tree: by
+// default the underlying Tree.RWmut is used, and
+// SetSkipLocking can be used to turn it off.
+type TypedTree[V any] struct {
+	t *Tree
+}
+
+// typedLeaf is the leaf actually stored in
+// a TypedTree[V]. The Leaf must be the first
+// field so that a *Leaf handed back by the Tree
+// can be converted back into its *typedLeaf.
+type typedLeaf[V any] struct {
+	Leaf
+	val V
+}
+
+// NewTypedTree returns a new, empty TypedTree.
+// The opts are applied to the underlying Tree.
+// NewTypedTree panics if they include
+// WithCompactLeaves, since a compact tree
+// stores leaves of its own, not the typedLeaf.
+func NewTypedTree[V any](opts ...TreeOption) *TypedTree[V] {
+	t := NewArtTree(opts...)
+	if t.compact {
+		panic("uart: a TypedTree cannot have compact leaves")
+	}
+	return &TypedTree[V]{t: t}
+}
+
+// typed recovers the typedLeaf that contains lf.
+// lf must have been inserted by this TypedTree.
+func (tt *TypedTree[V]) typed(lf *Leaf) *typedLeaf[V] {
+	return (*typedLeaf[V])(unsafe.Pointer(lf))
+}
+
+// value returns the V held by lf, or the zero V if lf is nil.
+func (tt *TypedTree[V]) value(lf *Leaf) (v V) {
+	if lf == nil {
+		return
+	}
+	return tt.typed(lf).val
+}
+
+// SetSkipLocking sets the SkipLocking flag of
+// the underlying Tree. See Tree.SkipLocking.
+func (tt *TypedTree[V]) SetSkipLocking(skip bool) {
+	tt.t.SkipLocking = skip
+}
+
+// Size returns the number of keys stored in the tree.
+func (tt *TypedTree[V]) Size() int {
+	return tt.t.Size()
+}
+
+// IsEmpty returns true iff the tree is empty.
+func (tt *TypedTree[V]) IsEmpty() bool {
+	return tt.t.IsEmpty()
+}
+
+// Insert stores value under key, replacing any
+// previous value. Like Tree.Insert, the key is
+// copied. The returned updated flag is true
+// if the key was already present.
+func (tt *TypedTree[V]) Insert(key Key, value V) (updated bool) {
+	tl := &typedLeaf[V]{val: value}
+	tl.Key = Key(append([]byte{}, key...))
+	return tt.t.InsertLeaf(&tl.Leaf)
+}
+
+// Find does a GTE, GT, LTE, LT, or Exact search
+// just like Tree.Find, returning the key found,
+// its value, and its integer index.
+//
+// The returned key must not be modified.
+func (tt *TypedTree[V]) Find(smod SearchModifier, key Key) (foundKey Key, val V, idx int, found bool) {
+	var lf *Leaf
+	lf, idx, found = tt.t.Find(smod, key)
+	if found && lf != nil {
+		foundKey = lf.Key
+		val = tt.value(lf)
+	}
+	return
+}
+
+// FindExact returns the value stored under key.
+func (tt *TypedTree[V]) FindExact(key Key) (val V, idx int, found bool) {
+	_, val, idx, found = tt.Find(Exact, key)
+	return
+}
+
+// FindGTE returns the value of the first element
+// whose key is greater than, or equal to, key.
+func (tt *TypedTree[V]) FindGTE(key Key) (val V, idx int, found bool) {
+	_, val, idx, found = tt.Find(GTE, key)
+	return
+}
+
+// FindGT returns the value of the first element
+// whose key is strictly greater than key.
+func (tt *TypedTree[V]) FindGT(key Key) (val V, idx int, found bool) {
+	_, val, idx, found = tt.Find(GT, key)
+	return
+}
+
+// FindLTE returns the value of the last element
+// whose key is less than, or equal to, key.
+func (tt *TypedTree[V]) FindLTE(key Key) (val V, idx int, found bool) {
+	_, val, idx, found = tt.Find(LTE, key)
+	return
+}
+
+// FindLT returns the value of the last element
+// whose key is strictly less than key.
+func (tt *TypedTree[V]) FindLT(key Key) (val V, idx int, found bool) {
+	_, val, idx, found = tt.Find(LT, key)
+	return
+}
+
+// At returns the key and value of the i-th
+// element in sorted order. See Tree.At.
+func (tt *TypedTree[V]) At(i int) (key Key, val V, ok bool) {
+	var lf *Leaf
+	lf, ok = tt.t.At(i)
+	if ok {
+		key = lf.Key
+		val = tt.value(lf)
+	}
+	return
+}
+
+// Atv returns just the value of the i-th element.
+func (tt *TypedTree[V]) Atv(i int) (val V, ok bool) {
+	_, val, ok = tt.At(i)
+	return
+}
+
+// Atfar is like At but does not cache the traversal
+// point. See Tree.Atfar.
+func (tt *TypedTree[V]) Atfar(i int) (key Key, val V, ok bool) {
+	var lf *Leaf
+	lf, ok = tt.t.Atfar(i)
+	if ok {
+		key = lf.Key
+		val = tt.value(lf)
+	}
+	return
+}
+
+// Remove deletes key from the tree, returning
+// the value it held if it was present.
+func (tt *TypedTree[V]) Remove(key Key) (deleted bool, val V) {
+	var lf *Leaf
+	deleted, lf = tt.t.Remove(key)
+	if deleted {
+		val = tt.value(lf)
+	}
+	return
+}
+
+// Ascend iterates over [beg, endx) in ascending
+// order. See the package level Ascend function.
+func (tt *TypedTree[V]) Ascend(beg, endx Key) iter.Seq2[Key, V] {
+	return func(yield func(key Key, value V) bool) {
+		it := tt.t.Iter(beg, endx)
+		for it.Next() {
+			if !yield(it.Key(), tt.value(it.Leaf())) {
+				return
+			}
+		}
+	}
+}
+
+// Descend iterates over (endx, start] in descending
+// order. See the package level Descend function.
+func (tt *TypedTree[V]) Descend(endx, start Key) iter.Seq2[Key, V] {
+	return func(yield func(key Key, value V) bool) {
+		it := tt.t.RevIter(endx, start)
+		for it.Next() {
+			if !yield(it.Key(), tt.value(it.Leaf())) {
+				return
+			}
+		}
+	}
+}
+
+// Snapshot returns a frozen, read-only view of the
+// tree in O(1) time. See Tree.Snapshot.
+func (tt *TypedTree[V]) Snapshot() *TypedTree[V] {
+	return &TypedTree[V]{t: tt.t.Snapshot()}
+}
+
+// Release tells a snapshot that it will not be
+// read again. See Tree.Release.
+func (tt *TypedTree[V]) Release() {
+	tt.t.Release()
+}
+
+// Clone returns an independent copy of the tree,
+// with the same options. The values are copied
+// with ordinary assignment. As in Tree.Clone, the
+// copy is built bottom-up.
+func (tt *TypedTree[V]) Clone() *TypedTree[V] {
+	t := tt.t
+	if !t.SkipLocking {
+		rl := t.rlock()
+		defer rl.RUnlock()
+	}
+	leaves := make([]*Leaf, 0, t.size)
+	it := t.Iter(nil, nil)
+	for it.Next() {
+		tl := &typedLeaf[V]{val: tt.value(it.Leaf())}
+		tl.Key = append([]byte{}, it.Key()...)
+		leaves = append(leaves, &tl.Leaf)
+	}
+	return &TypedTree[V]{t: buildFromLeaves(leaves).finishFrom(t)}
+}
@@ -0,0 +1,127 @@
+package uart
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTypedTree_basics(t *testing.T) {
+	tt := NewTypedTree[int]()
+
+	n := 1000
+	for i := range n {
+		key := Key(fmt.Sprintf("%06d", i*2))
+		if tt.Insert(key, i) {
+			t.Fatalf("key %v should not have been present", string(key))
+		}
+	}
+	if got := tt.Size(); got != n {
+		t.Fatalf("want size %v, got %v", n, got)
+	}
+	if !tt.Insert(Key("000010"), -5) {
+		t.Fatalf("expected update")
+	}
+	v, idx, ok := tt.FindExact(Key("000010"))
+	if !ok || v != -5 || idx != 5 {
+		t.Fatalf("FindExact: v=%v idx=%v ok=%v", v, idx, ok)
+	}
+	tt.Insert(Key("000010"), 5)
+
+	// GTE on a missing (odd) key gives the next even key.
+	v, idx, ok = tt.FindGTE(Key("000011"))
+	if !ok || v != 6 || idx != 6 {
+		t.Fatalf("FindGTE: v=%v idx=%v ok=%v", v, idx, ok)
+	}
+	v, _, ok = tt.FindLT(Key("000011"))
+	if !ok || v != 5 {
+		t.Fatalf("FindLT: v=%v ok=%v", v, ok)
+	}
+	for i := range n {
+		key, v, ok := tt.At(i)
+		if !ok || v != i || string(key) != fmt.Sprintf("%06d", i*2) {
+			t.Fatalf("At(%v): key=%v v=%v ok=%v", i, string(key), v, ok)
+		}
+	}
+
+	i := 0
+	for key, v := range tt.Ascend(nil, nil) {
+		if v != i || string(key) != fmt.Sprintf("%06d", i*2) {
+			t.Fatalf("Ascend at %v: key=%v v=%v", i, string(key), v)
+		}
+		i++
+	}
+	if i != n {
+		t.Fatalf("Ascend saw %v, want %v", i, n)
+	}
+	i = n - 1
+	for _, v := range tt.Descend(nil, nil) {
+		if v != i {
+			t.Fatalf("Descend: want %v, got %v", i, v)
+		}
+		i--
+	}
+
+	c := tt.Clone()
+	for i := range n / 2 {
+		deleted, v := tt.Remove(Key(fmt.Sprintf("%06d", i*2)))
+		if !deleted || v != i {
+			t.Fatalf("Remove %v: deleted=%v v=%v", i, deleted, v)
+		}
+	}
+	if tt.Size() != n/2 || c.Size() != n {
+		t.Fatalf("sizes after remove: %v and clone %v", tt.Size(), c.Size())
+	}
+	if v, ok := c.Atv(0); !ok || v != 0 {
+		t.Fatalf("clone Atv(0) = %v, %v", v, ok)
+	}
+}
+
+type typedTestPoint struct {
+	x, y float64
+}
+
+func TestTypedTree_fewer_allocs(t *testing.T) {
+	keys := make([]Key, 1000)
+	for i := range keys {
+		keys[i] = Key(fmt.Sprintf("key-%09d", i))
+	}
+
+	untyped := testing.AllocsPerRun(10, func() {
+		tree := NewArtTree()
+		for i, k := range keys {
+			tree.Insert(k, typedTestPoint{x: float64(i)})
+		}
+	})
+	typed := testing.AllocsPerRun(10, func() {
+		tree := NewTypedTree[typedTestPoint]()
+		for i, k := range keys {
+			tree.Insert(k, typedTestPoint{x: float64(i)})
+		}
+	})
+	//vv("allocs per 1000 inserts: untyped %v, typed %v", untyped, typed)
+	if typed >= untyped-float64(len(keys))/2 {
+		t.Fatalf("expected TypedTree to save about one allocation per insert: untyped %v, typed %v", untyped, typed)
+	}
+}
+
+func TestTypedTree_Clone_keeps_options(t *testing.T) {
+	tt := NewTypedTree[int](WithSlabAllocator(), WithBuckets(8), WithDRWMutex())
+	n := 500
+	for i := range n {
+		tt.Insert(Key(fmt.Sprintf("%06d", i)), i)
+	}
+	c := tt.Clone()
+	if c.t.slab == nil || c.t.buckets != 8 || c.t.DRWmut == nil {
+		t.Fatalf("clone lost options: slab %v, buckets %v, DRWmut %v",
+			c.t.slab != nil, c.t.buckets, c.t.DRWmut != nil)
+	}
+	c.Insert(Key("zzz"), -1)
+	if c.Size() != n+1 || tt.Size() != n {
+		t.Fatalf("sizes: clone %v, source %v", c.Size(), tt.Size())
+	}
+	for i := range n {
+		if v, ok := c.Atv(i); !ok || v != i {
+			t.Fatalf("clone Atv(%v) = %v, %v", i, v, ok)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package uart
+
+// The read-modify-write methods below each take the
+// write lock once, and find the key and change it
+// in the same descent of the tree, rather than
+// a FindExact followed by an Insert. The leaf to
+// store is made only once the descent has found
+// that there is one to store, so that a LoadOrStore
+// that loads uses none of a slab allocator's space.
+
+// Update calls fn with the value of key, and whether
+// key is in the tree, and then stores the value fn
+// returns, if keep is true, or removes key if it
+// is false. fn is called exactly once, with the
+// tree write-locked, so it must not call the
+// methods of the tree.
+//
+// Storing, removing, or leaving the key absent
+// all take a single descent.
+func (t *Tree) Update(key Key, fn func(old any, exists bool) (newV any, keep bool)) {
+	t.wlockPath()
+	defer t.wunlock()
+
+	u := &upsert{decide: func(old *Leaf) (*Leaf, bool) {
+		var v any
+		var keep bool
+		if old == nil {
+			v, keep = fn(nil, false)
+		} else {
+			v, keep = fn(old.Value, true)
+		}
+		if !keep {
+			return nil, true
+		}
+		return t.newLeaf(key, v), false
+	}}
+	_, deleted := t.upsert_unlocked(&Leaf{Key: key}, u)
+	if u.stored != nil || deleted != nil {
+		t.treeVersion++
+	}
+}
+
+// CompareAndSwap stores new as the value of key
+// if key is in the tree with the value old, as
+// compared by ==, and reports whether it did.
+// As with sync.Map, old must be comparable.
+func (t *Tree) CompareAndSwap(key Key, old, new any) (swapped bool) {
+	t.wlockPath()
+	defer t.wunlock()
+
+	u := &upsert{decide: func(o *Leaf) (*Leaf, bool) {
+		if o == nil || o.Value != old {
+			return nil, false
+		}
+		return t.newLeaf(key, new), false
+	}}
+	t.upsert_unlocked(&Leaf{Key: key}, u)
+	if u.stored != nil {
+		t.treeVersion++
+	}
+	return u.stored != nil
+}
+
+// LoadOrStore returns the value of key, with
+// loaded true, if key is in the tree. Otherwise
+// it stores value for key, and returns it.
+func (t *Tree) LoadOrStore(key Key, value any) (actual any, loaded bool) {
+	t.wlockPath()
+	defer t.wunlock()
+
+	actual = value
+	u := &upsert{decide: func(old *Leaf) (*Leaf, bool) {
+		if old != nil {
+			actual, loaded = old.Value, true
+			return nil, false
+		}
+		return t.newLeaf(key, value), false
+	}}
+	t.upsert_unlocked(&Leaf{Key: key}, u)
+	if u.stored != nil {
+		t.treeVersion++
+	}
+	return
+}
+
+// LoadAndDelete removes key from the tree, and
+// returns the value it had, with loaded true,
+// if it was there.
+func (t *Tree) LoadAndDelete(key Key) (value any, loaded bool) {
+	deleted, lf := t.Remove(key)
+	if deleted {
+		return lf.Value, true
+	}
+	return nil, false
+}
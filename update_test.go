@@ -0,0 +1,166 @@
+package uart
+
+import (
+	"fmt"
+	mathrand2 "math/rand/v2"
+	"sync"
+	"testing"
+)
+
+func TestUpdate_ops_match_map(t *testing.T) {
+	rng := mathrand2.New(mathrand2.NewPCG(14, 14))
+	cow := NewArtTree()
+	snap := cow.Snapshot()
+	for _, tree := range []*Tree{NewArtTree(), NewArtTree(WithDRWMutex()), cow,
+		NewArtTree(WithCompactLeaves()), NewArtTree(WithBuckets(4)), NewArtTree(WithSlabAllocator())} {
+		ref := make(map[string]int)
+		for i := range 5000 {
+			// small alphabet: keys are often
+			// prefixes of one another.
+			k := make(Key, 1+rng.IntN(4))
+			for j := range k {
+				k[j] = byte('a' + rng.IntN(3))
+			}
+			old, exists := ref[string(k)]
+			switch rng.IntN(5) {
+			case 0:
+				// keep only even values.
+				tree.Update(k, func(o any, ex bool) (any, bool) {
+					if ex != exists || (ex && o != old) {
+						t.Fatalf("Update(%q) saw %v, %v; want %v, %v", k, o, ex, old, exists)
+					}
+					return i, i%2 == 0
+				})
+				if i%2 == 0 {
+					ref[string(k)] = i
+				} else {
+					delete(ref, string(k))
+				}
+			case 1:
+				guess := old
+				if rng.IntN(2) == 0 {
+					guess = -1
+				}
+				swapped := tree.CompareAndSwap(k, guess, i)
+				want := exists && guess == old
+				if swapped != want {
+					t.Fatalf("CompareAndSwap(%q, %v) = %v", k, guess, swapped)
+				}
+				if want {
+					ref[string(k)] = i
+				}
+			case 2, 3:
+				actual, loaded := tree.LoadOrStore(k, i)
+				if loaded != exists || (exists && actual != old) || (!exists && actual != i) {
+					t.Fatalf("LoadOrStore(%q) = %v, %v", k, actual, loaded)
+				}
+				if !exists {
+					ref[string(k)] = i
+				}
+			case 4:
+				v, loaded := tree.LoadAndDelete(k)
+				if loaded != exists || (exists && v != old) {
+					t.Fatalf("LoadAndDelete(%q) = %v, %v", k, v, loaded)
+				}
+				delete(ref, string(k))
+			}
+		}
+		checkTree(t, "after updates", tree, treeOf(ref), nil)
+	}
+	if snap.Size() != 0 {
+		t.Fatalf("snapshot changed")
+	}
+}
+
+// run with -race.
+func TestUpdate_concurrent_counters(t *testing.T) {
+	for _, tree := range []*Tree{NewArtTree(), NewArtTree(WithDRWMutex())} {
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 1000 {
+					k := Key(fmt.Sprint(i % 10))
+					if i%2 == 0 {
+						tree.Update(k, func(old any, exists bool) (any, bool) {
+							if !exists {
+								return 1, true
+							}
+							return old.(int) + 1, true
+						})
+						continue
+					}
+					for {
+						old, loaded := tree.LoadOrStore(k, 1)
+						if !loaded || tree.CompareAndSwap(k, old, old.(int)+1) {
+							break
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		total := 0
+		for _, lf := range Ascend(tree, nil, nil) {
+			total += lf.(*Leaf).Value.(int)
+		}
+		if total != 8000 || tree.Size() != 10 {
+			t.Fatalf("total %v, size %v", total, tree.Size())
+		}
+	}
+}
+
+func TestUpdate_events(t *testing.T) {
+	tree := NewArtTree()
+	w := tree.Watch(nil, nil)
+	defer w.Close()
+
+	tree.Update(Key("a"), func(any, bool) (any, bool) { return 1, true })
+	tree.Update(Key("b"), func(any, bool) (any, bool) { return 2, false })
+	tree.CompareAndSwap(Key("a"), 0, 3) // no match
+	tree.CompareAndSwap(Key("a"), 1, 4)
+	tree.LoadOrStore(Key("a"), 5) // loaded
+	tree.LoadOrStore(Key("c"), 6)
+	tree.Update(Key("c"), func(any, bool) (any, bool) { return nil, false })
+	tree.LoadAndDelete(Key("a"))
+
+	for _, s := range []string{
+		"KeyInserted a 1", "KeyUpdated a 4", "KeyInserted c 6",
+		"KeyRemoved c 6", "KeyRemoved a 4",
+	} {
+		ev := nextEvent(t, w)
+		if got := fmt.Sprintf("%v %s %v", ev.Kind, ev.Key, ev.Value); got != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+	if tree.Size() != 0 {
+		t.Fatalf("size %v", tree.Size())
+	}
+}
+
+// A LoadOrStore that loads, or a CompareAndSwap
+// that does not swap, takes no slab space.
+func TestUpdate_no_slab_use_without_store(t *testing.T) {
+	tree := NewArtTree(WithSlabAllocator())
+	for i := range 100 {
+		tree.Insert(Key(fmt.Sprintf("key%03d", i)), i)
+	}
+	keys, leaves := len(tree.slab.keys), len(tree.slab.leaves)
+	for i := range 100 {
+		k := Key(fmt.Sprintf("key%03d", i))
+		if _, loaded := tree.LoadOrStore(k, -1); !loaded {
+			t.Fatalf("LoadOrStore(%s) stored", k)
+		}
+		if tree.CompareAndSwap(k, -1, -2) {
+			t.Fatalf("CompareAndSwap(%s) swapped", k)
+		}
+		tree.Update(Key(fmt.Sprintf("nokey%03d", i)), func(any, bool) (any, bool) {
+			return nil, false
+		})
+	}
+	if len(tree.slab.keys) != keys || len(tree.slab.leaves) != leaves {
+		t.Fatalf("slab space used: keys %v -> %v, leaves %v -> %v",
+			keys, len(tree.slab.keys), leaves, len(tree.slab.leaves))
+	}
+}
@@ -0,0 +1,335 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// EventKind says what a write did to a key.
+type EventKind uint8
+
+const (
+	// KeyInserted: the key was not in the tree before.
+	KeyInserted EventKind = iota + 1
+
+	// KeyUpdated: the key was given a new value.
+	KeyUpdated
+
+	// KeyRemoved: the key was removed, by Remove,
+	// DeleteRange, DeletePrefix, or ReadFrom.
+	KeyRemoved
+
+	// EventsLost: the Watcher fell behind, and
+	// Event.Lost events were dropped here.
+	EventsLost
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case KeyInserted:
+		return "KeyInserted"
+	case KeyUpdated:
+		return "KeyUpdated"
+	case KeyRemoved:
+		return "KeyRemoved"
+	case EventsLost:
+		return "EventsLost"
+	}
+	return fmt.Sprintf("EventKind(%v)", uint8(k))
+}
+
+// Event tells a Watcher about one change to one key.
+type Event struct {
+	Kind EventKind
+
+	// Key is the key changed. It is shared
+	// with the tree, and must not be modified.
+	Key Key
+
+	// Value is the new value for KeyInserted and
+	// KeyUpdated, and the value removed for KeyRemoved.
+	Value any
+
+	// Lost counts the events dropped, for EventsLost.
+	Lost int
+}
+
+// defaultWatchBuffer is the number of events a Watcher
+// holds for a slow subscriber, unless WatchBuffer says otherwise.
+const defaultWatchBuffer = 1024
+
+// WatchOption configures a Watcher in Tree.Watch.
+type WatchOption func(w *Watcher)
+
+// WatchBuffer returns a WatchOption that lets the
+// Watcher hold up to n undelivered events.
+func WatchBuffer(n int) WatchOption {
+	return func(w *Watcher) {
+		w.max = max(1, n)
+	}
+}
+
+// WatchFunc returns a WatchOption that has the
+// Watcher call fn with each event, instead of
+// sending it on Watcher.C. fn is called from a
+// goroutine of its own, one event at a time;
+// while it runs, more events are buffered.
+func WatchFunc(fn func(Event)) WatchOption {
+	return func(w *Watcher) {
+		w.fn = fn
+	}
+}
+
+// Watcher delivers the changes made to a range of
+// keys in a Tree. See Tree.Watch.
+type Watcher struct {
+	// C receives the events, in the order the
+	// writes happened. It is closed by Close.
+	// C is nil if WatchFunc was given.
+	C <-chan Event
+	c chan Event
+
+	tree       *Tree
+	start, end Key
+	fn         func(Event)
+	max        int
+
+	mu     sync.Mutex
+	cond   sync.Cond
+	queue  []Event // undelivered events are queue[head:]
+	head   int
+	closed bool
+	done   chan struct{}
+}
+
+// Watch returns a Watcher that reports every insert,
+// update, and remove of a key k with start <= k < end,
+// the same keys that Iter(start, end) would visit. A nil
+// start or end leaves that side unbounded.
+//
+// Events are handed to the Watcher once the write
+// that made them is complete and the tree is unlocked,
+// in the order the writes happened. Writers never wait
+// for a Watcher: each one buffers the events that its
+// subscriber has not yet taken (1024 by default; see
+// WatchBuffer), and drops events when the buffer is
+// full. In their place the subscriber gets an
+// EventsLost event saying how many were dropped,
+// after which it should re-read the range if it needs
+// to catch up. The events are sent on Watcher.C, or
+// passed to the WatchFunc callback if one was given.
+//
+// DeleteRange removes whole subtrees without visiting
+// their keys; while any Watcher is open it must visit
+// the removed keys that fall within the span of the
+// watched ranges, to report them. Watchers are not
+// told about changes made through a TypedTree, or to
+// Leaf.Value in place, which the tree cannot see.
+//
+// Call Close when done with the Watcher.
+func (t *Tree) Watch(start, end Key, opts ...WatchOption) *Watcher {
+	w := &Watcher{
+		tree:  t,
+		max:   defaultWatchBuffer,
+		start: copyKey(start),
+		end:   copyKey(end),
+		done:  make(chan struct{}),
+	}
+	w.cond.L = &w.mu
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.fn == nil {
+		w.c = make(chan Event)
+		w.C = w.c
+	}
+	go w.pump()
+
+	t.watchMu.Lock()
+	var ws []*Watcher
+	if p := t.watchers.Load(); p != nil {
+		ws = append(ws, *p...)
+	}
+	ws = append(ws, w)
+	t.watchers.Store(&ws)
+	t.watchMu.Unlock()
+	return w
+}
+
+// Close stops the Watcher. Events not yet delivered
+// are discarded, and C is closed. Close may be
+// called from within the WatchFunc callback.
+func (w *Watcher) Close() {
+	t := w.tree
+	t.watchMu.Lock()
+	if p := t.watchers.Load(); p != nil {
+		var ws []*Watcher
+		for _, x := range *p {
+			if x != w {
+				ws = append(ws, x)
+			}
+		}
+		t.watchers.Store(&ws)
+	}
+	t.watchMu.Unlock()
+
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		w.queue = nil
+		w.head = 0
+		close(w.done)
+		w.cond.Broadcast()
+	}
+	w.mu.Unlock()
+}
+
+// copyKey returns a copy of key, keeping nil as nil.
+func copyKey(key Key) Key {
+	if key == nil {
+		return nil
+	}
+	return append(Key{}, key...)
+}
+
+// watched returns true if t has any open Watchers.
+// Writers call it, holding the write lock, to see
+// if they need to record events.
+func (t *Tree) watched() bool {
+	p := t.watchers.Load()
+	return p != nil && len(*p) > 0
+}
+
+// note records an event for the write in progress.
+func (t *Tree) note(kind EventKind, lf *Leaf) {
+	t.pending = append(t.pending, Event{Kind: kind, Key: lf.Key, Value: lf.Value})
+}
+
+// noteRange records an event of the given kind
+// for each key k with start <= k < end in the subtree
+// root, which holds size keys, and which the
+// write in progress is removing or adding whole.
+// Only the keys within the span of the watched
+// ranges are visited.
+func (t *Tree) noteRange(root *bnode, size int64, start, end Key, kind EventKind) {
+	if root == nil {
+		return
+	}
+	// the span covering every watched range.
+	var lo, hi Key
+	for i, w := range *t.watchers.Load() {
+		if i == 0 || w.start == nil || (lo != nil && bytes.Compare(w.start, lo) < 0) {
+			lo = w.start
+		}
+		if i == 0 || w.end == nil || (hi != nil && bytes.Compare(w.end, hi) > 0) {
+			hi = w.end
+		}
+		if lo == nil && hi == nil {
+			break
+		}
+	}
+	if start == nil || (lo != nil && bytes.Compare(lo, start) > 0) {
+		start = lo
+	}
+	if end == nil || (hi != nil && bytes.Compare(hi, end) < 0) {
+		end = hi
+	}
+	it := (&Tree{root: root, size: size, SkipLocking: true, compact: t.compact, buckets: t.buckets}).Iter(start, end)
+	for it.Next() {
+		t.note(kind, it.Leaf())
+	}
+}
+
+// deliver ends a write that has events to report:
+// it unlocks t, and then hands the events to the
+// Watchers. watchMu is taken before the unlock, so
+// that the next writer's events cannot overtake ours.
+func (t *Tree) deliver() {
+	evs := t.pending
+	t.pending = nil
+	t.watchMu.Lock()
+	defer t.watchMu.Unlock()
+	if !t.SkipLocking {
+		t.unlock()
+	}
+	p := t.watchers.Load()
+	if p == nil {
+		return
+	}
+	for _, w := range *p {
+		w.mu.Lock()
+		for _, ev := range evs {
+			if inRange(ev.Key, w.start, w.end) {
+				w.push(ev)
+			}
+		}
+		w.cond.Signal()
+		w.mu.Unlock()
+	}
+}
+
+// push queues ev for delivery, or counts it as lost
+// if the queue is full. The caller holds w.mu.
+func (w *Watcher) push(ev Event) {
+	if w.closed {
+		return
+	}
+	if len(w.queue)-w.head < w.max {
+		if w.head > 0 && len(w.queue) == cap(w.queue) {
+			// slide down rather than grow.
+			n := copy(w.queue, w.queue[w.head:])
+			clear(w.queue[n:])
+			w.queue = w.queue[:n]
+			w.head = 0
+		}
+		w.queue = append(w.queue, ev)
+		return
+	}
+	// full: the EventsLost that stands for the dropped
+	// events goes past the end of the queue, and keeps
+	// counting until the subscriber makes room.
+	if last := &w.queue[len(w.queue)-1]; last.Kind == EventsLost {
+		last.Lost++
+		return
+	}
+	w.queue = append(w.queue, Event{Kind: EventsLost, Lost: 1})
+}
+
+// pump runs in a goroutine of its own, handing
+// the queued events to the subscriber.
+func (w *Watcher) pump() {
+	for {
+		w.mu.Lock()
+		for w.head == len(w.queue) && !w.closed {
+			w.cond.Wait()
+		}
+		if w.closed {
+			w.mu.Unlock()
+			if w.c != nil {
+				close(w.c)
+			}
+			return
+		}
+		ev := w.queue[w.head]
+		w.queue[w.head] = Event{}
+		w.head++
+		if w.head == len(w.queue) {
+			// empty: start again at the front.
+			w.queue = w.queue[:0]
+			w.head = 0
+		}
+		w.mu.Unlock()
+
+		if w.fn != nil {
+			w.fn(ev)
+			continue
+		}
+		select {
+		case w.c <- ev:
+		case <-w.done:
+			close(w.c)
+			return
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package uart
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event from w, failing
+// the test if none comes soon.
+func nextEvent(t *testing.T, w *Watcher) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-w.C:
+		if !ok {
+			t.Fatalf("Watcher.C closed")
+		}
+		return ev
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no event")
+	}
+	return Event{}
+}
+
+func TestWatch_events(t *testing.T) {
+	tree := NewArtTree()
+	w := tree.Watch(Key("b"), Key("d"))
+	defer w.Close()
+
+	tree.Insert(Key("a"), 1) // outside
+	tree.Insert(Key("b"), 2)
+	tree.Insert(Key("c"), 3)
+	tree.Insert(Key("b"), 4)
+	tree.Remove(Key("c"))
+	tree.Remove(Key("zz"))   // not there
+	tree.Insert(Key("d"), 5) // outside
+	tree.Insert(Key("c2"), 6)
+	tree.DeleteRange(Key("a"), Key("c3"))
+
+	want := []string{
+		"KeyInserted b 2",
+		"KeyInserted c 3",
+		"KeyUpdated b 4",
+		"KeyRemoved c 3",
+		"KeyInserted c2 6",
+		"KeyRemoved b 4",
+		"KeyRemoved c2 6",
+	}
+	for i, s := range want {
+		ev := nextEvent(t, w)
+		if got := fmt.Sprintf("%v %s %v", ev.Kind, ev.Key, ev.Value); got != s {
+			t.Fatalf("event %v: got %q, want %q", i, got, s)
+		}
+	}
+
+	// ReadFrom replaces everything.
+	src := NewArtTree()
+	src.Insert(Key("bb"), []byte("x"))
+	src.Insert(Key("e"), []byte("y"))
+	var buf bytes.Buffer
+	if _, err := src.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	tree.Insert(Key("b"), []byte("old"))
+	if _, err := tree.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"KeyInserted b old", "KeyRemoved b old", "KeyInserted bb x"} {
+		ev := nextEvent(t, w)
+		if got := fmt.Sprintf("%v %s %s", ev.Kind, ev.Key, ev.Value); got != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+
+	w.Close()
+	for range w.C {
+	}
+	tree.Insert(Key("b"), 7) // no one to tell
+}
+
+func TestWatch_lost_events(t *testing.T) {
+	tree := NewArtTree()
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var got []Event
+	done := make(chan struct{})
+	n := 100
+	w := tree.Watch(nil, nil, WatchBuffer(4), WatchFunc(func(ev Event) {
+		<-release
+		mu.Lock()
+		got = append(got, ev)
+		seen := 0
+		for _, e := range got {
+			seen += max(1, e.Lost)
+		}
+		mu.Unlock()
+		if seen == n {
+			close(done)
+		}
+	}))
+	defer w.Close()
+
+	for i := range n {
+		tree.Insert(Key(fmt.Sprintf("%03d", i)), i)
+	}
+	close(release)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	lost := 0
+	prev := -1
+	for _, ev := range got {
+		switch ev.Kind {
+		case EventsLost:
+			lost += ev.Lost
+			prev += ev.Lost
+		case KeyInserted:
+			if v := ev.Value.(int); v != prev+1 {
+				t.Fatalf("got %v after %v", v, prev)
+			}
+			prev++
+		default:
+			t.Fatalf("unexpected %v", ev.Kind)
+		}
+	}
+	if lost == 0 || len(got) > 4+2 {
+		t.Fatalf("lost %v of %v, in %v events", lost, n, len(got))
+	}
+}
+
+// run with -race. The events, replayed in order,
+// must give the same contents as the tree.
+func TestWatch_concurrent_writers(t *testing.T) {
+	for _, tree := range []*Tree{NewArtTree(), NewArtTree(WithDRWMutex())} {
+		w := tree.Watch(Key("k"), nil, WatchBuffer(1<<20))
+		model := make(map[string]any)
+		replayed := make(chan struct{})
+		go func() {
+			defer close(replayed)
+			for ev := range w.C {
+				if string(ev.Key) == "kz" {
+					return
+				}
+				switch ev.Kind {
+				case KeyInserted, KeyUpdated:
+					model[string(ev.Key)] = ev.Value
+				case KeyRemoved:
+					delete(model, string(ev.Key))
+				case EventsLost:
+					panic("lost events")
+				}
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for g := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 2000 {
+					k := Key(fmt.Sprintf("k%03d", (i*7+g)%300))
+					switch i % 5 {
+					case 3:
+						tree.Remove(k)
+					case 4:
+						if i%100 == 4 {
+							tree.DeletePrefix(k[:2])
+						}
+					default:
+						tree.Insert(k, g*10000+i)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		// a last write, to know when the replay is done.
+		tree.Insert(Key("kz"), "end")
+		<-replayed
+		w.Close()
+		tree.Remove(Key("kz"))
+
+		n := 0
+		for key, lf := range Ascend(tree, nil, nil) {
+			n++
+			if model[string(key)] != lf.(*Leaf).Value {
+				t.Fatalf("%q is %v, events say %v", key, lf.(*Leaf).Value, model[string(key)])
+			}
+		}
+		if n != len(model) {
+			t.Fatalf("tree has %v keys, events %v", n, len(model))
+		}
+	}
+}
+
+func TestWatch_Close_from_callback(t *testing.T) {
+	tree := NewArtTree()
+	var w *Watcher
+	calls := 0
+	closed := make(chan struct{})
+	w = tree.Watch(nil, nil, WatchFunc(func(ev Event) {
+		calls++
+		w.Close()
+		close(closed)
+	}))
+	tree.Insert(Key("a"), 1)
+	<-closed
+	tree.Insert(Key("b"), 2)
+	tree.Insert(Key("c"), 3)
+	time.Sleep(10 * time.Millisecond)
+	if calls != 1 {
+		t.Fatalf("callback ran %v times after Close", calls)
+	}
+}